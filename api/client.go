@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Time a call to the API of a node may take.
+const clientTimeout = 30 * time.Second
+
+// Client calls the JSON-RPC API of a running node.
+type Client struct {
+	url    string
+	http   *http.Client
+	nextID atomic.Int64
+}
+
+// NewClient creates a client for the API of the node listening on the address (host:port, or a URL).
+func NewClient(address string) *Client {
+	url := address
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+
+	return &Client{
+		url:  strings.TrimSuffix(url, "/") + "/rpc",
+		http: &http.Client{Timeout: clientTimeout},
+	}
+}
+
+// Call runs the method with the params (by position) and decodes its result into result, unless it is nil.
+// Errors the node answers with are returned as *Error.
+func (client *Client) Call(method string, result any, params ...any) error {
+	if params == nil {
+		params = []any{}
+	}
+
+	encodedParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	id, _ := json.Marshal(client.nextID.Add(1))
+	body, err := json.Marshal(Request{JSONRPC: "2.0", Method: method, Params: encodedParams, ID: id})
+	if err != nil {
+		return err
+	}
+
+	reply, err := client.http.Post(client.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered with %s", client.url, reply.Status)
+	}
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+	if err := json.NewDecoder(reply.Body).Decode(&response); err != nil {
+		return fmt.Errorf("invalid response from %s: %v", client.url, err)
+	}
+
+	if response.Error != nil {
+		return response.Error
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(response.Result, result)
+}
+
+// IsNotFound checks if the node answered that the block or transaction asked for does not exist.
+func IsNotFound(err error) bool {
+	var rpcErr *Error
+	return errors.As(err, &rpcErr) && rpcErr.Code == codeNotFound
+}
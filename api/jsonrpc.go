@@ -0,0 +1,393 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+)
+
+// Largest request body the JSON-RPC endpoint reads.
+const maxRequestBytes = 1 << 20
+
+// Error codes of JSON-RPC 2.0, and the ones of this API (-32000 to -32099 are left to the server).
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+	codeNotFound       = -32001
+	codeRejected       = -32002
+	codeRateLimited    = -32003
+)
+
+// Shortest time between two chain_verify calls. Verifying checks every block of the chain again,
+// so a client calling it in a loop would keep the node busy.
+const minVerifyInterval = 10 * time.Second
+
+// Error is the error object of a JSON-RPC 2.0 response.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", err.Message, err.Code)
+}
+
+func invalidParams(err error) *Error {
+	return &Error{Code: codeInvalidParams, Message: "invalid params: " + err.Error()}
+}
+
+// Request is a JSON-RPC 2.0 request. A request without an ID is a notification and gets no response.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// Response is a JSON-RPC 2.0 response. Error is set if the call failed, Result otherwise (it may be nil).
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  any             `json:"result"`
+	Error   *Error          `json:"error"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// MarshalJSON writes result if the call succeeded, even when it is null, and error if it failed, never both.
+func (response Response) MarshalJSON() ([]byte, error) {
+	if response.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			Error   *Error          `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{response.JSONRPC, response.Error, response.ID})
+	}
+
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  any             `json:"result"`
+		ID      json.RawMessage `json:"id"`
+	}{response.JSONRPC, response.Result, response.ID})
+}
+
+// A method of the API. Gets the raw params of the request.
+type method func(params json.RawMessage) (any, error)
+
+// JSONRPCServer answers JSON-RPC 2.0 requests (single or batched) about a node, sent as HTTP POST.
+// Methods:
+//
+//	chain_head								root of the canonical chain
+//	chain_getBlockByHash	[hash]			block with the hex hash, canonical or not
+//	chain_getBlockByHeight	[height]		block of the canonical chain at the height
+//	chain_verify							checks every block of the canonical chain again (at most once every minVerifyInterval)
+//	tx_submit				[transaction]	adds a signed transaction (a TransactionView) to the mempool, it is relayed in the background
+//	tx_get					[hash]			transaction from the canonical chain or the mempool
+//	mempool_list							pending transactions, oldest first
+//	net_peers								addresses the node knows and the state of its connection to each
+//
+// Params are given either by position or by name ({"hash": "..."}).
+type JSONRPCServer struct {
+	node    *blockchain.Node
+	methods map[string]method
+
+	lastVerify  time.Time // when chain_verify was last run
+	verifyMutex sync.Mutex
+}
+
+// NewJSONRPCServer creates the JSON-RPC server of the node.
+func NewJSONRPCServer(node *blockchain.Node) *JSONRPCServer {
+	server := &JSONRPCServer{node: node}
+	server.methods = map[string]method{
+		"chain_head":             server.chainHead,
+		"chain_getBlockByHash":   server.chainGetBlockByHash,
+		"chain_getBlockByHeight": server.chainGetBlockByHeight,
+		"chain_verify":           server.chainVerify,
+		"tx_submit":              server.txSubmit,
+		"tx_get":                 server.txGet,
+		"mempool_list":           server.mempoolList,
+		"net_peers":              server.netPeers,
+	}
+
+	return server
+}
+
+func (server *JSONRPCServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		http.Error(writer, "JSON-RPC requests must be sent with POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxRequestBytes+1))
+	if err != nil || len(body) > maxRequestBytes {
+		writeJSON(writer, http.StatusOK, errorResponse(nil, &Error{Code: codeInvalidRequest, Message: "request body is too large or unreadable"}))
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+
+	// a batch is an array of requests and is answered with an array of responses
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeJSON(writer, http.StatusOK, errorResponse(nil, &Error{Code: codeParseError, Message: "parse error: " + err.Error()}))
+			return
+		}
+
+		if len(batch) == 0 {
+			writeJSON(writer, http.StatusOK, errorResponse(nil, &Error{Code: codeInvalidRequest, Message: "empty batch"}))
+			return
+		}
+
+		responses := []*Response{}
+		for _, raw := range batch {
+			if response := server.handle(raw); response != nil {
+				responses = append(responses, response)
+			}
+		}
+
+		if len(responses) == 0 {
+			writer.WriteHeader(http.StatusNoContent) // only notifications
+			return
+		}
+
+		writeJSON(writer, http.StatusOK, responses)
+		return
+	}
+
+	response := server.handle(body)
+	if response == nil {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, response)
+}
+
+// Runs one request. Returns nil for notifications.
+func (server *JSONRPCServer) handle(raw json.RawMessage) *Response {
+	var request Request
+	if err := json.Unmarshal(raw, &request); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return errorResponse(nil, &Error{Code: codeParseError, Message: "parse error: " + err.Error()})
+		}
+		return errorResponse(nil, &Error{Code: codeInvalidRequest, Message: "invalid request: " + err.Error()})
+	}
+
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		return errorResponse(request.ID, &Error{Code: codeInvalidRequest, Message: `invalid request: jsonrpc must be "2.0" and method must be set`})
+	}
+
+	call, ok := server.methods[request.Method]
+	if !ok {
+		return responseFor(request, nil, &Error{Code: codeMethodNotFound, Message: "method not found: " + request.Method})
+	}
+
+	result, err := call(request.Params)
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: codeInternalError, Message: err.Error()}
+		}
+		return responseFor(request, nil, rpcErr)
+	}
+
+	return responseFor(request, result, nil)
+}
+
+// Returns the response to the request, or nil if the request is a notification.
+func responseFor(request Request, result any, err *Error) *Response {
+	if len(request.ID) == 0 {
+		return nil
+	}
+
+	if err != nil {
+		return errorResponse(request.ID, err)
+	}
+
+	return &Response{JSONRPC: "2.0", Result: result, ID: request.ID}
+}
+
+func errorResponse(id json.RawMessage, err *Error) *Response {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	return &Response{JSONRPC: "2.0", Error: err, ID: id}
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(value)
+}
+
+// Decodes params given by position (in the order of names) or by name into target, a pointer to a struct with json tags.
+// Missing or null params leave target as it is.
+func decodeParams(raw json.RawMessage, target any, names ...string) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil
+	}
+
+	if raw[0] == '[' {
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return err
+		}
+
+		if len(list) > len(names) {
+			return fmt.Errorf("expected at most %d params, got %d", len(names), len(list))
+		}
+
+		named := make(map[string]json.RawMessage, len(list))
+		for i, value := range list {
+			named[names[i]] = value
+		}
+
+		var err error
+		if raw, err = json.Marshal(named); err != nil {
+			return err
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(target)
+}
+
+// Decodes a hex hash param.
+func decodeHash(value string) ([]byte, error) {
+	hash, err := hex.DecodeString(value)
+	if err != nil || len(hash) == 0 {
+		return nil, errors.New("hash must be a non-empty hex string")
+	}
+
+	return hash, nil
+}
+
+func (server *JSONRPCServer) chainHead(params json.RawMessage) (any, error) {
+	return NewHeadView(server.node.LocalChain), nil
+}
+
+func (server *JSONRPCServer) chainGetBlockByHash(params json.RawMessage) (any, error) {
+	var args struct {
+		Hash string `json:"hash"`
+	}
+	if err := decodeParams(params, &args, "hash"); err != nil {
+		return nil, invalidParams(err)
+	}
+
+	hash, err := decodeHash(args.Hash)
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+
+	block := server.node.LocalChain.GetBlock(hash)
+	if block == nil {
+		return nil, &Error{Code: codeNotFound, Message: "block not found"}
+	}
+
+	return NewBlockView(server.node.LocalChain, block), nil
+}
+
+func (server *JSONRPCServer) chainGetBlockByHeight(params json.RawMessage) (any, error) {
+	var args struct {
+		Height *int `json:"height"`
+	}
+	if err := decodeParams(params, &args, "height"); err != nil {
+		return nil, invalidParams(err)
+	}
+
+	if args.Height == nil {
+		return nil, invalidParams(errors.New("height must be set"))
+	}
+
+	block := server.node.LocalChain.GetBlockByHeight(*args.Height)
+	if block == nil {
+		return nil, &Error{Code: codeNotFound, Message: "no block at that height"}
+	}
+
+	return NewBlockView(server.node.LocalChain, block), nil
+}
+
+func (server *JSONRPCServer) chainVerify(params json.RawMessage) (any, error) {
+	server.verifyMutex.Lock()
+	wait := minVerifyInterval - time.Since(server.lastVerify)
+	if wait > 0 {
+		server.verifyMutex.Unlock()
+		return nil, &Error{Code: codeRateLimited, Message: fmt.Sprintf("chain was verified less than %v ago, try again in %v", minVerifyInterval, wait.Round(time.Second))}
+	}
+	server.lastVerify = time.Now()
+	server.verifyMutex.Unlock()
+
+	return NewVerifyView(server.node.LocalChain), nil
+}
+
+func (server *JSONRPCServer) txSubmit(params json.RawMessage) (any, error) {
+	var args struct {
+		Transaction *TransactionView `json:"transaction"`
+	}
+	if err := decodeParams(params, &args, "transaction"); err != nil {
+		return nil, invalidParams(err)
+	}
+
+	if args.Transaction == nil {
+		return nil, invalidParams(errors.New("transaction must be set"))
+	}
+
+	transaction, err := args.Transaction.Transaction()
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+
+	if err := server.node.SubmitTransaction(transaction); err != nil {
+		return nil, &Error{Code: codeRejected, Message: "transaction rejected: " + err.Error()}
+	}
+
+	view := NewTransactionView(transaction)
+	view.Pending = true
+
+	return view, nil
+}
+
+func (server *JSONRPCServer) txGet(params json.RawMessage) (any, error) {
+	var args struct {
+		Hash string `json:"hash"`
+	}
+	if err := decodeParams(params, &args, "hash"); err != nil {
+		return nil, invalidParams(err)
+	}
+
+	hash, err := decodeHash(args.Hash)
+	if err != nil {
+		return nil, invalidParams(err)
+	}
+
+	view, ok := findTransaction(server.node, hash)
+	if !ok {
+		return nil, &Error{Code: codeNotFound, Message: "transaction not found"}
+	}
+
+	return view, nil
+}
+
+func (server *JSONRPCServer) mempoolList(params json.RawMessage) (any, error) {
+	return mempoolViews(server.node), nil
+}
+
+func (server *JSONRPCServer) netPeers(params json.RawMessage) (any, error) {
+	return NewPeerViews(server.node), nil
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+)
+
+// Creates a node with an in-memory chain and an identity key, like the node command does.
+func newTestNode(t *testing.T) *blockchain.Node {
+	chain, err := blockchain.NewBlockChain(blockchain.DefaultGenesis(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, key, err := blockchain.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := blockchain.MakeNode(0)
+	node.Key = key
+	node.SetLocalChain(chain)
+
+	return node
+}
+
+// Posts the body to the JSON-RPC server and returns the status and the body of the answer.
+func postRPC(t *testing.T, server http.Handler, body string) (int, string) {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body)))
+
+	return recorder.Code, recorder.Body.String()
+}
+
+// A successful call always has a result, even a null one, and a failed call only an error.
+func TestResponseMembers(t *testing.T) {
+	tests := []struct {
+		name     string
+		response Response
+		want     string
+	}{
+		{"null result", Response{JSONRPC: "2.0", ID: json.RawMessage("1")}, `{"jsonrpc":"2.0","result":null,"id":1}`},
+		{"empty result", Response{JSONRPC: "2.0", Result: []string{}, ID: json.RawMessage("2")}, `{"jsonrpc":"2.0","result":[],"id":2}`},
+		{"error", Response{JSONRPC: "2.0", Error: &Error{Code: codeNotFound, Message: "block not found"}, ID: json.RawMessage("3")},
+			`{"jsonrpc":"2.0","error":{"code":-32001,"message":"block not found"},"id":3}`},
+		{"error with a result", Response{JSONRPC: "2.0", Result: "ignored", Error: &Error{Code: codeInternalError, Message: "failed"}, ID: json.RawMessage("4")},
+			`{"jsonrpc":"2.0","error":{"code":-32603,"message":"failed"},"id":4}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := json.Marshal(&test.response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != test.want {
+				t.Fatalf("response is %s, want %s", data, test.want)
+			}
+		})
+	}
+}
+
+// chain_verify runs at most once every minVerifyInterval.
+func TestChainVerifyRateLimited(t *testing.T) {
+	server := NewJSONRPCServer(newTestNode(t))
+	request := `{"jsonrpc":"2.0","method":"chain_verify","id":1}`
+
+	_, body := postRPC(t, server, request)
+	var first struct {
+		Result VerifyView `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(body), &first); err != nil || !first.Result.Valid {
+		t.Fatalf("first verification answered %s", body)
+	}
+
+	_, body = postRPC(t, server, request)
+	var second struct {
+		Error *Error `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &second); err != nil || second.Error == nil || second.Error.Code != codeRateLimited {
+		t.Fatalf("second verification right after the first answered %s", body)
+	}
+}
+
+// Decodes a single response into its raw members, so tests can tell a null member from a missing one.
+func decodeMembers(t *testing.T, body string) map[string]json.RawMessage {
+	t.Helper()
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &members); err != nil {
+		t.Fatalf("response %s: %v", body, err)
+	}
+
+	return members
+}
+
+// Checks that the response is an error with the code, for the request with the ID.
+func checkErrorResponse(t *testing.T, members map[string]json.RawMessage, code int, id string) {
+	t.Helper()
+
+	if _, ok := members["result"]; ok {
+		t.Fatalf("error response has a result: %v", members)
+	}
+
+	var rpcErr Error
+	if err := json.Unmarshal(members["error"], &rpcErr); err != nil || rpcErr.Code != code {
+		t.Fatalf("error is %s, want code %d", members["error"], code)
+	}
+
+	if string(members["id"]) != id {
+		t.Fatalf("id is %s, want %s", members["id"], id)
+	}
+}
+
+func TestJSONRPCErrors(t *testing.T) {
+	server := NewJSONRPCServer(newTestNode(t))
+
+	tests := []struct {
+		name string
+		body string
+		code int
+		id   string
+	}{
+		{"broken JSON", `{"jsonrpc":"2.0",`, codeParseError, "null"},
+		{"broken JSON in a batch", `[{"jsonrpc":"2.0"},`, codeParseError, "null"},
+		{"not an object", `"chain_head"`, codeInvalidRequest, "null"},
+		{"wrong version", `{"jsonrpc":"1.0","method":"chain_head","id":1}`, codeInvalidRequest, "1"},
+		{"no method", `{"jsonrpc":"2.0","id":2}`, codeInvalidRequest, "2"},
+		{"empty batch", `[]`, codeInvalidRequest, "null"},
+		{"unknown method", `{"jsonrpc":"2.0","method":"chain_mine","id":3}`, codeMethodNotFound, "3"},
+		{"hash that is not hex", `{"jsonrpc":"2.0","method":"chain_getBlockByHash","params":["xyz"],"id":4}`, codeInvalidParams, "4"},
+		{"missing height", `{"jsonrpc":"2.0","method":"chain_getBlockByHeight","params":{},"id":5}`, codeInvalidParams, "5"},
+		{"unknown param name", `{"jsonrpc":"2.0","method":"tx_get","params":{"hsh":"00"},"id":6}`, codeInvalidParams, "6"},
+		{"too many params", `{"jsonrpc":"2.0","method":"tx_get","params":["00","01"],"id":7}`, codeInvalidParams, "7"},
+		{"param of the wrong type", `{"jsonrpc":"2.0","method":"chain_getBlockByHeight","params":["one"],"id":"eight"}`, codeInvalidParams, `"eight"`},
+		{"transaction the mempool rejects", `{"jsonrpc":"2.0","method":"tx_submit","params":[{"sender":"a","recipient":"b","data":"c"}],"id":9}`, codeRejected, "9"},
+		{"block that does not exist", `{"jsonrpc":"2.0","method":"chain_getBlockByHeight","params":[99],"id":10}`, codeNotFound, "10"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := postRPC(t, server, test.body)
+			if status != http.StatusOK {
+				t.Fatalf("status %d, want %d", status, http.StatusOK)
+			}
+
+			checkErrorResponse(t, decodeMembers(t, body), test.code, test.id)
+		})
+	}
+}
+
+// Notifications are run but not answered.
+func TestJSONRPCNotification(t *testing.T) {
+	status, body := postRPC(t, NewJSONRPCServer(newTestNode(t)), `{"jsonrpc":"2.0","method":"chain_head"}`)
+	if status != http.StatusNoContent || body != "" {
+		t.Fatalf("notification answered with %d: %s", status, body)
+	}
+}
+
+func TestJSONRPCMethodNotAllowed(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	NewJSONRPCServer(newTestNode(t)).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/rpc", nil))
+
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET answered with %d, want %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// A batch is answered with one response per request that is not a notification, in the order of the requests.
+func TestJSONRPCBatch(t *testing.T) {
+	node := newTestNode(t)
+	server := NewJSONRPCServer(node)
+
+	status, body := postRPC(t, server, `[
+		{"jsonrpc":"2.0","method":"chain_head","id":1},
+		{"jsonrpc":"2.0","method":"mempool_list"},
+		{"jsonrpc":"2.0","method":"chain_mine","id":2},
+		42,
+		{"jsonrpc":"2.0","method":"mempool_list","id":3}
+	]`)
+	if status != http.StatusOK {
+		t.Fatalf("status %d, want %d", status, http.StatusOK)
+	}
+
+	var responses []json.RawMessage
+	if err := json.Unmarshal([]byte(body), &responses); err != nil {
+		t.Fatalf("batch response %s: %v", body, err)
+	}
+	if len(responses) != 4 {
+		t.Fatalf("%d responses, want 4: %s", len(responses), body)
+	}
+
+	var head struct {
+		Result HeadView `json:"result"`
+		ID     int      `json:"id"`
+	}
+	if err := json.Unmarshal(responses[0], &head); err != nil || head.ID != 1 || head.Result.Hash == "" {
+		t.Fatalf("first response is %s", responses[0])
+	}
+
+	checkErrorResponse(t, decodeMembers(t, string(responses[1])), codeMethodNotFound, "2")
+	checkErrorResponse(t, decodeMembers(t, string(responses[2])), codeInvalidRequest, "null")
+
+	// an empty mempool is an empty list, not a missing result
+	if members := decodeMembers(t, string(responses[3])); string(members["result"]) != "[]" || string(members["id"]) != "3" {
+		t.Fatalf("last response is %s", responses[3])
+	}
+
+	// a batch of notifications only is not answered
+	status, body = postRPC(t, server, `[{"jsonrpc":"2.0","method":"chain_head"},{"jsonrpc":"2.0","method":"mempool_list"}]`)
+	if status != http.StatusNoContent || body != "" {
+		t.Fatalf("batch of notifications answered with %d: %s", status, body)
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+)
+
+// Most blocks one /blocks request returns, and how many it returns when no range is given.
+const (
+	maxBlocksPerPage     = 100
+	defaultBlocksPerPage = 10
+)
+
+// RESTServer serves JSON views of the ledger of a node over plain HTTP GET requests:
+//
+//	/head					root of the canonical chain
+//	/blocks?from=&to=		canonical blocks from height from to height to (both included, the last 10 blocks by default),
+//							at most 100 of them with the height to continue from in next (see BlockPageView)
+//	/blocks/{hash}			block with the hex hash, canonical or not
+//	/tx/{hash}				transaction from the canonical chain or the mempool
+//	/peers					addresses the node knows and the state of its connection to each
+//
+// Errors are returned as {"error": "..."} with a 4xx status.
+type RESTServer struct {
+	node *blockchain.Node
+	mux  *http.ServeMux
+}
+
+// NewRESTServer creates the REST server of the node.
+func NewRESTServer(node *blockchain.Node) *RESTServer {
+	server := &RESTServer{node: node, mux: http.NewServeMux()}
+
+	server.mux.HandleFunc("/head", server.head)
+	server.mux.HandleFunc("/blocks", server.blocks)
+	server.mux.HandleFunc("/blocks/", server.block)
+	server.mux.HandleFunc("/tx/", server.transaction)
+	server.mux.HandleFunc("/peers", server.peers)
+	server.mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		writeError(writer, http.StatusNotFound, errors.New("no such endpoint: "+request.URL.Path))
+	})
+
+	return server
+}
+
+func (server *RESTServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writer.Header().Set("Allow", "GET, HEAD")
+		writeError(writer, http.StatusMethodNotAllowed, errors.New("only GET requests are supported"))
+		return
+	}
+
+	server.mux.ServeHTTP(writer, request)
+}
+
+func (server *RESTServer) head(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, NewHeadView(server.node.LocalChain))
+}
+
+func (server *RESTServer) blocks(writer http.ResponseWriter, request *http.Request) {
+	chain := server.node.LocalChain
+	height := chain.GetHeight()
+
+	to, err := heightParam(request, "to", height)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+	if to > height {
+		to = height
+	}
+
+	from, err := heightParam(request, "from", to-defaultBlocksPerPage+1)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if from < 0 {
+		from = 0
+	}
+
+	// a range past the root is an empty page, so a client that pages with next can follow the chain as it grows
+	page := BlockPageView{Blocks: []BlockView{}}
+	if from > height {
+		writeJSON(writer, http.StatusOK, page)
+		return
+	}
+
+	if from > to && request.URL.Query().Has("from") {
+		writeError(writer, http.StatusBadRequest, errors.New("from must not be above to"))
+		return
+	}
+
+	// a long range is cut to one page, the caller continues from next
+	if to-from+1 > maxBlocksPerPage {
+		next := from + maxBlocksPerPage
+		page.Next = &next
+		to = next - 1
+	}
+
+	for current := from; current <= to; current++ {
+		block := chain.GetBlockByHeight(current)
+		if block == nil {
+			break
+		}
+		page.Blocks = append(page.Blocks, NewBlockView(chain, block))
+	}
+
+	writeJSON(writer, http.StatusOK, page)
+}
+
+func (server *RESTServer) block(writer http.ResponseWriter, request *http.Request) {
+	hash, err := decodeHash(strings.TrimPrefix(request.URL.Path, "/blocks/"))
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	block := server.node.LocalChain.GetBlock(hash)
+	if block == nil {
+		writeError(writer, http.StatusNotFound, errors.New("block not found"))
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, NewBlockView(server.node.LocalChain, block))
+}
+
+func (server *RESTServer) transaction(writer http.ResponseWriter, request *http.Request) {
+	hash, err := decodeHash(strings.TrimPrefix(request.URL.Path, "/tx/"))
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	view, ok := findTransaction(server.node, hash)
+	if !ok {
+		writeError(writer, http.StatusNotFound, errors.New("transaction not found"))
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, view)
+}
+
+func (server *RESTServer) peers(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, NewPeerViews(server.node))
+}
+
+// Reads a height from the query, or returns fallback if it is not given.
+func heightParam(request *http.Request, name string, fallback int) (int, error) {
+	value := request.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	height, err := strconv.Atoi(value)
+	if err != nil || height < 0 {
+		return 0, errors.New(name + " must be a height (a number that is not negative)")
+	}
+
+	return height, nil
+}
+
+func writeError(writer http.ResponseWriter, status int, err error) {
+	writeJSON(writer, status, map[string]string{"error": err.Error()})
+}
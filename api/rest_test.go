@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+)
+
+// Mines a block with one transaction on top of the root of the node's chain, like the miner does.
+func mineTestBlock(t *testing.T, node *blockchain.Node) {
+	t.Helper()
+
+	recipient := blockchain.AddressFromPublicKey(node.Key.Public().(ed25519.PublicKey))
+	transaction, err := blockchain.MakeSignedTransaction(node.Key, recipient, time.Now().UnixNano(), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.SubmitTransaction(*transaction); err != nil {
+		t.Fatal(err)
+	}
+
+	block := node.BuildBlockTemplate()
+	if _, err := block.MineParallel(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.LocalChain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	node.Mempool.RemoveMined()
+}
+
+func TestBlockPages(t *testing.T) {
+	node := newTestNode(t)
+	for i := 0; i < 3; i++ {
+		mineTestBlock(t, node)
+	}
+	server := NewRESTServer(node)
+
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		heights []int
+	}{
+		{"whole chain", "", http.StatusOK, []int{0, 1, 2, 3}},
+		{"range", "?from=1&to=2", http.StatusOK, []int{1, 2}},
+		{"to past the root", "?from=2&to=50", http.StatusOK, []int{2, 3}},
+		{"only to", "?to=1", http.StatusOK, []int{0, 1}},
+		{"from the root", "?from=3", http.StatusOK, []int{3}},
+		{"from past the root", "?from=4", http.StatusOK, []int{}},
+		{"range past the root", "?from=8&to=12", http.StatusOK, []int{}},
+		{"from above to", "?from=2&to=1", http.StatusBadRequest, nil},
+		{"negative height", "?from=-1", http.StatusBadRequest, nil},
+		{"height that is not a number", "?to=root", http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/blocks"+test.query, nil))
+
+			if recorder.Code != test.status {
+				t.Fatalf("status %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+			if test.heights == nil {
+				return
+			}
+
+			// an empty page is an empty list, so clients do not have to handle null
+			var members map[string]json.RawMessage
+			if err := json.Unmarshal(recorder.Body.Bytes(), &members); err != nil {
+				t.Fatal(err)
+			}
+			if len(test.heights) == 0 && string(members["blocks"]) != "[]" {
+				t.Fatalf("blocks of an empty page are %s, want []", members["blocks"])
+			}
+			if _, ok := members["next"]; ok {
+				t.Fatalf("page of %d blocks has a next height", len(test.heights))
+			}
+
+			var page BlockPageView
+			if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+			heights := make([]int, len(page.Blocks))
+			for i, block := range page.Blocks {
+				heights[i] = block.Height
+			}
+			if fmt.Sprint(heights) != fmt.Sprint(test.heights) {
+				t.Fatalf("heights %v, want %v", heights, test.heights)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+)
+
+// NewHandler returns the HTTP handler of the API of the node.
+// JSON-RPC 2.0 requests are served on /rpc, event subscriptions over WebSocket on /ws (see WebSocketServer),
+// everything else is the REST explorer (see RESTServer).
+func NewHandler(node *blockchain.Node) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/rpc", NewJSONRPCServer(node))
+	mux.Handle("/ws", NewWebSocketServer(node))
+	mux.Handle("/", NewRESTServer(node))
+
+	return mux
+}
+
+// ListenAndServe serves the API of the node on the address (host:port) until the listener fails.
+func ListenAndServe(address string, node *blockchain.Node) error {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           NewHandler(node),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return server.ListenAndServe()
+}
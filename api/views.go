@@ -0,0 +1,273 @@
+// Package api serves the state of a node to external clients over HTTP.
+// Blocks, transactions and peers are returned as JSON views, with hashes, keys and signatures in hex.
+package api
+
+import (
+	"encoding/hex"
+	"errors"
+	"time"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+)
+
+// HeaderView is the JSON view of a block header.
+// Bits		target in compact form, as in the header
+// Target	hex target the hash must be below
+type HeaderView struct {
+	Hash       string `json:"hash"`
+	Version    uint32 `json:"version"`
+	ParentHash string `json:"parentHash"`
+	MerkleRoot string `json:"merkleRoot"`
+	Timestamp  int64  `json:"timestamp"`
+	Bits       uint32 `json:"bits"`
+	Target     string `json:"target"`
+	Nonce      uint64 `json:"nonce"`
+}
+
+// BlockView is the JSON view of a block.
+// Height		height of the block in the block tree (-1 if the chain does not know the block)
+// Canonical	whether the block is on the canonical chain or on a side branch
+type BlockView struct {
+	HeaderView
+	Height       int               `json:"height"`
+	Canonical    bool              `json:"canonical"`
+	Transactions []TransactionView `json:"transactions"`
+}
+
+// BlockPageView is a page of canonical blocks, as returned by /blocks.
+// Next		height to ask for with from to get the rest of the range (omitted if the page has all of it)
+type BlockPageView struct {
+	Blocks []BlockView `json:"blocks"`
+	Next   *int        `json:"next,omitempty"`
+}
+
+// TransactionView is the JSON view of a transaction.
+// Data			message of the transaction
+// PublicKey	hex public key of the sender
+// Signature	hex signature of the sender
+// BlockHash	block of the canonical chain the transaction is in (empty while it is pending)
+// BlockHeight	height of that block (-1 while it is pending)
+// Pending		whether the transaction is in the mempool
+type TransactionView struct {
+	Hash        string `json:"hash"`
+	Sender      string `json:"sender"`
+	Recipient   string `json:"recipient"`
+	Timestamp   int64  `json:"timestamp"`
+	Data        string `json:"data"`
+	PublicKey   string `json:"publicKey"`
+	Signature   string `json:"signature"`
+	BlockHash   string `json:"blockHash,omitempty"`
+	BlockHeight int    `json:"blockHeight"`
+	Pending     bool   `json:"pending"`
+}
+
+// HeadView describes the root of the canonical chain.
+// TotalWork	cumulative proof of work of the canonical chain, in decimal
+// NextBits		bits the next block must have
+type HeadView struct {
+	ChainID     string `json:"chainId"`
+	GenesisHash string `json:"genesisHash"`
+	Hash        string `json:"hash"`
+	Height      int    `json:"height"`
+	Timestamp   int64  `json:"timestamp"`
+	TotalWork   string `json:"totalWork"`
+	NextBits    uint32 `json:"nextBits"`
+}
+
+// VerifyView is the result of checking the canonical chain again.
+// Blocks		blocks that were checked before the first problem (all of them if the chain is valid)
+// Error		first problem found (empty if the chain is valid)
+type VerifyView struct {
+	Valid  bool   `json:"valid"`
+	Blocks int    `json:"blocks"`
+	Head   string `json:"head"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PeerView is the JSON view of an address the node knows.
+// Latency		round trip time of the last ping, in milliseconds
+// LastSeen		last time a connection or ping succeeded (omitted if never)
+type PeerView struct {
+	ID          int        `json:"id"`
+	Address     string     `json:"address"`
+	State       string     `json:"state"`
+	Identity    string     `json:"identity,omitempty"`
+	Height      int        `json:"height"`
+	Version     int        `json:"version,omitempty"`
+	Persistent  bool       `json:"persistent"`
+	Score       int        `json:"score"`
+	Attempts    int        `json:"attempts"`
+	Latency     float64    `json:"latencyMs"`
+	LastSeen    *time.Time `json:"lastSeen,omitempty"`
+	BannedUntil *time.Time `json:"bannedUntil,omitempty"`
+}
+
+// NewHeaderView makes the view of the header of a block.
+func NewHeaderView(block *blockchain.Block) HeaderView {
+	return HeaderView{
+		Hash:       hex.EncodeToString(block.GetHash()),
+		Version:    block.GetVersion(),
+		ParentHash: hex.EncodeToString(block.GetParentBlockHash()),
+		MerkleRoot: hex.EncodeToString(block.GetMerkleRoot()),
+		Timestamp:  block.GetTimestamp(),
+		Bits:       block.GetBits(),
+		Target:     block.GetTarget().Text(16),
+		Nonce:      block.GetNonce(),
+	}
+}
+
+// NewBlockView makes the view of a block of the chain, with all its transactions.
+func NewBlockView(chain *blockchain.BlockChain, block *blockchain.Block) BlockView {
+	height := chain.GetBlockHeight(block.GetHash())
+	canonical := chain.IsCanonical(block.GetHash())
+
+	view := BlockView{
+		HeaderView:   NewHeaderView(block),
+		Height:       height,
+		Canonical:    canonical,
+		Transactions: []TransactionView{},
+	}
+
+	for _, transaction := range block.GetTransactions() {
+		transactionView := NewTransactionView(transaction)
+		if canonical {
+			transactionView.BlockHash = view.Hash
+			transactionView.BlockHeight = height
+		}
+		view.Transactions = append(view.Transactions, transactionView)
+	}
+
+	return view
+}
+
+// NewTransactionView makes the view of a transaction that is not in a block.
+func NewTransactionView(transaction blockchain.Transaction) TransactionView {
+	hash, _ := transaction.CalculateHash()
+
+	return TransactionView{
+		Hash:        hex.EncodeToString(hash),
+		Sender:      string(transaction.Sender),
+		Recipient:   string(transaction.Recipient),
+		Timestamp:   transaction.Timestamp,
+		Data:        string(transaction.Data),
+		PublicKey:   hex.EncodeToString(transaction.PubKey),
+		Signature:   hex.EncodeToString(transaction.Signature),
+		BlockHeight: -1,
+	}
+}
+
+// NewHeadView describes the root of the chain.
+func NewHeadView(chain *blockchain.BlockChain) HeadView {
+	root, nextBits := chain.GetRootAndNextBits()
+
+	return HeadView{
+		ChainID:     chain.GetChainID(),
+		GenesisHash: hex.EncodeToString(chain.GetGenesis().GetHash()),
+		Hash:        hex.EncodeToString(root.GetHash()),
+		Height:      chain.GetBlockHeight(root.GetHash()),
+		Timestamp:   root.GetTimestamp(),
+		TotalWork:   chain.GetTotalWork().String(),
+		NextBits:    nextBits,
+	}
+}
+
+// NewVerifyView checks the canonical chain again (see BlockChain.VerifyChain) and describes the result.
+func NewVerifyView(chain *blockchain.BlockChain) VerifyView {
+	head := hex.EncodeToString(chain.GetRoot().GetHash())
+	blocks, err := chain.VerifyChain()
+	if err != nil {
+		return VerifyView{Blocks: blocks, Head: head, Error: err.Error()}
+	}
+
+	return VerifyView{Valid: true, Blocks: blocks, Head: head}
+}
+
+// NewPeerViews makes the views of every address the node knows.
+func NewPeerViews(node *blockchain.Node) []PeerView {
+	views := []PeerView{}
+
+	for _, info := range node.GetPeerInfo() {
+		view := PeerView{
+			ID:         info.ID,
+			Address:    info.Address,
+			State:      info.State.String(),
+			Identity:   info.Identity,
+			Height:     info.Height,
+			Version:    info.Version,
+			Persistent: info.Persistent,
+			Score:      info.Score,
+			Attempts:   info.Attempts,
+			Latency:    float64(info.Latency) / float64(time.Millisecond),
+		}
+
+		if !info.LastSeen.IsZero() {
+			lastSeen := info.LastSeen
+			view.LastSeen = &lastSeen
+		}
+
+		if info.State == blockchain.PeerBanned {
+			bannedUntil := info.BannedUntil
+			view.BannedUntil = &bannedUntil
+		}
+
+		views = append(views, view)
+	}
+
+	return views
+}
+
+// Transaction builds the transaction the view describes. The hash and block fields are ignored.
+func (view TransactionView) Transaction() (blockchain.Transaction, error) {
+	publicKey, err := hex.DecodeString(view.PublicKey)
+	if err != nil {
+		return blockchain.Transaction{}, errors.New("publicKey is not hex")
+	}
+
+	signature, err := hex.DecodeString(view.Signature)
+	if err != nil {
+		return blockchain.Transaction{}, errors.New("signature is not hex")
+	}
+
+	return blockchain.Transaction{
+		Sender:    []byte(view.Sender),
+		Recipient: []byte(view.Recipient),
+		Timestamp: view.Timestamp,
+		Data:      []byte(view.Data),
+		PubKey:    publicKey,
+		Signature: signature,
+	}, nil
+}
+
+// Finds a transaction in the canonical chain or the mempool of the node.
+// Returns false if the node does not know it.
+func findTransaction(node *blockchain.Node, hash []byte) (TransactionView, bool) {
+	if transaction, block := node.LocalChain.GetTransaction(hash); transaction != nil {
+		view := NewTransactionView(*transaction)
+		view.BlockHash = hex.EncodeToString(block.GetHash())
+		view.BlockHeight = node.LocalChain.GetBlockHeight(block.GetHash())
+
+		return view, true
+	}
+
+	if transaction, ok := node.Mempool.Get(hash); ok {
+		view := NewTransactionView(transaction)
+		view.Pending = true
+
+		return view, true
+	}
+
+	return TransactionView{}, false
+}
+
+// Views of the pending transactions of the node, oldest first.
+func mempoolViews(node *blockchain.Node) []TransactionView {
+	views := []TransactionView{}
+
+	for _, transaction := range node.Mempool.Transactions() {
+		view := NewTransactionView(transaction)
+		view.Pending = true
+		views = append(views, view)
+	}
+
+	return views
+}
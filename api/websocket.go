@@ -0,0 +1,428 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+	"github.com/gorilla/websocket"
+)
+
+// Limits of a WebSocket connection.
+const (
+	maxSubscriptions = 32               // subscriptions one connection may hold
+	wsWriteTimeout   = 10 * time.Second // time a client gets to take one message
+	wsPongTimeout    = 60 * time.Second // a client that answers no ping for this long is disconnected
+	wsPingInterval   = wsPongTimeout / 2
+)
+
+// Topics a client can subscribe to.
+const (
+	topicNewHeads            = "newHeads"            // new root of the canonical chain, as a BlockView
+	topicReorgs              = "reorgs"              // switches of the canonical chain to another branch, as a ReorgView
+	topicPendingTransactions = "pendingTransactions" // transactions admitted to the mempool, as TransactionViews
+	topicTransactions        = "transactions"        // transactions of one address, pending or mined, as TransactionViews
+)
+
+// ReorgView is the JSON view of a switch of the canonical chain to another branch.
+// ForkHeight		height of the last block both branches share
+// Disconnected		hashes of the blocks that left the canonical chain, oldest first
+// Connected		hashes of the blocks that joined it, oldest first
+// RolledBack		transactions that were only in the disconnected blocks (they are pending again)
+type ReorgView struct {
+	OldHead      string            `json:"oldHead"`
+	NewHead      string            `json:"newHead"`
+	ForkHeight   int               `json:"forkHeight"`
+	Disconnected []string          `json:"disconnected"`
+	Connected    []string          `json:"connected"`
+	RolledBack   []TransactionView `json:"rolledBack"`
+}
+
+// Notification is the JSON-RPC 2.0 notification that carries an event to a subscriber.
+type Notification struct {
+	JSONRPC string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  NotificationParams `json:"params"`
+}
+
+// Subscription	ID that subscribe returned
+type NotificationParams struct {
+	Subscription int    `json:"subscription"`
+	Topic        string `json:"topic"`
+	Result       any    `json:"result"`
+}
+
+// Topic a connection subscribed to. address is only set for topicTransactions.
+type topicFilter struct {
+	topic   string
+	address string
+}
+
+// WebSocketServer streams the events of a node to clients over WebSocket.
+// Clients speak JSON-RPC 2.0 on the socket:
+//
+//	subscribe		[topic, address]	subscribes to newHeads, reorgs, pendingTransactions, or transactions (of the address), returns the subscription ID
+//	unsubscribe		[id]				ends a subscription, returns whether it existed
+//
+// Every other method is answered like on /rpc (see JSONRPCServer).
+// Events arrive as "subscription" notifications with the ID, the topic and the view of the event.
+// A client that does not keep up with the events is disconnected.
+type WebSocketServer struct {
+	node     *blockchain.Node
+	rpc      *JSONRPCServer
+	upgrader websocket.Upgrader
+}
+
+// NewWebSocketServer creates the WebSocket server of the node.
+func NewWebSocketServer(node *blockchain.Node) *WebSocketServer {
+	return &WebSocketServer{
+		node: node,
+		rpc:  NewJSONRPCServer(node),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+		},
+	}
+}
+
+func (server *WebSocketServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	conn, err := server.upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		return // the upgrader already answered with an error
+	}
+
+	connection := &wsConnection{
+		server:        server,
+		conn:          conn,
+		subscription:  server.node.LocalChain.Events().Subscribe(blockchain.DefaultEventBuffer),
+		subscriptions: make(map[int]topicFilter),
+		done:          make(chan struct{}),
+	}
+
+	go connection.writeEvents()
+	connection.readRequests()
+}
+
+// One client connected over WebSocket.
+// subscription		events of the node, filtered by the topics the client subscribed to
+// subscriptions	topics the client subscribed to by ID
+// writeMutex		a WebSocket connection takes one writer at a time
+type wsConnection struct {
+	server       *WebSocketServer
+	conn         *websocket.Conn
+	subscription *blockchain.Subscription
+
+	subscriptions map[int]topicFilter
+	nextID        int
+	mutex         sync.Mutex
+
+	writeMutex sync.Mutex
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// Reads and answers requests until the client disconnects.
+func (connection *wsConnection) readRequests() {
+	defer connection.close()
+
+	connection.conn.SetReadLimit(maxRequestBytes)
+	connection.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	connection.conn.SetPongHandler(func(string) error {
+		return connection.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		_, message, err := connection.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if response := connection.handle(message); response != nil {
+			if err := connection.write(response); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Runs one request. Subscriptions are handled here, everything else by the JSON-RPC server.
+func (connection *wsConnection) handle(raw json.RawMessage) *Response {
+	var request Request
+	if err := json.Unmarshal(raw, &request); err != nil || request.JSONRPC != "2.0" {
+		return connection.server.rpc.handle(raw)
+	}
+
+	switch request.Method {
+	case "subscribe":
+		id, err := connection.subscribe(request.Params)
+		if err != nil {
+			return responseFor(request, nil, err)
+		}
+		return responseFor(request, id, nil)
+	case "unsubscribe":
+		ok, err := connection.unsubscribe(request.Params)
+		if err != nil {
+			return responseFor(request, nil, err)
+		}
+		return responseFor(request, ok, nil)
+	}
+
+	return connection.server.rpc.handle(raw)
+}
+
+func (connection *wsConnection) subscribe(params json.RawMessage) (int, *Error) {
+	var args struct {
+		Topic   string `json:"topic"`
+		Address string `json:"address"`
+	}
+	if err := decodeParams(params, &args, "topic", "address"); err != nil {
+		return 0, invalidParams(err)
+	}
+
+	switch args.Topic {
+	case topicNewHeads, topicReorgs, topicPendingTransactions:
+		if args.Address != "" {
+			return 0, invalidParams(fmt.Errorf("topic %s takes no address", args.Topic))
+		}
+	case topicTransactions:
+		if args.Address == "" {
+			return 0, invalidParams(errors.New("topic transactions needs an address"))
+		}
+		if !blockchain.IsValidAddress(args.Address) {
+			return 0, invalidParams(fmt.Errorf("%q is not a valid address", args.Address))
+		}
+	default:
+		return 0, invalidParams(fmt.Errorf("unknown topic %q", args.Topic))
+	}
+
+	connection.mutex.Lock()
+	defer connection.mutex.Unlock()
+
+	if len(connection.subscriptions) >= maxSubscriptions {
+		return 0, &Error{Code: codeRejected, Message: fmt.Sprintf("a connection cannot hold more than %d subscriptions", maxSubscriptions)}
+	}
+
+	connection.nextID++
+	connection.subscriptions[connection.nextID] = topicFilter{topic: args.Topic, address: args.Address}
+
+	return connection.nextID, nil
+}
+
+func (connection *wsConnection) unsubscribe(params json.RawMessage) (bool, *Error) {
+	var args struct {
+		ID *int `json:"id"`
+	}
+	if err := decodeParams(params, &args, "id"); err != nil {
+		return false, invalidParams(err)
+	}
+
+	if args.ID == nil {
+		return false, invalidParams(errors.New("id must be set"))
+	}
+
+	connection.mutex.Lock()
+	defer connection.mutex.Unlock()
+
+	_, ok := connection.subscriptions[*args.ID]
+	delete(connection.subscriptions, *args.ID)
+
+	return ok, nil
+}
+
+// Sends the events the client subscribed to, and pings it, until the connection is closed.
+// Closes the connection if the node dropped the subscription because the client was too slow.
+func (connection *wsConnection) writeEvents() {
+	defer connection.close()
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-connection.subscription.Events():
+			if !ok {
+				if err := connection.subscription.Err(); err != nil {
+					connection.writeClose(websocket.ClosePolicyViolation, err.Error())
+				}
+				return
+			}
+
+			for _, notification := range connection.notifications(event) {
+				if err := connection.write(notification); err != nil {
+					return
+				}
+			}
+		case <-ticker.C:
+			connection.writeMutex.Lock()
+			err := connection.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			connection.writeMutex.Unlock()
+
+			if err != nil {
+				return
+			}
+		case <-connection.done:
+			return
+		}
+	}
+}
+
+// Notifications of the event for every subscription it matches, in the order of the subscription IDs.
+func (connection *wsConnection) notifications(event blockchain.Event) []Notification {
+	connection.mutex.Lock()
+	ids := make([]int, 0, len(connection.subscriptions))
+	filters := make(map[int]topicFilter, len(connection.subscriptions))
+	for id, filter := range connection.subscriptions {
+		ids = append(ids, id)
+		filters[id] = filter
+	}
+	connection.mutex.Unlock()
+
+	sort.Ints(ids)
+
+	var notifications []Notification
+	for _, id := range ids {
+		for _, result := range connection.match(filters[id], event) {
+			notifications = append(notifications, Notification{
+				JSONRPC: "2.0",
+				Method:  "subscription",
+				Params:  NotificationParams{Subscription: id, Topic: filters[id].topic, Result: result},
+			})
+		}
+	}
+
+	return notifications
+}
+
+// Views of the event the filter lets through (none if it does not match).
+func (connection *wsConnection) match(filter topicFilter, event blockchain.Event) []any {
+	chain := connection.server.node.LocalChain
+
+	switch filter.topic {
+	case topicNewHeads:
+		if event.Type == blockchain.EventNewHead {
+			return []any{NewBlockView(chain, event.Block)}
+		}
+	case topicReorgs:
+		if event.Type == blockchain.EventReorg {
+			return []any{NewReorgView(event.Reorg)}
+		}
+	case topicPendingTransactions:
+		if event.Type == blockchain.EventTransaction {
+			view := NewTransactionView(event.Transaction)
+			view.Pending = true
+			return []any{view}
+		}
+	case topicTransactions:
+		return addressTransactions(chain, filter.address, event)
+	}
+
+	return nil
+}
+
+// Views of the transactions of the event that the address sent or received.
+// Mined transactions are reported when their block becomes the root, or when a reorg connects it.
+func addressTransactions(chain *blockchain.BlockChain, address string, event blockchain.Event) []any {
+	var views []any
+
+	switch event.Type {
+	case blockchain.EventTransaction:
+		if involves(event.Transaction, address) {
+			view := NewTransactionView(event.Transaction)
+			view.Pending = true
+			views = append(views, view)
+		}
+	case blockchain.EventNewHead:
+		views = append(views, minedTransactions(chain, event.Block, address)...)
+	case blockchain.EventReorg:
+		// the last connected block is the new root and gets its own EventNewHead
+		connected := event.Reorg.Connected
+		for _, block := range connected[:len(connected)-1] {
+			views = append(views, minedTransactions(chain, block, address)...)
+		}
+	}
+
+	return views
+}
+
+// Views of the transactions of a block of the canonical chain that the address sent or received.
+func minedTransactions(chain *blockchain.BlockChain, block *blockchain.Block, address string) []any {
+	var views []any
+
+	for _, transaction := range block.GetTransactions() {
+		if !involves(transaction, address) {
+			continue
+		}
+
+		view := NewTransactionView(transaction)
+		view.BlockHash = hex.EncodeToString(block.GetHash())
+		view.BlockHeight = chain.GetBlockHeight(block.GetHash())
+		views = append(views, view)
+	}
+
+	return views
+}
+
+func involves(transaction blockchain.Transaction, address string) bool {
+	return string(transaction.Sender) == address || string(transaction.Recipient) == address
+}
+
+// NewReorgView makes the view of a reorg.
+func NewReorgView(reorg *blockchain.Reorg) ReorgView {
+	view := ReorgView{
+		OldHead:      hex.EncodeToString(reorg.OldRoot.GetHash()),
+		NewHead:      hex.EncodeToString(reorg.NewRoot.GetHash()),
+		ForkHeight:   reorg.ForkHeight,
+		Disconnected: []string{},
+		Connected:    []string{},
+		RolledBack:   []TransactionView{},
+	}
+
+	for _, block := range reorg.Disconnected {
+		view.Disconnected = append(view.Disconnected, hex.EncodeToString(block.GetHash()))
+	}
+
+	for _, block := range reorg.Connected {
+		view.Connected = append(view.Connected, hex.EncodeToString(block.GetHash()))
+	}
+
+	for _, transaction := range reorg.RolledBack {
+		transactionView := NewTransactionView(transaction)
+		transactionView.Pending = true
+		view.RolledBack = append(view.RolledBack, transactionView)
+	}
+
+	return view
+}
+
+// Writes a message to the client as JSON.
+func (connection *wsConnection) write(value any) error {
+	connection.writeMutex.Lock()
+	defer connection.writeMutex.Unlock()
+
+	connection.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+
+	return connection.conn.WriteJSON(value)
+}
+
+// Tells the client why the connection is closed.
+func (connection *wsConnection) writeClose(code int, reason string) {
+	connection.writeMutex.Lock()
+	defer connection.writeMutex.Unlock()
+
+	connection.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+}
+
+// Ends the subscription and closes the connection. Safe to call from both goroutines.
+func (connection *wsConnection) close() {
+	connection.closeOnce.Do(func() {
+		close(connection.done)
+		connection.subscription.Unsubscribe()
+		connection.conn.Close()
+	})
+}
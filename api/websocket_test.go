@@ -0,0 +1,135 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+)
+
+// One message from the WebSocket server, a response or a notification.
+type wsMessage struct {
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+	Method string          `json:"method"`
+	Params struct {
+		Subscription int             `json:"subscription"`
+		Topic        string          `json:"topic"`
+		Result       TransactionView `json:"result"`
+	} `json:"params"`
+}
+
+// Connects a client to a WebSocket server of the node.
+func dialTestWebSocket(t *testing.T, node *blockchain.Node) *websocket.Conn {
+	t.Helper()
+
+	server := httptest.NewServer(NewWebSocketServer(node))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// Reads the next message, failing the test if none arrives in time.
+func readMessage(t *testing.T, conn *websocket.Conn) wsMessage {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var message wsMessage
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatalf("no message from the server: %v", err)
+	}
+
+	return message
+}
+
+// Sends a request and returns the response to it.
+func call(t *testing.T, conn *websocket.Conn, method string, params ...any) wsMessage {
+	t.Helper()
+
+	if err := conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "method": method, "params": params, "id": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	return readMessage(t, conn)
+}
+
+// Reads the next message and checks that it is a notification of the subscription about a transaction.
+func expectTransaction(t *testing.T, conn *websocket.Conn, subscription int, topic string, pending bool) {
+	t.Helper()
+
+	message := readMessage(t, conn)
+	if message.Method != "subscription" || message.Params.Subscription != subscription || message.Params.Topic != topic {
+		t.Fatalf("got %+v, want a %s notification of subscription %d", message, topic, subscription)
+	}
+	if message.Params.Result.Pending != pending {
+		t.Fatalf("transaction is pending: %v, want %v", message.Params.Result.Pending, pending)
+	}
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	node := newTestNode(t)
+	conn := dialTestWebSocket(t, node)
+	address := blockchain.AddressFromPublicKey(node.Key.Public().(ed25519.PublicKey))
+
+	if message := call(t, conn, "subscribe", topicNewHeads); string(message.Result) != "1" {
+		t.Fatalf("subscribe to newHeads returned %s, %v", message.Result, message.Error)
+	}
+	if message := call(t, conn, "subscribe", topicTransactions, address); string(message.Result) != "2" {
+		t.Fatalf("subscribe to transactions returned %s, %v", message.Result, message.Error)
+	}
+
+	invalid := []struct {
+		name   string
+		params []any
+	}{
+		{"unknown topic", []any{"blocks"}},
+		{"address that is not valid", []any{topicTransactions, "not-an-address"}},
+		{"address of another length", []any{topicTransactions, address[:len(address)-2]}},
+		{"no address", []any{topicTransactions}},
+		{"address on a topic without one", []any{topicPendingTransactions, address}},
+	}
+	for _, test := range invalid {
+		message := call(t, conn, "subscribe", test.params...)
+		if message.Error == nil || message.Error.Code != codeInvalidParams {
+			t.Fatalf("subscribe with %s returned %s, %v", test.name, message.Result, message.Error)
+		}
+	}
+
+	// the transaction is reported when it enters the mempool and again when its block becomes the root
+	mineTestBlock(t, node)
+	expectTransaction(t, conn, 2, topicTransactions, true)
+	if message := readMessage(t, conn); message.Method != "subscription" || message.Params.Subscription != 1 || message.Params.Topic != topicNewHeads {
+		t.Fatalf("got %+v, want a newHeads notification", message)
+	}
+	expectTransaction(t, conn, 2, topicTransactions, false)
+
+	if message := call(t, conn, "unsubscribe", 1); string(message.Result) != "true" {
+		t.Fatalf("unsubscribe returned %s, %v", message.Result, message.Error)
+	}
+	if message := call(t, conn, "unsubscribe", 1); string(message.Result) != "false" {
+		t.Fatalf("second unsubscribe returned %s, %v", message.Result, message.Error)
+	}
+
+	// without the newHeads subscription only the transactions are reported
+	mineTestBlock(t, node)
+	expectTransaction(t, conn, 2, topicTransactions, true)
+	expectTransaction(t, conn, 2, topicTransactions, false)
+
+	// calls that are not subscriptions go to the JSON-RPC server
+	if message := call(t, conn, "chain_getBlockByHeight", 2); message.Error != nil || len(message.Result) == 0 {
+		t.Fatalf("chain_getBlockByHeight returned %s, %v", message.Result, message.Error)
+	}
+}
@@ -0,0 +1,441 @@
+package blockchain
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits of the address book.
+const (
+	maxAddressBookSize = 1000
+	maxAddressScore    = 10
+	minAddressScore    = -5 // addresses that fall below this are forgotten
+
+	minReconnectDelay = 2 * time.Second // wait after the first failed attempt, doubles with every further one
+	maxReconnectDelay = 5 * time.Minute
+)
+
+// PeerState is where the node is with the connection to an address.
+type PeerState int
+
+const (
+	PeerDisconnected PeerState = iota // not connected, dialed again once its reconnect delay is over
+	PeerConnecting                    // being dialed
+	PeerConnected                     // connected and answering pings
+	PeerBanned                        // misbehaved, not dialed again until the ban is over
+)
+
+func (state PeerState) String() string {
+	switch state {
+	case PeerDisconnected:
+		return "disconnected"
+	case PeerConnecting:
+		return "connecting"
+	case PeerConnected:
+		return "connected"
+	case PeerBanned:
+		return "banned"
+	}
+
+	return "unknown"
+}
+
+// PeerInfo is what the node knows about an address, as shown by GetPeerInfo.
+// ID			ID of the node from the config (-1 if it is not known)
+// Identity		address of the identity key the peer proved in its handshake
+// Height		height of the peer from its last handshake or ping
+// Version		protocol version we speak with the peer (the lower of both versions)
+// Persistent	from the config, always reconnected to
+// Score		goes up for every successful connection, down for every failed one
+// Attempts		failed connection attempts since the last successful one
+// LastSeen		last time a connection or ping succeeded
+// Latency		round trip time of the last ping
+// NextAttempt	earliest time the address is dialed again
+// BannedUntil	end of the ban, if the address is banned
+type PeerInfo struct {
+	ID          int
+	Address     string
+	State       PeerState
+	Identity    string
+	Height      int
+	Version     int
+	Persistent  bool
+	Score       int
+	Attempts    int
+	LastSeen    time.Time
+	Latency     time.Duration
+	NextAttempt time.Time
+	BannedUntil time.Time
+}
+
+// AddressBook keeps the addresses of every node we heard of, from the config, seeds and other nodes.
+// Every address has a score: it goes up when connecting to it works and down when it fails,
+// so the peer manager tries the addresses that worked before first.
+// After a failed attempt an address is not dialed again before its reconnect delay, which doubles with every failure.
+// Addresses from the config are persistent: they are never forgotten.
+// now		clock of the book, time.Now except in tests
+type AddressBook struct {
+	addresses map[string]*knownAddress
+	now       func() time.Time
+
+	mutex sync.Mutex
+}
+
+// Entry of an address in the address book.
+// id			ID of the node from the config (-1 if it is not known)
+// persistent	from the config, always connected to and never forgotten
+// score		goes up for every successful connection, down for every failed one
+// identity		address of the identity key of the peer, from its handshake
+// version		protocol version we speak with the peer, from its handshake
+// attempts		failed attempts since the last success, sets the reconnect delay
+// pingFailures	pings in a row that failed while connected
+type knownAddress struct {
+	address      string
+	id           int
+	persistent   bool
+	state        PeerState
+	identity     string
+	height       int
+	version      int
+	score        int
+	attempts     int
+	pingFailures int
+	lastAttempt  time.Time
+	lastSuccess  time.Time
+	latency      time.Duration
+	nextAttempt  time.Time
+	bannedUntil  time.Time
+}
+
+// NewAddressBook creates an empty address book.
+func NewAddressBook() *AddressBook {
+	return &AddressBook{addresses: make(map[string]*knownAddress), now: time.Now}
+}
+
+// Add adds an address that was learned from a seed or another node.
+// Returns false if the address is not in the form host:port, is already known or the book is full.
+func (book *AddressBook) Add(address string) bool {
+	if !isValidPeerAddress(address) {
+		return false
+	}
+
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	if _, known := book.addresses[address]; known || len(book.addresses) >= maxAddressBookSize {
+		return false
+	}
+
+	book.addresses[address] = &knownAddress{address: address, id: -1}
+
+	return true
+}
+
+// AddPersistent adds an address from the config, with the ID the node has there.
+func (book *AddressBook) AddPersistent(id int, address string) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	book.addresses[address] = &knownAddress{address: address, id: id, persistent: true}
+}
+
+// Attempt records that a connection to the address is being made.
+func (book *AddressBook) Attempt(address string) {
+	book.update(address, func(known *knownAddress) {
+		known.state = PeerConnecting
+		known.lastAttempt = book.now()
+	})
+}
+
+// Good records that connecting to the address worked.
+func (book *AddressBook) Good(address string) {
+	book.update(address, func(known *knownAddress) {
+		known.state = PeerConnected
+		known.attempts = 0
+		known.pingFailures = 0
+		known.lastSuccess = book.now()
+		known.nextAttempt = time.Time{}
+		if known.score < maxAddressScore {
+			known.score++
+		}
+	})
+}
+
+// Bad records that connecting to the address failed, and sets when it is dialed again.
+// Addresses whose score falls too low are forgotten, unless they are persistent.
+func (book *AddressBook) Bad(address string) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	known, ok := book.addresses[address]
+	if !ok {
+		return
+	}
+
+	known.score--
+	if known.score < minAddressScore && !known.persistent {
+		delete(book.addresses, address)
+		return
+	}
+
+	known.attempts++
+	known.state = PeerDisconnected
+	known.nextAttempt = book.now().Add(reconnectDelay(known.attempts))
+}
+
+// Identified records the identity (address of its identity key) and height a peer sent in its handshake,
+// and the protocol version we speak with it.
+func (book *AddressBook) Identified(address string, identity string, height int, version int) {
+	book.update(address, func(known *knownAddress) {
+		known.identity = identity
+		known.height = height
+		known.version = version
+	})
+}
+
+// Pinged records that the peer answered a ping after latency, at the given height.
+func (book *AddressBook) Pinged(address string, latency time.Duration, height int) {
+	book.update(address, func(known *knownAddress) {
+		known.pingFailures = 0
+		known.latency = latency
+		known.height = height
+		known.lastSuccess = book.now()
+	})
+}
+
+// PingFailed records that the peer did not answer a ping and returns how many pings in a row failed.
+func (book *AddressBook) PingFailed(address string) int {
+	failures := 0
+	book.update(address, func(known *knownAddress) {
+		known.pingFailures++
+		failures = known.pingFailures
+	})
+
+	return failures
+}
+
+// Disconnected records that the connection to the address was lost.
+// It is dialed again after the shortest reconnect delay.
+func (book *AddressBook) Disconnected(address string) {
+	book.update(address, func(known *knownAddress) {
+		if known.state == PeerBanned {
+			return
+		}
+		known.state = PeerDisconnected
+		known.pingFailures = 0
+		known.nextAttempt = book.now().Add(minReconnectDelay)
+	})
+}
+
+// Ban keeps the node from connecting to the address for duration, even if it is persistent.
+func (book *AddressBook) Ban(address string, duration time.Duration) {
+	book.update(address, func(known *knownAddress) {
+		known.state = PeerBanned
+		known.bannedUntil = book.now().Add(duration)
+	})
+}
+
+// IsBanned returns whether the address is banned right now.
+func (book *AddressBook) IsBanned(address string) bool {
+	banned := false
+	book.update(address, func(known *knownAddress) {
+		banned = known.state == PeerBanned && book.now().Before(known.bannedUntil)
+	})
+
+	return banned
+}
+
+// IsBannedNode returns whether the address is banned right now, or an address of the node with the identity.
+func (book *AddressBook) IsBannedNode(address string, identity string) bool {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	now := book.now()
+	for _, known := range book.addresses {
+		if known.address != address && known.identity != identity {
+			continue
+		}
+
+		if known.state == PeerBanned && now.Before(known.bannedUntil) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ID returns the ID of the node with the address from the config, or -1.
+func (book *AddressBook) ID(address string) int {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	if known, ok := book.addresses[address]; ok {
+		return known.id
+	}
+
+	return -1
+}
+
+// Size returns the number of known addresses.
+func (book *AddressBook) Size() int {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	return len(book.addresses)
+}
+
+// Addresses returns up to limit known addresses, best score first.
+func (book *AddressBook) Addresses(limit int) []string {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	var addresses []string
+	for _, known := range book.sorted() {
+		if len(addresses) >= limit {
+			break
+		}
+		addresses = append(addresses, known.address)
+	}
+
+	return addresses
+}
+
+// Persistent returns the addresses from the config that are not in exclude and can be dialed now.
+func (book *AddressBook) Persistent(exclude map[string]bool) []string {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	now := book.now()
+
+	var addresses []string
+	for _, known := range book.sorted() {
+		if known.persistent && !exclude[known.address] && known.dialable(now) {
+			addresses = append(addresses, known.address)
+		}
+	}
+
+	return addresses
+}
+
+// Candidates returns up to count addresses that are not from the config, not in exclude and can be dialed now,
+// best score first and the ones that were tried longest ago first among equal scores.
+func (book *AddressBook) Candidates(exclude map[string]bool, count int) []string {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	now := book.now()
+
+	var candidates []string
+	for _, known := range book.sorted() {
+		if len(candidates) >= count {
+			break
+		}
+
+		if !known.persistent && !exclude[known.address] && known.dialable(now) {
+			candidates = append(candidates, known.address)
+		}
+	}
+
+	return candidates
+}
+
+// Info returns what the book knows about every address, best score first.
+func (book *AddressBook) Info() []PeerInfo {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	var infos []PeerInfo
+	for _, known := range book.sorted() {
+		infos = append(infos, PeerInfo{
+			ID:          known.id,
+			Address:     known.address,
+			State:       known.state,
+			Identity:    known.identity,
+			Height:      known.height,
+			Version:     known.version,
+			Persistent:  known.persistent,
+			Score:       known.score,
+			Attempts:    known.attempts,
+			LastSeen:    known.lastSuccess,
+			Latency:     known.latency,
+			NextAttempt: known.nextAttempt,
+			BannedUntil: known.bannedUntil,
+		})
+	}
+
+	return infos
+}
+
+// Whether the address can be dialed at the given time: it is not connected or being dialed,
+// its ban is over and its reconnect delay has passed.
+func (known *knownAddress) dialable(now time.Time) bool {
+	switch known.state {
+	case PeerConnecting, PeerConnected:
+		return false
+	case PeerBanned:
+		if now.Before(known.bannedUntil) {
+			return false
+		}
+	}
+
+	return !now.Before(known.nextAttempt)
+}
+
+// Delay before the next attempt after the given number of failed ones: doubles every time up to maxReconnectDelay.
+func reconnectDelay(attempts int) time.Duration {
+	delay := minReconnectDelay
+	for i := 1; i < attempts && delay < maxReconnectDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxReconnectDelay {
+		delay = maxReconnectDelay
+	}
+
+	return delay
+}
+
+// Calls change with the entry of the address, if it is known.
+func (book *AddressBook) update(address string, change func(known *knownAddress)) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	if known, ok := book.addresses[address]; ok {
+		change(known)
+	}
+}
+
+// Returns the entries by score, then by when they were last tried. Mutex must be held.
+func (book *AddressBook) sorted() []*knownAddress {
+	entries := make([]*knownAddress, 0, len(book.addresses))
+	for _, known := range book.addresses {
+		entries = append(entries, known)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].score != entries[j].score {
+			return entries[i].score > entries[j].score
+		}
+		if !entries[i].lastAttempt.Equal(entries[j].lastAttempt) {
+			return entries[i].lastAttempt.Before(entries[j].lastAttempt)
+		}
+		return entries[i].address < entries[j].address
+	})
+
+	return entries
+}
+
+// Checks that an address is in the form host:port.
+func isValidPeerAddress(address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return false
+	}
+
+	number, err := strconv.Atoi(port)
+
+	return err == nil && number > 0 && number < 65536
+}
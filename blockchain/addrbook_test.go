@@ -0,0 +1,331 @@
+package blockchain
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Clock that only moves when the test moves it. Peer manager goroutines may read it while the test moves it.
+type fakeClock struct {
+	now   time.Time
+	mutex sync.Mutex
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return clock.now
+}
+
+func (clock *fakeClock) Advance(duration time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.now = clock.now.Add(duration)
+}
+
+// Creates an address book that runs on a fake clock.
+func newTestAddressBook() (*AddressBook, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	book := NewAddressBook()
+	book.now = clock.Now
+
+	return book, clock
+}
+
+// Returns what the book knows about the address.
+func peerInfo(t *testing.T, book *AddressBook, address string) PeerInfo {
+	t.Helper()
+
+	for _, info := range book.Info() {
+		if info.Address == address {
+			return info
+		}
+	}
+
+	t.Fatalf("%s is not in the address book", address)
+	return PeerInfo{}
+}
+
+// Returns whether the address would be dialed now.
+func dialable(book *AddressBook, address string) bool {
+	for _, candidate := range append(book.Persistent(nil), book.Candidates(nil, maxAddressBookSize)...) {
+		if candidate == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestReconnectDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, minReconnectDelay},
+		{2, 2 * minReconnectDelay},
+		{3, 4 * minReconnectDelay},
+		{8, 128 * minReconnectDelay},
+		{9, maxReconnectDelay},
+		{100, maxReconnectDelay},
+	}
+
+	for _, test := range tests {
+		if got := reconnectDelay(test.attempts); got != test.want {
+			t.Errorf("delay after %d attempts is %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+// Every failed attempt doubles the wait before the next one, up to maxReconnectDelay.
+func TestAddressBookBackoff(t *testing.T) {
+	book, clock := newTestAddressBook()
+	address := "127.0.0.1:9000"
+	book.AddPersistent(1, address) // persistent, so it is not forgotten however often it fails
+
+	for attempts := 1; attempts <= 12; attempts++ {
+		book.Attempt(address)
+		if dialable(book, address) {
+			t.Fatalf("address is dialed again while it is being dialed")
+		}
+		book.Bad(address)
+
+		delay := reconnectDelay(attempts)
+		if delay > maxReconnectDelay {
+			t.Fatalf("delay after %d attempts is %v, above the cap", attempts, delay)
+		}
+		if info := peerInfo(t, book, address); info.Attempts != attempts || !info.NextAttempt.Equal(clock.Now().Add(delay)) {
+			t.Fatalf("after %d attempts the book has %d attempts, next attempt at %v, want in %v", attempts, info.Attempts, info.NextAttempt, delay)
+		}
+
+		clock.Advance(delay - time.Nanosecond)
+		if dialable(book, address) {
+			t.Fatalf("address is dialed before its delay of %v is over", delay)
+		}
+		clock.Advance(time.Nanosecond)
+		if !dialable(book, address) {
+			t.Fatalf("address is not dialed after its delay of %v", delay)
+		}
+	}
+
+	// a successful connection starts the backoff over, a lost one waits the shortest delay
+	book.Attempt(address)
+	book.Good(address)
+	if info := peerInfo(t, book, address); info.Attempts != 0 || info.State != PeerConnected {
+		t.Fatalf("after a success the book has %d attempts and state %v", info.Attempts, info.State)
+	}
+	book.Disconnected(address)
+	if dialable(book, address) {
+		t.Fatal("lost address is dialed again right away")
+	}
+	clock.Advance(minReconnectDelay)
+	if !dialable(book, address) {
+		t.Fatal("lost address is not dialed after the shortest delay")
+	}
+	book.Attempt(address)
+	book.Bad(address)
+	if info := peerInfo(t, book, address); !info.NextAttempt.Equal(clock.Now().Add(minReconnectDelay)) {
+		t.Fatalf("first failure after a success waits until %v, want %v", info.NextAttempt, minReconnectDelay)
+	}
+}
+
+func TestAddressBookScore(t *testing.T) {
+	book, clock := newTestAddressBook()
+	good, bad, persistent := "127.0.0.1:9001", "127.0.0.1:9002", "127.0.0.1:9003"
+	book.Add(good)
+	book.Add(bad)
+	book.AddPersistent(3, persistent)
+
+	for i := 0; i < maxAddressScore+5; i++ {
+		book.Attempt(good)
+		book.Good(good)
+		book.Disconnected(good)
+	}
+	if score := peerInfo(t, book, good).Score; score != maxAddressScore {
+		t.Fatalf("score of a good address is %d, want the cap %d", score, maxAddressScore)
+	}
+
+	// the address that worked is tried first
+	book.Attempt(bad)
+	book.Bad(bad)
+	clock.Advance(maxReconnectDelay)
+	if candidates := book.Candidates(nil, 2); !reflect.DeepEqual(candidates, []string{good, bad}) {
+		t.Fatalf("candidates are %v, want %v", candidates, []string{good, bad})
+	}
+	if candidates := book.Candidates(nil, 1); !reflect.DeepEqual(candidates, []string{good}) {
+		t.Fatalf("one candidate is %v, want %v", candidates, []string{good})
+	}
+	if candidates := book.Candidates(map[string]bool{good: true}, 2); !reflect.DeepEqual(candidates, []string{bad}) {
+		t.Fatalf("candidates without the excluded address are %v, want %v", candidates, []string{bad})
+	}
+
+	// an address that keeps failing is forgotten once its score falls below minAddressScore, a persistent one never
+	for score := peerInfo(t, book, bad).Score; score > minAddressScore; score-- {
+		book.Bad(bad)
+	}
+	peerInfo(t, book, bad)
+	book.Bad(bad)
+	for _, info := range book.Info() {
+		if info.Address == bad {
+			t.Fatalf("address with score below %d is still in the book", minAddressScore)
+		}
+	}
+
+	for i := 0; i < 2*maxAddressScore; i++ {
+		book.Bad(persistent)
+	}
+	if score := peerInfo(t, book, persistent).Score; score >= minAddressScore {
+		t.Fatalf("score of the persistent address is %d", score)
+	}
+}
+
+func TestAddressBookBan(t *testing.T) {
+	book, clock := newTestAddressBook()
+	address, other := "127.0.0.1:9004", "127.0.0.1:9005"
+	book.AddPersistent(4, address)
+	book.Add(other)
+	book.Identified(address, "identity", 10, ProtocolVersion)
+	book.Identified(other, "identity", 10, ProtocolVersion)
+
+	book.Ban(address, time.Hour)
+	if !book.IsBanned(address) || dialable(book, address) {
+		t.Fatal("banned address can be dialed")
+	}
+	if book.IsBanned(other) || !book.IsBannedNode(other, "identity") {
+		t.Fatal("ban does not cover the other address of the banned node")
+	}
+	if book.IsBannedNode(other, "someone else") {
+		t.Fatal("ban covers another node")
+	}
+
+	// a disconnect does not lift the ban
+	book.Disconnected(address)
+	if info := peerInfo(t, book, address); info.State != PeerBanned || !info.BannedUntil.Equal(clock.Now().Add(time.Hour)) {
+		t.Fatalf("after a disconnect the state is %v, banned until %v", info.State, info.BannedUntil)
+	}
+
+	clock.Advance(time.Hour - time.Nanosecond)
+	if !book.IsBanned(address) || dialable(book, address) {
+		t.Fatal("ban ended early")
+	}
+	clock.Advance(time.Nanosecond)
+	if book.IsBanned(address) || book.IsBannedNode(other, "identity") || !dialable(book, address) {
+		t.Fatal("ban did not end")
+	}
+}
+
+// Addresses are exchanged at most maxAddressesPerMessage at a time, and only valid and new ones are kept.
+func TestAddressGossipLimits(t *testing.T) {
+	node := newTestNode(t, 0)
+	node.SetListenAddress("127.0.0.1:8000")
+	book := node.peerManager.book
+
+	var announced []string
+	for port := 10000; port < 10000+maxAddressesPerMessage; port++ {
+		announced = append(announced, fmt.Sprintf("127.0.0.1:%d", port))
+	}
+
+	var reply AddrAnnounceReply
+	if err := node.AddrAnnounce(AddrAnnounceArg{Addresses: append(announced, "127.0.0.1:1")}, &reply); err == nil {
+		t.Fatal("announcement of too many addresses was accepted")
+	}
+	if book.Size() != 0 {
+		t.Fatalf("rejected announcement added %d addresses", book.Size())
+	}
+
+	if err := node.AddrAnnounce(AddrAnnounceArg{Addresses: announced}, &reply); err != nil || reply.Added != maxAddressesPerMessage {
+		t.Fatalf("announcement added %d addresses: %v", reply.Added, err)
+	}
+
+	// known, invalid and own addresses are not added
+	reply = AddrAnnounceReply{}
+	mixed := []string{announced[0], "127.0.0.1", "127.0.0.1:0", ":8000", "127.0.0.1:70000", "127.0.0.1:8000", "127.0.0.1:20000"}
+	if err := node.AddrAnnounce(AddrAnnounceArg{Addresses: mixed}, &reply); err != nil || reply.Added != 1 {
+		t.Fatalf("announcement added %d addresses, want 1: %v", reply.Added, err)
+	}
+
+	for _, limit := range []int{0, -1, maxAddressesPerMessage + 1, 1000} {
+		var peers GetPeersReply
+		if err := node.GetPeers(GetPeersArg{Limit: limit}, &peers); err != nil || len(peers.Addresses) != maxAddressesPerMessage {
+			t.Fatalf("GetPeers with limit %d returned %d addresses: %v", limit, len(peers.Addresses), err)
+		}
+	}
+	var peers GetPeersReply
+	if err := node.GetPeers(GetPeersArg{Limit: 5}, &peers); err != nil || len(peers.Addresses) != 5 {
+		t.Fatalf("GetPeers with limit 5 returned %d addresses: %v", len(peers.Addresses), err)
+	}
+
+	// the book stops growing at maxAddressBookSize
+	for port := 30000; book.Size() < maxAddressBookSize; port++ {
+		book.Add(fmt.Sprintf("127.0.0.1:%d", port))
+	}
+	if book.Add("127.0.0.1:40000") || book.Size() != maxAddressBookSize {
+		t.Fatalf("full address book took another address, size %d", book.Size())
+	}
+}
+
+// Persistent peers are dialed even when the node needs no more peers, and dialed again after every failure.
+func TestPersistentPeersRedialed(t *testing.T) {
+	node := newTestNode(t, 0)
+	node.SetTargetPeers(0)
+	clock := &fakeClock{now: time.Now()}
+	book := node.peerManager.book
+	book.now = clock.Now
+
+	unreachable, candidate := "127.0.0.1:1", "127.0.0.1:2"
+	node.AddPeer(1, unreachable)
+	node.AddSeed(candidate)
+
+	for attempts := 1; attempts <= 3; attempts++ {
+		node.peerManager.fillPeers()
+		if info := peerInfo(t, book, unreachable); info.Attempts != attempts || info.State != PeerDisconnected {
+			t.Fatalf("persistent peer has %d attempts and state %v, want %d attempts", info.Attempts, info.State, attempts)
+		}
+
+		// not dialed again before its delay is over
+		node.peerManager.fillPeers()
+		if info := peerInfo(t, book, unreachable); info.Attempts != attempts {
+			t.Fatalf("persistent peer was dialed again before its delay, %d attempts", info.Attempts)
+		}
+		clock.Advance(reconnectDelay(attempts))
+	}
+	if info := peerInfo(t, book, candidate); info.Attempts != 0 {
+		t.Fatalf("node that needs no peers dialed a seed %d times", info.Attempts)
+	}
+
+	// a persistent peer that is reachable again is connected to, and reconnected to after it was lost
+	peer := newTestNode(t, 2)
+	server := rpc.NewServer()
+	if err := server.RegisterName("Node", peer); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	address := strings.TrimPrefix(httpServer.URL, "http://")
+	node.AddPeer(2, address)
+
+	node.peerManager.fillPeers()
+	if peers := node.GetPeerAddresses(); !reflect.DeepEqual(peers, []string{address}) {
+		t.Fatalf("node is connected to %v, want %s", peers, address)
+	}
+
+	node.peerManager.disconnect(address)
+	node.peerManager.fillPeers()
+	if peers := node.GetPeerAddresses(); len(peers) != 0 {
+		t.Fatalf("lost peer was dialed again right away, connected to %v", peers)
+	}
+	clock.Advance(minReconnectDelay)
+	node.peerManager.fillPeers()
+	if peers := node.GetPeerAddresses(); !reflect.DeepEqual(peers, []string{address}) {
+		t.Fatalf("node is connected to %v after the delay, want %s", peers, address)
+	}
+	node.removeConnectedPeer(address)
+}
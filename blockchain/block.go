@@ -16,6 +16,9 @@ import (
 
 var max int = 7
 
+// Version of the block header format, stored in every header.
+const BlockVersion uint32 = 1
+
 // Includes helper methods that allow easy access to find ParentBlockHash, Hash, and Nonce.
 // Arbitrary number of transactions - in this implemenation, we choose 7 transactions per block.
 // Header	contains metaDataof the block
@@ -32,17 +35,28 @@ type Block struct {
 }
 
 // BlockHeader contains metaDataof the block.
+// Version			version of the header format
 // Timestamp 		time when block is added to the chain
 // ParentBlockHash	Hash of the previous block
-// Hash	 			takes Nonce, Timestamps, ParentBlockHash, and root Hash of the transaction Merkle Tree
+// MerkleRoot		root Hash of the transaction Merkle Tree
+// Bits				target the Hash must be below, in compact form (see BigToCompact)
+// Hash	 			takes Nonce, Timestamps, ParentBlockHash, Bits, and root Hash of the transaction Merkle Tree
 // Nonce	 		rand int that is initialised to 0
 type BlockHeader struct {
+	version         uint32
 	timestamp       int64
 	parentBlockHash []byte
+	merkleRoot      []byte
+	bits            uint32
 	hash            []byte
 	nonce           uint64
 }
 
+// Gets the version of the header format of the block.
+func (block *Block) GetVersion() uint32 {
+	return block.header.version
+}
+
 func (block *Block) GetTimestamp() int64 {
 	return block.header.timestamp
 }
@@ -55,6 +69,10 @@ func (block *Block) GetParentBlockHash() []byte {
 	return block.header.parentBlockHash
 }
 
+func (block *Block) GetMerkleRoot() []byte {
+	return block.header.merkleRoot
+}
+
 func (block *Block) GetHash() []byte {
 	return block.header.hash
 }
@@ -79,10 +97,42 @@ func (block *Block) GetTarget() *big.Int {
 	return block.pow.target
 }
 
+func (block *Block) GetBits() uint32 {
+	return block.header.bits
+}
+
+// Sets the target of the block (in compact form), which changes its hash.
+func (block *Block) SetBits(bits uint32) {
+	block.header.bits = bits
+	block.pow = NewPOWFromBits(bits)
+}
+
 func (block *Block) GetDataList() []merkletree.Content {
 	return block.dataList
 }
 
+// Returns the transactions of the block as concrete values, which gob can send without registering types.
+func (block *Block) GetTransactions() []Transaction {
+	transactions := make([]Transaction, 0, len(block.dataList))
+	for _, content := range block.dataList {
+		if transaction, ok := content.(Transaction); ok {
+			transactions = append(transactions, transaction)
+		}
+	}
+
+	return transactions
+}
+
+// Turns transactions into the content of a Merkle Tree.
+func transactionContents(transactions []Transaction) []merkletree.Content {
+	contents := make([]merkletree.Content, 0, len(transactions))
+	for _, transaction := range transactions {
+		contents = append(contents, transaction)
+	}
+
+	return contents
+}
+
 func (block *Block) ResetDataList() {
 	block.dataList = []merkletree.Content{}
 }
@@ -97,15 +147,15 @@ func GetMax() int {
 
 // Part of the Content interface in MerkleTree Package.
 // TODO
-func (Block Block) CalculateHash() ([]byte, error) {
-	Hash := sha256.New()
-	Data := Block.BlockDataToBytes()
+func (Block *Block) CalculateHash() ([]byte, error) {
+	return hashBytes(Block.BlockDataToBytes()), nil
+}
 
-	if _, err := Hash.Write(Data); err != nil {
-		return nil, err
-	}
+// Returns the SHA-256 hash of data.
+func hashBytes(data []byte) []byte {
+	hash := sha256.Sum256(data)
 
-	return Hash.Sum(nil), nil
+	return hash[:]
 }
 
 // need to set Hash of the block after it is added to the chain
@@ -113,8 +163,8 @@ func (Block Block) CalculateHash() ([]byte, error) {
 // because the Data(MerkleTree of transacitons) must be set before the Hash can be set
 // Part of the Content interface in MerkleTree Package
 // TODO
-func (block Block) Equals(other merkletree.Content) (bool, error) {
-	return block.data == other.(Block).data, nil
+func (block *Block) Equals(other merkletree.Content) (bool, error) {
+	return block.data == other.(*Block).data, nil
 }
 
 // NewBlockChain creates a new block and returns the pointer to it.
@@ -123,12 +173,18 @@ func (block Block) Equals(other merkletree.Content) (bool, error) {
 // ParentBlockHash	to the Hash of the previous block (gotten from parameter)
 // Hash				to empty byte array, to be set after a Nonce is found (after mining when adding to chain)
 // Nonce 			to 0
+// Bits				to the default difficulty, blocks built on a chain get theirs from BlockChain.GetNextBits
 // DataList 		to empty array of type (merkletree.Content)
 // Pow				to a new ProofOfWork struct
 func MakeBlock(pBlockHash []byte) *Block {
+	pow := NewPOW()
+
 	header := &BlockHeader{
+		version:         BlockVersion,
 		timestamp:       time.Now().UnixNano(),
 		parentBlockHash: pBlockHash,
+		merkleRoot:      []byte{},
+		bits:            BigToCompact(pow.target),
 		hash:            []byte{},
 		nonce:           0,
 	}
@@ -136,17 +192,20 @@ func MakeBlock(pBlockHash []byte) *Block {
 	block := &Block{
 		header:   *header,
 		dataList: []merkletree.Content{},
-		pow:      NewPOW(),
+		pow:      pow,
 	}
 
 	return block
 }
 
 // When adding block for conesnsus (adding block data from RPC)
-func MakeAddBlock(time int64, pBlockHash []byte, nonc uint64, dl []merkletree.Content) *Block {
+func MakeAddBlock(time int64, pBlockHash []byte, bits uint32, nonc uint64, dl []merkletree.Content) *Block {
 	header := &BlockHeader{
+		version:         BlockVersion,
 		timestamp:       time,
 		parentBlockHash: pBlockHash,
+		merkleRoot:      []byte{},
+		bits:            bits,
 		hash:            []byte{},
 		nonce:           nonc,
 	}
@@ -156,44 +215,66 @@ func MakeAddBlock(time int64, pBlockHash []byte, nonc uint64, dl []merkletree.Co
 	// used in transaction RPCs
 	if err != nil {
 		tree = nil
+	} else {
+		header.merkleRoot = tree.MerkleRoot()
 	}
 
 	block := &Block{
 		header:   *header,
 		data:     tree,
 		dataList: dl,
-		pow:      NewPOW(),
+		pow:      NewPOWFromBits(bits),
 	}
 
 	return block
 }
 
-// Takes MakeBlock() and passes empty byte array to it
-// Has no parent block, so ParentBlockHash is empty (empty byte array)
-// time should be in UnixNano
-func MakeGenesisBlock() *Block {
-	genesis := MakeBlock([]byte{}) // just the genesis block, so it does not have to be set into node.Block
-
-	for i := 0; i < max; i++ {
-		emptyTransaction := Transaction{
-			Sender:    []byte{},
-			Recipient: []byte{},
-			Timestamp: 0,
-			Data:      []byte("init"),
+// Rebuilds a block from a complete header and its transactions (a block received from another node or read from disk).
+// The Merkle root is kept as it is in the header, so ValidateBlock can check it against the transactions.
+// The hash is not set, it has to be calculated or taken from the sender.
+func MakeBlockFromHeader(header BlockHeader, dl []merkletree.Content) (*Block, error) {
+	var tree *merkletree.MerkleTree
+
+	if len(dl) > 0 {
+		var err error
+		if tree, err = merkletree.NewTree(dl); err != nil {
+			return nil, err
 		}
+	}
+
+	block := &Block{
+		header:   header,
+		data:     tree,
+		dataList: dl,
+		pow:      NewPOWFromBits(header.bits),
+	}
+
+	return block, nil
+}
 
-		genesis.Add(emptyTransaction)
+// Builds the genesis block of the default genesis spec (see DefaultGenesis)
+// Has no parent block, so ParentBlockHash is empty (empty byte array)
+// The timestamp is fixed and mining starts at nonce 0, so every node gets the same genesis block
+func MakeGenesisBlock() *Block {
+	genesis, err := DefaultGenesis().MakeBlock()
+	if err != nil {
+		log.Fatal(err)
 	}
-	genesis.Mine()
-	fmt.Println("Genesis block created!")
+	logDebug("Genesis block created. Hash: %x", genesis.GetHash())
 
 	return genesis
 }
 
-// NOT USED for adding the transactions that it creates by itself
-// Used in RPCs
+// Adds a transaction to the block and rebuilds the Merkle Tree of the transactions.
+// Used to build block templates from the mempool
 // internal function
+// Rejects transactions whose signature does not verify
 func (block *Block) Add(transaction Transaction) error {
+	if err := transaction.Verify(); err != nil {
+		fmt.Println("Transaction is not signed by its sender, cannot add it to the block")
+		return err
+	}
+
 	if len(block.dataList) >= max {
 		fmt.Println("Block is full, cannot add more transactions")
 		return errors.New("Block is full, cannot add more transactions")
@@ -213,57 +294,13 @@ func (block *Block) Add(transaction Transaction) error {
 				log.Fatal(err)
 			}
 		}
+
+		block.header.merkleRoot = block.data.MerkleRoot()
 	}
 
 	return nil
 }
 
-// AddTransaction is of type Block and takes paramater of type Transaction.
-// Checks if the block is full (var max transactions, arbitrarily set) and if it is, prints error message.
-// If the transaction MerkleTree is empty, creates a new Merkle Tree with the transaction.
-// If it is not empty, rebuilds the Merkle Tree with the new transaction.
-func (block *Block) AddTransaction(transaction Transaction, chain *BlockChain, node *Node) {
-	if len(block.dataList) > max { // Should never run this, this is only here just in case
-		fmt.Println("Block is full, cannot add more transactions")
-
-	} else if len(block.dataList) == max-1 {
-		block.Add(transaction) // still needs to add the transaction
-		fmt.Println("Block is full, attempting to mine and add block to chain")
-
-		block.wg.Add(1)
-		go func() {
-			defer block.wg.Done()
-			chain.AddBlock(block)
-		}()
-		block.wg.Wait()
-		fmt.Println(">>> Succesfully added block to chain")
-
-		block.wg.Add(1)
-		go func() {
-			defer block.wg.Done()
-			node.SendBlock(block)
-		}()
-		block.wg.Wait()
-		fmt.Println(">>> Succesfully sent block (to be added to chain) to nodes")
-
-		node.Block = MakeBlock(block.GetHash())
-		node.Block.wg.Add(1)
-		go func() {
-			defer node.Block.wg.Done()
-			node.SendBlock(node.Block)
-		}()
-		node.Block.wg.Wait()
-		fmt.Println(">>> Succesfully sent empty block to nodes")
-
-	} else {
-		fmt.Println("Adding transaction to block")
-		block.Add(transaction)
-
-		// sending transactions to all of the nodes
-		node.SendTransaction(transaction)
-	}
-}
-
 // Function used to set parent Hash
 // Used when first block is added to the blockchain after the genesis block.
 // Otherwise, the parent Hash will be empty for this block, and it will not be added.
@@ -272,7 +309,7 @@ func (block *Block) SetBlockParentHash(Hash []byte) {
 }
 
 // String representation of Block
-func (block Block) String() string {
+func (block *Block) String() string {
 	str := "**Block**\n"
 	str += block.header.String()
 	str += "Data(String representation of Transactions Merkle Tree):\n"
@@ -285,6 +322,8 @@ func (block Block) String() string {
 func (Header *BlockHeader) String() string {
 	str := "Timestamp: " + strconv.FormatInt(Header.timestamp, 10) + "\n"
 	str += "Parent Block Hash: " + hex.EncodeToString(Header.parentBlockHash) + "\n"
+	str += "Merkle Root: " + hex.EncodeToString(Header.merkleRoot) + "\n"
+	str += "Bits: " + strconv.FormatUint(uint64(Header.bits), 16) + "\n"
 	str += "Hash: " + hex.EncodeToString(Header.hash) + "\n"
 
 	return str
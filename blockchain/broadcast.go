@@ -0,0 +1,88 @@
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Time one peer gets to answer an announcement, and again to take the data.
+const broadcastTimeout = 5 * time.Second
+
+// Result of sending an item to one peer.
+// Wanted		the peer did not have the item and asked for it
+// Accepted		the peer took the item (added the block to its chain or the transaction to its mempool)
+// Err			why the announcement or the delivery failed (nil if both worked)
+// Duration		time the peer took for both
+type PeerResult struct {
+	Address  string
+	Wanted   bool
+	Accepted bool
+	Err      error
+	Duration time.Duration
+}
+
+// BroadcastReport collects what every peer did with a broadcast item.
+type BroadcastReport struct {
+	Item    InvItem
+	Results []PeerResult
+}
+
+// Accepted returns the number of peers that took the item.
+func (report *BroadcastReport) Accepted() int {
+	count := 0
+	for _, result := range report.Results {
+		if result.Accepted {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Failed returns the number of peers that could not be reached or did not answer in time.
+func (report *BroadcastReport) Failed() int {
+	count := 0
+	for _, result := range report.Results {
+		if result.Err != nil {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (report *BroadcastReport) String() string {
+	return fmt.Sprintf("%x: accepted by %d of %d peers, %d failed", report.Item.Hash, report.Accepted(), len(report.Results), report.Failed())
+}
+
+// Announces the item to every connected peer at the same time and calls deliver for each peer that wants it.
+// Every peer gets broadcastTimeout for the announcement and again for the delivery,
+// so a peer that hangs only delays its own result. Returns once every peer answered or timed out.
+func (node *Node) broadcast(item InvItem, deliver func(peer ServerConnection) (bool, error)) *BroadcastReport {
+	peers := node.connectedPeers()
+	report := &BroadcastReport{Item: item, Results: make([]PeerResult, len(peers))}
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(result *PeerResult, peer ServerConnection) {
+			defer wg.Done()
+
+			start := time.Now()
+			result.Address = peer.address
+			result.Wanted, result.Err = node.announce(peer, item)
+			if result.Wanted {
+				result.Accepted, result.Err = deliver(peer)
+			}
+			result.Duration = time.Since(start)
+
+			if result.Err != nil {
+				logWarn("Response >>> could not send %x to %s: %v", item.Hash, peer.address, result.Err)
+			}
+		}(&report.Results[i], peer)
+	}
+	wg.Wait()
+
+	return report
+}
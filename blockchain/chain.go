@@ -5,133 +5,579 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"strconv"
+	"math/big"
 	"sync"
 	"time"
 
 	"github.com/cbergoon/merkletree"
 )
 
+var (
+	ErrUnknownParent = errors.New("parent block is unknown")
+	ErrBlockExists   = errors.New("block is already known")
+)
+
 // BlockChain structure links together blocks in a Merkle Tree.
+// Every valid block is kept in a tree of blocks, including the ones on side branches.
+// The canonical chain is the branch with the most cumulative proof of work.
 // currentBlock		block that is being filled up with transactions has NOT been added to the chain yet
 // chain			chain of blocks that have been added to the chain
+// blockList		blocks of the canonical chain, from genesis to root
+// blocks			every known block (canonical or not) by hash
+// transactions		block of the canonical chain each transaction is in, by transaction hash
+// tip				tree entry of the root block
+// store			on-disk storage of the accepted blocks (nil if the chain only lives in memory)
+// chainID			chain ID from the genesis spec
+// reorgHandler		called with the transactions of blocks that left the canonical chain during a reorg
+// tipHandler		called with the new root whenever the root of the canonical chain changes
+// events			bus the accepted blocks, reorgs and new roots are published on (the mempool publishes its transactions on it too)
+// mutex			guards everything above except genesis, chainID and events, which never change.
+//
+//	Blocks are added under the write lock, every getter takes the read lock.
+//	Methods that start with a lower case letter expect the caller to hold the lock.
+//
+// publishMutex		held by acceptBlock from linking a block until its events are published and its handlers are called,
+//
+//	so the events of two blocks are published in the order the blocks were linked.
+//	It is taken before mutex, and handlers must not add blocks.
 type BlockChain struct {
 	root      *Block
 	genesis   *Block
+	chainID   string
 	chain     *merkletree.MerkleTree
 	blockList []merkletree.Content
+	blocks    map[string]*blockNode
+	tip       *blockNode
+	store     *BlockStore
+
+	transactions map[string]*Block
+
+	reorgHandler func(rolledBack []Transaction)
+	tipHandler   func(root *Block)
+	events       *EventBus
+
+	mutex        sync.RWMutex
+	publishMutex sync.Mutex
+}
 
-	wg    sync.WaitGroup
-	mutex sync.Mutex
+// Entry of a block in the block tree.
+// parent	entry of the parent block (nil for genesis)
+// height	number of blocks between it and genesis (genesis is 0)
+// work		cumulative proof of work of all the blocks from genesis up to and including this one
+type blockNode struct {
+	block  *Block
+	parent *blockNode
+	height int
+	work   *big.Int
 }
 
-// NewBlockChain creates a new blockchain with a genesis block.
-func NewBlockChain() *BlockChain {
-	genesis := MakeGenesisBlock()
+// NewBlockChain creates a new blockchain with the genesis block of the spec.
+// If a store is given, the blocks saved in it are reloaded and re-verified,
+// and every block that is accepted afterwards is persisted to it.
+// An empty store gets the genesis block written to it.
+// A store whose genesis block is not the one of the spec belongs to another chain and is rejected.
+func NewBlockChain(spec *GenesisSpec, store *BlockStore) (*BlockChain, error) {
+	var blocks []*Block
+
+	genesis, err := spec.MakeBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if store != nil {
+		stored, err := store.LoadBlocks()
+		if err != nil {
+			return nil, err
+		}
+		blocks = stored
+	}
+
+	if len(blocks) == 0 {
+		if store != nil {
+			if err := store.Append(genesis); err != nil {
+				return nil, err
+			}
+		}
+		blocks = []*Block{genesis}
+	}
+
+	if !bytes.Equal(blocks[0].GetHash(), genesis.GetHash()) {
+		return nil, fmt.Errorf("stored genesis block %x does not match the genesis block %x of chain %s", blocks[0].GetHash(), genesis.GetHash(), spec.ChainID)
+	}
+
 	list := []merkletree.Content{genesis}
 
 	tree, err := merkletree.NewTree(list)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	genesisNode := &blockNode{
+		block:  genesis,
+		height: 0,
+		work:   blockWork(genesis),
 	}
 
 	blockChain := &BlockChain{
 		root:      genesis,
 		genesis:   genesis,
+		chainID:   spec.ChainID,
 		chain:     tree,
 		blockList: list,
+		blocks:    map[string]*blockNode{string(genesis.GetHash()): genesisNode},
+		tip:       genesisNode,
+
+		transactions: make(map[string]*Block),
+		events:       NewEventBus(),
+	}
+	blockChain.indexTransactions(genesis)
+
+	// replays the stored blocks, checking every block again before it is linked
+	// blocks are stored in the order they were accepted, so parents always come before their children
+	for i, block := range blocks[1:] {
+		if err := blockChain.validateBlock(block); err != nil {
+			return nil, fmt.Errorf("stored block %d is invalid: %v", i+2, err)
+		}
+
+		if _, _, err := blockChain.insertBlock(block); err != nil {
+			return nil, fmt.Errorf("stored block %d could not be linked: %v", i+2, err)
+		}
 	}
 
-	return blockChain
+	if len(blocks) > 1 {
+		logInfo("Loaded %d blocks from disk.", len(blocks))
+	}
+
+	blockChain.store = store
+
+	return blockChain, nil
 }
 
 // Gets the root of the blockchain.
 func (blockChain *BlockChain) GetRoot() *Block {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
 	return blockChain.root
 }
 
+// Gets the genesis block of the blockchain.
+func (blockChain *BlockChain) GetGenesis() *Block {
+	return blockChain.genesis // never changes, no lock needed
+}
+
+// Gets the chain ID from the genesis spec.
+func (blockChain *BlockChain) GetChainID() string {
+	return blockChain.chainID // never changes, no lock needed
+}
+
 func (blockChain *BlockChain) GetBlockListLen() int {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
 	return len(blockChain.blockList)
 }
 
-// AddBlock adds a block to the blockchain.
-// Does not allow block to be added if not full.
-// Checks if the hash of the to-be-added block and parent block exists.
-// checks if the root hash (of the BlockChain Merkle Tree) is equal to the parent block hash of the to-be-added block.
+// Gets the height of the root (genesis has height 0).
+func (blockChain *BlockChain) GetHeight() int {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	return blockChain.tip.height
+}
+
+// Gets the cumulative proof of work of the canonical chain.
+func (blockChain *BlockChain) GetTotalWork() *big.Int {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	return new(big.Int).Set(blockChain.tip.work)
+}
+
+// Gets a known block by its hash, whether it is on the canonical chain or on a side branch.
+// Returns nil if the block is unknown.
+func (blockChain *BlockChain) GetBlock(hash []byte) *Block {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	node, ok := blockChain.blocks[string(hash)]
+	if !ok {
+		return nil
+	}
+
+	return node.block
+}
+
+// Gets the height of a known block, whether it is on the canonical chain or on a side branch.
+// Returns -1 if the block is unknown.
+func (blockChain *BlockChain) GetBlockHeight(hash []byte) int {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	node, ok := blockChain.blocks[string(hash)]
+	if !ok {
+		return -1
+	}
+
+	return node.height
+}
+
+// Gets the block of the canonical chain at the given height.
+// Returns nil if the height is past the root.
+func (blockChain *BlockChain) GetBlockByHeight(height int) *Block {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	return blockChain.blockByHeight(height)
+}
+
+func (blockChain *BlockChain) blockByHeight(height int) *Block {
+	if height < 0 || height >= len(blockChain.blockList) {
+		return nil
+	}
+
+	return blockChain.blockList[height].(*Block)
+}
+
+// Checks if the block with the given hash is on the canonical chain.
+func (blockChain *BlockChain) IsCanonical(hash []byte) bool {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	return blockChain.isCanonical(hash)
+}
+
+func (blockChain *BlockChain) isCanonical(hash []byte) bool {
+	node, ok := blockChain.blocks[string(hash)]
+	if !ok {
+		return false
+	}
+
+	return blockChain.blockByHeight(node.height) == node.block
+}
+
+// HasTransaction checks if the transaction with the given hash is in a block of the canonical chain.
+func (blockChain *BlockChain) HasTransaction(hash []byte) bool {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	_, ok := blockChain.transactions[string(hash)]
+
+	return ok
+}
+
+// GetTransaction returns the transaction with the given hash and the canonical block it is in.
+// Returns nil if the transaction is not in the canonical chain.
+func (blockChain *BlockChain) GetTransaction(hash []byte) (*Transaction, *Block) {
+	blockChain.mutex.RLock()
+	block, ok := blockChain.transactions[string(hash)]
+	blockChain.mutex.RUnlock()
+
+	if !ok {
+		return nil, nil
+	}
+
+	for _, content := range block.GetDataList() {
+		contentHash, _ := content.CalculateHash()
+
+		if transaction, ok := content.(Transaction); ok && bytes.Equal(contentHash, hash) {
+			return &transaction, block
+		}
+	}
+
+	return nil, nil
+}
+
+// Events returns the bus the chain publishes accepted blocks, reorgs and new roots on,
+// and the mempool of the chain its admitted transactions.
+func (blockChain *BlockChain) Events() *EventBus {
+	return blockChain.events // never changes, no lock needed
+}
+
+// SetReorgHandler sets the function that is called when a reorg takes blocks off the canonical chain.
+// It receives the transactions of those blocks that are not in the blocks of the new branch,
+// so they can be put back into the pending transactions.
+func (blockChain *BlockChain) SetReorgHandler(handler func(rolledBack []Transaction)) {
+	blockChain.mutex.Lock()
+	defer blockChain.mutex.Unlock()
+
+	blockChain.reorgHandler = handler
+}
+
+// Sets the function that is called with the new root whenever the root of the canonical chain changes.
+// It is called after the chain is updated, without holding the lock, so it may read the chain.
+func (blockChain *BlockChain) SetTipHandler(handler func(root *Block)) {
+	blockChain.mutex.Lock()
+	defer blockChain.mutex.Unlock()
+
+	blockChain.tipHandler = handler
+}
+
+// AddBlock adds a block that has already been mined (see Block.MineParallel) to the blockchain.
+// The block is checked with ValidateBlock, it is not mined again.
+// The parent does not have to be the root: a block on another branch is kept on a side branch,
+// and becomes part of the canonical chain once its branch has the most proof of work.
 func (blockChain *BlockChain) AddBlock(block *Block) error {
-	blockChain.wg.Add(1)
-	go func() {
-		defer blockChain.wg.Done()
+	return blockChain.acceptBlock(block)
+}
 
-		block.Mine()
-	}()
-	blockChain.wg.Wait()
+// Adds a block received from another node to the blockchain.
+// correctHash is the hash the sender claims the block has. The header hash is calculated once from the
+// transmitted nonce and has to match it, the proof of work is not redone.
+func (blockChain *BlockChain) AddConsensusBlock(block *Block, correctHash []byte) error {
+	block.SetHash(correctHash)
 
-	rootHash := blockChain.root.GetHash()
-	blockHash := block.GetParentBlockHash()
+	return blockChain.acceptBlock(block)
+}
 
-	if len(block.GetDataList()) < GetMax() {
-		fmt.Println("Block is not full, cannot add to chain.")
+// Validates a mined block against the chain, saves it to disk and links it into the block tree.
+// Prints whether it extended the canonical chain or was kept on a side branch.
+// The events of the block and the reorg and tip handlers are called once the lock is released,
+// with what linkBlock saw while it held the lock. EventNewHead and the tip handler only follow a block that moved the root.
+// The publish mutex is held throughout, so a block linked later cannot publish before this one.
+func (blockChain *BlockChain) acceptBlock(block *Block) error {
+	blockChain.publishMutex.Lock()
+	defer blockChain.publishMutex.Unlock()
 
-		return errors.New("Block is not full, cannot add to chain")
+	result, err := blockChain.linkBlock(block)
+	if err != nil {
+		return err
+	}
 
-		// blockHash exists         parentHash Exists      rootHash == parentBlockHash
-	} else if block.GetHash() != nil && rootHash != nil && bytes.Equal(blockHash, rootHash) {
-		blockChain.blockList = append(blockChain.blockList, block)
-		blockChain.root = block
+	blockChain.events.Publish(Event{Type: EventBlock, Block: block, Height: result.height, Canonical: result.tipMoved})
+	if !result.tipMoved {
+		return nil
+	}
 
-		blockChain.chain.RebuildTreeWith(blockChain.blockList) // rebuilds chain and sets blockChain.chain to the new chain
+	if result.reorg != nil {
+		blockChain.events.Publish(Event{Type: EventReorg, Reorg: result.reorg})
 
-		fmt.Println("Block " + strconv.Itoa(len(blockChain.blockList))+ " added to chain.")
+		if result.reorgHandler != nil && len(result.reorg.RolledBack) > 0 {
+			result.reorgHandler(result.reorg.RolledBack)
+		}
+	}
 
-	} else {
-		fmt.Println("Block hash does not match root hash, or block hash does not exist.")
+	blockChain.events.Publish(Event{Type: EventNewHead, Block: result.root, Height: result.rootHeight})
 
-		return errors.New("Block hash does not match root hash, or block hash does not exist")
+	if result.tipHandler != nil {
+		result.tipHandler(result.root)
 	}
 
 	return nil
 }
 
-// Adds block to the blockchain when running consensus.
-func (blockChain *BlockChain) AddConsensusBlock(block *Block, correctHash []byte) error {
-	blockChain.wg.Add(1)
-	go func() {
-		defer blockChain.wg.Done()
+// What linkBlock did with a block, taken while it held the lock so later blocks cannot change it.
+// height			height of the block in the block tree
+// tipMoved			whether the block became the root of the canonical chain
+// root				root of the canonical chain right after the block was linked, and its height
+// reorg			the switch to another branch the block caused (nil if there was none)
+// reorgHandler		handlers to call once the lock is released
+type linkResult struct {
+	height     int
+	tipMoved   bool
+	root       *Block
+	rootHeight int
+	reorg      *Reorg
+
+	reorgHandler func(rolledBack []Transaction)
+	tipHandler   func(root *Block)
+}
 
-		block.Mine()
-	}()
-	blockChain.wg.Wait()
+// The part of acceptBlock that holds the write lock.
+// Returns where the block was linked and whether it moved the root of the canonical chain.
+func (blockChain *BlockChain) linkBlock(block *Block) (*linkResult, error) {
+	blockChain.mutex.Lock()
+	defer blockChain.mutex.Unlock()
 
-	rootHash := blockChain.root.GetHash()
-	blockParentHash := block.GetParentBlockHash()
+	if _, known := blockChain.blocks[string(block.GetHash())]; known && len(block.GetHash()) != 0 {
+		logDebug("Block is already in the chain.")
 
-	if !bytes.Equal(block.GetHash(), correctHash){
-		fmt.Println("Block hash does not match consensus hash.")
+		return nil, ErrBlockExists
+	}
 
-		return errors.New("consensus not reached")
-	} else if len(block.GetDataList()) < GetMax() {
-		fmt.Println("Block is not full, cannot add to chain.")
+	if err := blockChain.validateBlock(block); err != nil {
+		logWarn("Block is invalid: %v", err)
 
-		return errors.New("block is not full")
+		return nil, err
+	}
 
-		// blockHash exists         parentHash Exists      rootHash == parentBlockHash
-	} else if block.GetHash() != nil && rootHash != nil && bytes.Equal(blockParentHash, rootHash) {
-		blockChain.blockList = append(blockChain.blockList, block)
-		blockChain.root = block
+	if err := blockChain.persist(block); err != nil {
+		logError("Block could not be saved to disk: %v", err)
 
-		blockChain.chain.RebuildTreeWith(blockChain.blockList) // rebuilds chain and sets blockChain.chain to the new chain
+		return nil, err
+	}
+
+	tipMoved, reorg, err := blockChain.insertBlock(block)
+	if err != nil {
+		return nil, err
+	}
 
-		fmt.Println("Block " + strconv.Itoa(len(blockChain.blockList))+ " added to chain.")
+	if tipMoved {
+		logInfo("Block %d added to chain.", len(blockChain.blockList))
 	} else {
-		fmt.Println("Block hash does not match root hash, or block hash does not exist.")
+		logInfo("Block added to a side branch, the canonical chain has more work.")
+	}
+
+	return &linkResult{
+		height:       blockChain.blocks[string(block.GetHash())].height,
+		tipMoved:     tipMoved,
+		root:         blockChain.root,
+		rootHeight:   blockChain.tip.height,
+		reorg:        reorg,
+		reorgHandler: blockChain.reorgHandler,
+		tipHandler:   blockChain.tipHandler,
+	}, nil
+}
+
+// Links a verified block into the block tree under its parent.
+// If its branch now has more cumulative work than the canonical chain, it becomes the new root.
+// Returns whether the block is on the canonical chain afterwards, and the reorg it caused (nil if there was none).
+func (blockChain *BlockChain) insertBlock(block *Block) (bool, *Reorg, error) {
+	hash := string(block.GetHash())
 
-		return errors.New("block hash does not match root hash or does not exist")
+	if _, known := blockChain.blocks[hash]; known {
+		return false, nil, ErrBlockExists
 	}
 
-	return nil
+	parent, known := blockChain.blocks[string(block.GetParentBlockHash())]
+	if !known {
+		return false, nil, ErrUnknownParent
+	}
+
+	node := &blockNode{
+		block:  block,
+		parent: parent,
+		height: parent.height + 1,
+		work:   new(big.Int).Add(parent.work, blockWork(block)),
+	}
+	blockChain.blocks[hash] = node
+
+	if node.work.Cmp(blockChain.tip.work) <= 0 {
+		return false, nil, nil // ties go to the branch that was seen first
+	}
+
+	return true, blockChain.setTip(node), nil
 }
 
+// Makes the given tree entry the root of the canonical chain.
+// If it is not a child of the current root, the blocks after the fork point are rolled back
+// and the reorg is returned, with the transactions that only they contained.
+func (blockChain *BlockChain) setTip(node *blockNode) *Reorg {
+	var reorg *Reorg
+	oldTip := blockChain.tip
+
+	if node.parent == oldTip {
+		blockChain.blockList = append(blockChain.blockList, node.block)
+		blockChain.indexTransactions(node.block)
+	} else {
+		// walks both branches back until they meet
+		var connected []*blockNode
+		newBranch, oldBranch := node, oldTip
+
+		for newBranch.height > oldBranch.height {
+			connected = append(connected, newBranch)
+			newBranch = newBranch.parent
+		}
+		for oldBranch.height > newBranch.height {
+			oldBranch = oldBranch.parent
+		}
+		for newBranch != oldBranch {
+			connected = append(connected, newBranch)
+			newBranch = newBranch.parent
+			oldBranch = oldBranch.parent
+		}
+		fork := newBranch
+
+		disconnected := blockChain.blockList[fork.height+1:]
+		for _, content := range disconnected {
+			blockChain.unindexTransactions(content.(*Block))
+		}
+
+		blockList := append([]merkletree.Content{}, blockChain.blockList[:fork.height+1]...)
+		for i := len(connected) - 1; i >= 0; i-- {
+			blockList = append(blockList, connected[i].block)
+			blockChain.indexTransactions(connected[i].block)
+		}
+		blockChain.blockList = blockList
+
+		logWarn("Reorg: %d blocks rolled back, %d blocks connected from height %d.", len(disconnected), len(connected), fork.height+1)
+
+		reorg = &Reorg{
+			OldRoot:    oldTip.block,
+			NewRoot:    node.block,
+			ForkHeight: fork.height,
+			RolledBack: rolledBackTransactions(disconnected, connected),
+		}
+		for _, content := range disconnected {
+			reorg.Disconnected = append(reorg.Disconnected, content.(*Block))
+		}
+		for i := len(connected) - 1; i >= 0; i-- {
+			reorg.Connected = append(reorg.Connected, connected[i].block)
+		}
+	}
+
+	blockChain.tip = node
+	blockChain.root = node.block
+
+	blockChain.chain.RebuildTreeWith(blockChain.blockList) // rebuilds chain and sets blockChain.chain to the new chain
+
+	return reorg
+}
+
+// Adds the transactions of a block that joined the canonical chain to the transaction index.
+func (blockChain *BlockChain) indexTransactions(block *Block) {
+	for _, content := range block.GetDataList() {
+		hash, _ := content.CalculateHash()
+		blockChain.transactions[string(hash)] = block
+	}
+}
+
+// Removes the transactions of a block that left the canonical chain from the transaction index.
+func (blockChain *BlockChain) unindexTransactions(block *Block) {
+	for _, content := range block.GetDataList() {
+		hash, _ := content.CalculateHash()
+		delete(blockChain.transactions, string(hash))
+	}
+}
+
+// Returns the transactions of the disconnected blocks that are not in any of the connected blocks.
+func rolledBackTransactions(disconnected []merkletree.Content, connected []*blockNode) []Transaction {
+	included := make(map[string]bool)
+
+	for _, node := range connected {
+		for _, content := range node.block.GetDataList() {
+			hash, _ := content.CalculateHash()
+			included[string(hash)] = true
+		}
+	}
+
+	var rolledBack []Transaction
+	for _, content := range disconnected {
+		for _, data := range content.(*Block).GetDataList() {
+			transaction, ok := data.(Transaction)
+			if !ok {
+				continue
+			}
+
+			hash, _ := transaction.CalculateHash()
+			if !included[string(hash)] {
+				included[string(hash)] = true
+				rolledBack = append(rolledBack, transaction)
+			}
+		}
+	}
+
+	return rolledBack
+}
+
+// Writes the block to the store, if the chain has one.
+func (blockChain *BlockChain) persist(block *Block) error {
+	if blockChain.store == nil {
+		return nil
+	}
+
+	return blockChain.store.Append(block)
+}
 
 // Asycnchronously runs the verification of the blockchain every 300 milliseconds.
 // This is to ensure no malicious blocks are added to the chain.
@@ -140,7 +586,9 @@ func (blockChain *BlockChain) RunVerification() {
 
 	for {
 		<-timer.C
+		blockChain.mutex.RLock()
 		check, err := blockChain.chain.VerifyTree()
+		blockChain.mutex.RUnlock()
 
 		if !check {
 			log.Fatal("BlockChain is invalid.")
@@ -156,6 +604,9 @@ func (blockChain *BlockChain) RunVerification() {
 
 // String representation of the blockchain.
 func (blockChain *BlockChain) String() string {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
 	str := "***BlockChain***\n"
 
 	for _, content := range blockChain.blockList {
@@ -0,0 +1,178 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/cbergoon/merkletree"
+)
+
+// Adds the transactions to the mempool of the node, so the next mined block contains them.
+func addPending(t *testing.T, node *Node, transactions ...Transaction) {
+	for _, transaction := range transactions {
+		if _, err := node.Mempool.Add(transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func hasTransaction(t *testing.T, chain *BlockChain, transaction Transaction) bool {
+	hash, err := transaction.CalculateHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chain.HasTransaction(hash)
+}
+
+// Two nodes mine competing branches from genesis. A third node sees the shorter one first,
+// keeps the other as a side branch on a tie and switches to it once it has more work.
+func TestChainReorg(t *testing.T) {
+	shared := newTestTransaction(t, "in both branches")
+	onlyA := newTestTransaction(t, "only in branch a")
+
+	nodeA := newTestNode(t, 0)
+	addPending(t, nodeA, shared, onlyA)
+	a1 := mineTestBlock(t, nodeA, 0)
+
+	nodeB := newTestNode(t, 1)
+	addPending(t, nodeB, shared)
+	b1 := mineTestBlock(t, nodeB, 1)
+	b2 := mineTestBlock(t, nodeB, 1)
+
+	node := newTestNode(t, 2)
+	chain := node.LocalChain
+	subscription := chain.Events().Subscribe(16)
+	defer subscription.Unsubscribe()
+
+	steps := []struct {
+		block *Block
+		root  *Block
+	}{
+		{a1, a1},
+		{b1, a1}, // same work, the branch seen first stays canonical
+		{b2, b2},
+	}
+	for i, step := range steps {
+		if err := chain.AddBlock(step.block); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if chain.GetRoot() != step.root {
+			t.Fatalf("step %d: root is %x, want %x", i, chain.GetRoot().GetHash(), step.root.GetHash())
+		}
+	}
+
+	if err := chain.AddBlock(b1); err != ErrBlockExists {
+		t.Fatalf("adding a known block: err = %v, want %v", err, ErrBlockExists)
+	}
+
+	if chain.GetHeight() != 2 || chain.IsCanonical(a1.GetHash()) || !chain.IsCanonical(b1.GetHash()) {
+		t.Fatal("canonical chain is not genesis, b1, b2")
+	}
+	if chain.GetBlock(a1.GetHash()) == nil {
+		t.Fatal("block of the old branch was forgotten")
+	}
+	if hasTransaction(t, chain, onlyA) || !hasTransaction(t, chain, shared) {
+		t.Fatal("transaction index does not follow the new branch")
+	}
+
+	var reorg *Reorg
+	for reorg == nil {
+		event := <-subscription.Events()
+		if event.Type == EventReorg {
+			reorg = event.Reorg
+		}
+	}
+
+	if reorg.OldRoot != a1 || reorg.NewRoot != b2 || reorg.ForkHeight != 0 {
+		t.Fatalf("reorg from %x to %x at %d, want from a1 to b2 at 0", reorg.OldRoot.GetHash(), reorg.NewRoot.GetHash(), reorg.ForkHeight)
+	}
+	if len(reorg.Disconnected) != 1 || reorg.Disconnected[0] != a1 {
+		t.Fatalf("disconnected %d blocks, want a1", len(reorg.Disconnected))
+	}
+	if len(reorg.Connected) != 2 || reorg.Connected[0] != b1 || reorg.Connected[1] != b2 {
+		t.Fatalf("connected %d blocks, want b1 and b2", len(reorg.Connected))
+	}
+	if len(reorg.RolledBack) != 1 || !bytes.Equal(reorg.RolledBack[0].Data, onlyA.Data) {
+		t.Fatalf("rolled back %d transactions, want only the one of branch a", len(reorg.RolledBack))
+	}
+
+	// the node puts the rolled back transaction back into its mempool
+	hash, _ := onlyA.CalculateHash()
+	if !node.Mempool.Has(hash) {
+		t.Fatal("rolled back transaction is not pending again")
+	}
+
+	if checked, err := chain.VerifyChain(); err != nil {
+		t.Fatalf("chain is invalid at block %d: %v", checked, err)
+	}
+}
+
+// A block whose parent is not known is rejected and not linked anywhere.
+func TestChainUnknownParent(t *testing.T) {
+	source := newTestNode(t, 0)
+	mineTestBlock(t, source, 1)
+	orphan := mineTestBlock(t, source, 1)
+
+	chain := newTestNode(t, 1).LocalChain
+	if err := chain.AddBlock(orphan); !errors.Is(err, ErrUnknownParent) {
+		t.Fatalf("err = %v, want %v", err, ErrUnknownParent)
+	}
+	if chain.GetBlock(orphan.GetHash()) != nil || chain.GetHeight() != 0 {
+		t.Fatal("block with an unknown parent was linked")
+	}
+}
+
+func TestRolledBackTransactions(t *testing.T) {
+	transactions := make([]Transaction, 4)
+	for i := range transactions {
+		transactions[i] = newTestTransaction(t, string(rune('a'+i)))
+	}
+
+	block := func(transactions ...Transaction) *Block {
+		block, err := MakeBlockFromHeader(BlockHeader{version: BlockVersion}, transactionContents(transactions))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+
+	tests := []struct {
+		name         string
+		disconnected []*Block
+		connected    []*Block
+		want         []Transaction
+	}{
+		{"nothing connected", []*Block{block(transactions[0], transactions[1])}, nil, transactions[:2]},
+		{"all in the new branch", []*Block{block(transactions[0])}, []*Block{block(transactions[1], transactions[0])}, nil},
+		{"some in the new branch", []*Block{block(transactions[0], transactions[1]), block(transactions[2])},
+			[]*Block{block(transactions[1])}, []Transaction{transactions[0], transactions[2]}},
+		{"in two disconnected blocks", []*Block{block(transactions[3]), block(transactions[3])}, nil, transactions[3:]},
+		{"empty blocks", []*Block{block()}, []*Block{block()}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var disconnected []merkletree.Content
+			for _, block := range test.disconnected {
+				disconnected = append(disconnected, block)
+			}
+
+			var connected []*blockNode
+			for _, block := range test.connected {
+				connected = append(connected, &blockNode{block: block})
+			}
+
+			got := rolledBackTransactions(disconnected, connected)
+			if len(got) != len(test.want) {
+				t.Fatalf("rolled back %d transactions, want %d", len(got), len(test.want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i].Data, test.want[i].Data) {
+					t.Fatalf("transaction %d is %q, want %q", i, got[i].Data, test.want[i].Data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Runs blocks, transactions and sync requests into one node from many goroutines while its miner runs.
+// The readers keep going until the miner has put every submitted transaction in a block.
+// Meant to be run with -race.
+func TestConcurrentNodeAccess(t *testing.T) {
+	const (
+		senders    = 4
+		submitters = 4
+		readers    = 4
+		perWorker  = 10 // transactions of every submitter
+	)
+
+	// blocks of another node, which every sender sends in order
+	source := newTestNode(t, 0)
+	var blocks []*Block
+	for i := 0; i < 6; i++ {
+		blocks = append(blocks, mineTestBlock(t, source, 2))
+	}
+
+	node := newTestNode(t, 1)
+	session := openTestSession(t, node)
+	if err := node.StartMining(10*time.Millisecond, 2); err != nil {
+		t.Fatal(err)
+	}
+	defer node.StopMining()
+
+	var transactions []Transaction
+	for i := 0; i < submitters*perWorker; i++ {
+		transactions = append(transactions, newTestTransaction(t, fmt.Sprintf("concurrent %d", i)))
+	}
+
+	var wg, readerWG sync.WaitGroup
+	stop := make(chan struct{})
+
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for _, block := range blocks {
+				args := BlockArg{
+					Header:       makeHeaderArg(block.GetHeader()),
+					Hash:         block.GetHash(),
+					Transactions: block.GetTransactions(),
+					Session:      session,
+				}
+				if err := node.ReceiveBlock(args, &BlockReply{}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < submitters; i++ {
+		wg.Add(1)
+		go func(transactions []Transaction) {
+			defer wg.Done()
+
+			for _, transaction := range transactions {
+				if err := node.SubmitTransaction(transaction); err != nil {
+					t.Error(err)
+				}
+				time.Sleep(time.Millisecond) // gives the miner templates of different sizes
+			}
+		}(transactions[i*perWorker : (i+1)*perWorker])
+	}
+
+	for i := 0; i < readers; i++ {
+		readerWG.Add(1)
+		go func() {
+			defer readerWG.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				var headers GetHeadersReply
+				if err := node.GetHeaders(GetHeadersArg{Locator: node.LocalChain.GetLocator()}, &headers); err != nil {
+					t.Error(err)
+				}
+
+				// headers from genesis reach the root, so they add up to the height and work that come with them
+				var all GetHeadersReply
+				if err := node.GetHeaders(GetHeadersArg{Locator: [][]byte{node.LocalChain.GetGenesis().GetHash()}}, &all); err != nil {
+					t.Error(err)
+				}
+				work := blockWork(node.LocalChain.GetGenesis())
+				for _, data := range all.Headers {
+					var header BlockHeader
+					if err := header.UnmarshalBinary(data); err != nil {
+						t.Error(err)
+					}
+					work.Add(work, headerWork(&header))
+				}
+				if len(all.Headers) != all.BestHeight || work.Cmp(all.BestWork) != 0 {
+					t.Errorf("%d headers with work %v came with height %d and work %v", len(all.Headers), work, all.BestHeight, all.BestWork)
+				}
+
+				var hashes [][]byte
+				for height := 1; height <= node.LocalChain.GetHeight() && len(hashes) < maxBlocksPerCall; height++ {
+					if block := node.LocalChain.GetBlockByHeight(height); block != nil {
+						hashes = append(hashes, block.GetHash())
+					}
+				}
+
+				var reply GetBlocksReply
+				if err := node.GetBlocks(GetBlocksArg{Hashes: hashes}, &reply); err != nil {
+					t.Error(err)
+				}
+				for _, data := range reply.Blocks {
+					if _, err := DecodeBlock(data); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for node.Mempool.Size() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	readerWG.Wait()
+	node.StopMining()
+
+	if size := node.Mempool.Size(); size > 0 {
+		t.Errorf("miner left %d transactions in the mempool", size)
+	}
+
+	for i, block := range blocks {
+		if node.LocalChain.GetBlock(block.GetHash()) == nil {
+			t.Errorf("block %d was not added", i+1)
+		}
+	}
+
+	for i, transaction := range transactions {
+		hash, _ := transaction.CalculateHash()
+		if !node.LocalChain.HasTransaction(hash) {
+			t.Errorf("transaction %d is not in the chain", i)
+		}
+	}
+
+	if checked, err := node.LocalChain.VerifyChain(); err != nil {
+		t.Fatalf("chain is invalid at block %d: %v", checked, err)
+	}
+}
+
+// Adds the same blocks from many goroutines and checks that the last new head published and the last root
+// the tip handler got are the root, so a block linked earlier never publishes its head after one linked later.
+func TestConcurrentNewHeadOrder(t *testing.T) {
+	const adders = 4
+
+	source := newTestNode(t, 0)
+	var blocks []*Block
+	for i := 0; i < 8; i++ {
+		blocks = append(blocks, mineTestBlock(t, source, 1+i%2))
+	}
+
+	chain := newTestNode(t, 1).LocalChain
+
+	var handled []*Block
+	var handledMutex sync.Mutex
+	chain.SetTipHandler(func(root *Block) {
+		time.Sleep(time.Duration(len(root.GetTransactions())%2) * time.Millisecond) // slows down every other handler
+		handledMutex.Lock()
+		handled = append(handled, root)
+		handledMutex.Unlock()
+	})
+
+	subscription := chain.Events().Subscribe(adders * len(blocks) * 4)
+	defer subscription.Unsubscribe()
+
+	var wg sync.WaitGroup
+	for i := 0; i < adders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for _, block := range blocks {
+				chain.AddBlock(block)
+			}
+		}()
+	}
+	wg.Wait()
+	subscription.Unsubscribe()
+
+	var last *Block
+	for event := range subscription.Events() {
+		if event.Type == EventNewHead {
+			last = event.Block
+		}
+	}
+
+	if last != chain.GetRoot() {
+		t.Errorf("last new head is not the root")
+	}
+	if len(handled) == 0 || handled[len(handled)-1] != chain.GetRoot() {
+		t.Errorf("tip handler did not get the root last")
+	}
+}
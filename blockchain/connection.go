@@ -1,21 +1,29 @@
 package blockchain
 
 import (
-	"bufio"
-	"fmt"
+	"crypto/ed25519"
+	"errors"
 	"log"
 	"net/http"
 	"net/rpc"
-	"os"
 	"sync"
-
-	"github.com/cbergoon/merkletree"
+	"time"
 )
 
+var ErrAlreadyMining = errors.New("node is already mining")
+
+// Connection to a peer node
+// serverID			ID of the peer from the config (-1 if it is not known)
+// publicKey		identity key the peer proved in its handshake
+// version			protocol version we speak with the peer (the lower of both versions)
+// session			token the peer handed us in its handshake, sent with every block, transaction and announcement
 type ServerConnection struct {
 	serverID      int
 	address       string
 	rpcConnection *rpc.Client
+	publicKey     ed25519.PublicKey
+	version       int
+	session       []byte
 }
 
 type Node struct {
@@ -23,36 +31,87 @@ type Node struct {
 	Self      ServerConnection
 	peerNodes []ServerConnection
 
-	Block      *Block      // block that is being filled up with transactions has NOT been added to the chain yet
-	LocalChain *BlockChain // local copy of blockchain
+	Key        ed25519.PrivateKey // identity key of the node (transactions are signed with wallet accounts)
+	Mempool    *Mempool           // transactions that have NOT been added to the chain yet, blocks are built from it
+	LocalChain *BlockChain        // local copy of blockchain
 
-	mutex sync.Mutex
-	wg    sync.WaitGroup
+	syncManager *SyncManager  // downloads blocks that the node missed from its peers
+	peerManager *PeerManager  // finds other nodes and keeps the node connected to enough of them
+	seen        *seenCache    // blocks and transactions the node has seen, so it relays each of them only once
+	challenges  *challengeSet // handshake challenges handed out to nodes that connected to us
+	sessions    *sessionSet   // nodes that connected to us and completed their handshake
+	miner       *Miner        // builds blocks from the mempool and mines them (nil if the node does not mine)
+
+	mutex sync.RWMutex // guards Self, peerNodes and miner, the rest is set before the node starts serving
 }
 
-// From its own chain
-// Already have from header: parentBlockHash,
-// Already have from block: transaction hash of parent block (data)
-// Needs: nonce, timestamp, and hash to verify hash
+// Block as it is sent to other nodes
+// Header		complete header, so the receiver rebuilds the exact block without guessing anything
+// Hash			hash the sender calculated, the receiver calculates it again from the header and compares
+// Transactions	transactions of the block, as concrete values since gob cannot decode the merkletree.Content interface
+//
+//	without knowing its types (each one is sent in its canonical encoding, see Transaction.MarshalBinary)
+//
+// Session		token the receiver handed the sender in its handshake
 type BlockArg struct {
-	Nonce     uint64
-	Timestamp int64
-	Hash      []byte
+	Header HeaderArg
+	Hash   []byte
+
+	Transactions []Transaction
 
-	DataList []merkletree.Content
+	Session []byte
+}
+
+// Every field of a block header
+// Version			version of the header format
+// ParentBlockHash	hash of the block it is built on
+// MerkleRoot		root hash of the transactions, checked against Transactions by the receiver
+// Bits				target in compact form
+// Nonce			nonce that was found when mining
+// Timestamp		time the block was created
+type HeaderArg struct {
+	Version         uint32
+	ParentBlockHash []byte
+	MerkleRoot      []byte
+	Bits            uint32
+	Nonce           uint64
+	Timestamp       int64
+}
+
+// Copies the fields of a header into a HeaderArg.
+func makeHeaderArg(header BlockHeader) HeaderArg {
+	return HeaderArg{
+		Version:         header.version,
+		ParentBlockHash: header.parentBlockHash,
+		MerkleRoot:      header.merkleRoot,
+		Bits:            header.bits,
+		Nonce:           header.nonce,
+		Timestamp:       header.timestamp,
+	}
+}
+
+// Builds the header that the HeaderArg was made from. The hash is left empty.
+func (arg HeaderArg) toHeader() BlockHeader {
+	return BlockHeader{
+		version:         arg.Version,
+		timestamp:       arg.Timestamp,
+		parentBlockHash: arg.ParentBlockHash,
+		merkleRoot:      arg.MerkleRoot,
+		bits:            arg.Bits,
+		hash:            []byte{},
+		nonce:           arg.Nonce,
+	}
 }
 
 type BlockReply struct {
 	Success bool
 }
 
+// Transaction is sent in its canonical encoding (gob uses Transaction.MarshalBinary)
+// Session	token the receiver handed the sender in its handshake
 type TransactionArg struct {
-	Sender    []byte
-	Recipient []byte
-	Timestamp int64
-	Data      []byte
-
-	BlockTimestamp int64 // time that the block was created
+	Transaction Transaction
+	Session     []byte
 }
 
 type TransactionReply struct {
@@ -60,236 +119,337 @@ type TransactionReply struct {
 }
 
 func (node *Node) GetSelfAddress() string {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
 	return node.Self.address
 }
 
+// Address derived from the identity key of the node
+func (node *Node) GetAddress() string {
+	return AddressFromPublicKey(node.Key.Public().(ed25519.PublicKey))
+}
+
 // RPC that allows a node to receive a block from another node
 // Receives the block data from another node and adds it to its own chain
 // If the block is valid, it will add it to its own chain
+// Only peers that completed a handshake with this node can send blocks.
 func (node *Node) ReceiveBlock(args BlockArg, reply *BlockReply) error {
-	fmt.Println("--------------------------------------")
-	if args.Nonce == 0 && args.DataList == nil {
-		// means that empty block was sent 
-		addBlock := MakeAddBlock(args.Timestamp, args.Hash, args.Nonce, args.DataList)
-		node.Block = addBlock
-		fmt.Println(">>> Saving empty block")
+	if err := node.checkSession(args.Session); err != nil {
+		return err
+	}
 
-	} else {
-		// means that the block is full and needs to be added to the chain
-
-		parentBlockHash := node.LocalChain.GetRoot().GetParentBlockHash()
-		addBlock := MakeAddBlock(args.Timestamp, parentBlockHash, args.Nonce, args.DataList)
-		// create a new Merkle Tree from the list of transactions
-
-		node.wg.Add(1)
-		// Nonce should be correct
-		go func() {
-			defer node.wg.Done()
-			err := node.LocalChain.AddConsensusBlock(addBlock, args.Hash)
-
-			if err != nil {
-				fmt.Println("RPC >>> Error adding full block to chain")
-				reply.Success = false
-			} else {
-				fmt.Printf("RPC >>> Successfully added full block to chain. Hash: %x\n", addBlock.GetHash())
-				node.Block = nil // resets block if added to the chain successfully
-				reply.Success = true
-			}
-		}()
-		node.wg.Wait()
+	// the parent and everything else comes from the header that was sent
+	addBlock, err := MakeBlockFromHeader(args.Header.toHeader(), transactionContents(args.Transactions))
+	if err != nil {
+		logWarn("RPC >>> Could not rebuild block: %v", err)
+		reply.Success = false
 
-		fmt.Println("RPC >>> Successfully added full block to chain")
+		return nil
 	}
-	fmt.Print("-----\n\nWhat would you like to do?\n\n1. Send a transaction\n2. View hash of local chain\n\n-----\n\nType option: \n")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	// Nonce should be correct
+	go func() {
+		defer wg.Done()
+		err := node.LocalChain.AddConsensusBlock(addBlock, args.Hash)
+
+		if errors.Is(err, ErrUnknownParent) {
+			// we missed blocks before this one, so catch up with the peers
+			reply.Success = false
+			if node.syncManager.Trigger() {
+				logInfo("RPC >>> Parent of block is unknown, syncing chain with peers")
+				go node.SyncChain()
+			}
+		} else if err != nil {
+			logWarn("RPC >>> Error adding full block to chain: %v", err)
+			reply.Success = false
+		} else {
+			logInfo("RPC >>> Successfully added full block to chain. Hash: %x", addBlock.GetHash())
+			node.Mempool.RemoveMined() // its transactions are no longer pending
+			reply.Success = true
+
+			// pass it on to the peers that do not have it yet
+			go node.SendBlock(addBlock)
+		}
+	}()
+	wg.Wait()
+
 	return nil
 }
 
-// Takes in a block and calls ReceiveBlock on all peer nodes, passing it as an argument.
-func (node *Node) SendBlock(block *Block) {
-	arg := &BlockArg{
-		Nonce:     block.GetNonce(),
-		Timestamp: block.GetTimestamp(),
-		Hash:      block.GetHash(),
-		DataList:  block.GetDataList(),
+// Announces a block to all peer nodes at once and calls ReceiveBlock, passing it as an argument, on the ones that want it.
+// Returns what every peer did with it, or nil if the block was sent before.
+func (node *Node) SendBlock(block *Block) *BroadcastReport {
+	item := InvItem{Type: InvBlock, Hash: block.GetHash()}
+	if !node.seen.Add(item) {
+		return nil
 	}
 
-	for _, peer := range node.peerNodes {
-		if peer.rpcConnection != nil {
-
-			node.wg.Add(1)
-			go func(peer ServerConnection) {
-				defer node.wg.Done()
+	arg := BlockArg{
+		Header:       makeHeaderArg(block.GetHeader()),
+		Hash:         block.GetHash(),
+		Transactions: block.GetTransactions(),
+	}
 
-				var reply BlockReply
-				peer.rpcConnection.Call("Node.ReceiveBlock", arg, &reply)
+	report := node.broadcast(item, func(peer ServerConnection) (bool, error) {
+		peerArg := arg
+		peerArg.Session = peer.session
 
-				if reply.Success {
-					fmt.Println("Response >>> block was received successfully!")
-				} else {
-					fmt.Println("Response >>> block was not received successfully!")
-				}
-			}(peer)
-			node.wg.Wait()
+		var reply BlockReply
+		err := callWithTimeout(peer.rpcConnection, "Node.ReceiveBlock", &peerArg, &reply, broadcastTimeout)
+		if err != nil {
+			// after a timeout the call may still be writing to reply
+			return false, err
 		}
-	}
+
+		return reply.Success, nil
+	})
+	logInfo("Response >>> block %s", report)
+
+	return report
 }
 
 // RPC that allows a node to receive a transaction from another node
-// Receives the transaction data from another node and adds it to its own block
+// Receives the transaction data from another node and adds it to its own mempool
+// Only peers that completed a handshake with this node can send transactions.
 func (node *Node) ReceiveTransaction(args TransactionArg, reply *TransactionReply) error {
-	fmt.Println("--------------------------------------")
-	newTransaction := &Transaction{
-		Sender:    args.Sender,
-		Recipient: args.Recipient,
-		Timestamp: args.Timestamp,
-		Data:      args.Data,
+	if err := node.checkSession(args.Session); err != nil {
+		return err
 	}
 
-	// Needs to intialise a new block if it doesn't have one
-	// with the same timestamp as the se block
-	if node.LocalChain.GetBlockListLen() == 1 && node.Block == nil {
-		fmt.Println(">>> Creating second block!")
-		node.Block = MakeAddBlock(args.BlockTimestamp, node.LocalChain.genesis.GetHash(), 0, nil)
+	newTransaction := &args.Transaction
+
+	if err := newTransaction.Verify(); err != nil {
+		logWarn("RPC >>> Rejected transaction: %v", err)
+		reply.Success = false
+
+		return nil
 	}
 
-	err := node.Block.Add(*newTransaction)
+	hash, err := node.Mempool.Add(*newTransaction)
 
 	if err != nil {
-		fmt.Println("RPC >>> Error adding transaction to block")
+		logWarn("RPC >>> Error adding transaction to mempool: %v", err)
 		reply.Success = false
 	} else {
-		hash, _ := newTransaction.CalculateHash()
-		fmt.Printf("RPC >>> Successfully added transaction to block. Hash: %x\n", hash)
+		logInfo("RPC >>> Successfully added transaction to mempool. Hash: %x", hash)
 		reply.Success = true
+
+		// pass it on to the peers that do not have it yet
+		go node.SendTransaction(*newTransaction)
 	}
-	fmt.Print("--------------------------------------\n\nWhat would you like to do?\n\n1. Send a transaction\n2. View hash of local chain\n\n-----\n\nType option: \n")
 
 	return nil
 }
 
-// Sends a transaction to all peer nodes
-func (node *Node) SendTransaction(transaction Transaction) {
-	arg := &TransactionArg{
-		Sender:    transaction.Sender,
-		Recipient: transaction.Recipient,
-		Timestamp: transaction.Timestamp,
-		Data:      transaction.Data,
+// Announces a transaction to all peer nodes at once and sends it to the ones that want it.
+// Returns what every peer did with it, or nil if the transaction was sent before.
+func (node *Node) SendTransaction(transaction Transaction) *BroadcastReport {
+	hash, err := transaction.CalculateHash()
+	if err != nil {
+		return nil
+	}
 
-		BlockTimestamp: node.Block.GetTimestamp(),
+	item := InvItem{Type: InvTransaction, Hash: hash}
+	if !node.seen.Add(item) {
+		return nil
 	}
 
-	for _, peer := range node.peerNodes {
-		if peer.rpcConnection != nil {
+	report := node.broadcast(item, func(peer ServerConnection) (bool, error) {
+		arg := &TransactionArg{
+			Transaction: transaction,
+			Session:     peer.session,
+		}
 
-			node.wg.Add(1)
-			go func(peer ServerConnection) {
-				defer node.wg.Done()
+		var reply TransactionReply
+		err := callWithTimeout(peer.rpcConnection, "Node.ReceiveTransaction", arg, &reply, broadcastTimeout)
+		if err != nil {
+			// after a timeout the call may still be writing to reply
+			return false, err
+		}
 
-				var reply TransactionReply
-				peer.rpcConnection.Call("Node.ReceiveTransaction", arg, &reply)
+		return reply.Success, nil
+	})
+	logInfo("Response >>> transaction %s", report)
 
-				if reply.Success {
-					fmt.Println("Response >>> transaction was received successfully!")
-				} else {
-					fmt.Println("Response >>> transaction was not received successfully!")
-				}
-			}(peer)
+	return report
+}
 
-			node.wg.Wait()
-		}
-	}
+// Sets the local chain of the node and creates the mempool for it.
+// Transactions of blocks that a reorg takes off the chain are put back into the mempool.
+func (node *Node) SetLocalChain(chain *BlockChain) {
+	node.LocalChain = chain
+	node.Mempool = NewMempool(chain, DefaultMempoolBytes, DefaultMempoolExpiry)
+	chain.SetReorgHandler(node.restoreTransactions)
+	chain.SetTipHandler(node.tipChanged)
 }
 
-// Returns the local chain as a string
-func (node *Node) NodeChainToString() string {
-	return node.LocalChain.String()
+// Tells the miner that the root of the local chain changed, so it stops mining on the old root.
+func (node *Node) tipChanged(root *Block) {
+	if miner := node.getMiner(); miner != nil {
+		miner.NotifyNewTip()
+	}
 }
 
-// MakeNode creates a new node with the given ID
-func MakeNode(i int) *Node {
-	node := new(Node)
-	node.ID = i
-	node.Self = ServerConnection{serverID: i}
+// StartMining starts a miner that builds a block from the mempool every interval and mines it with the given number of workers.
+// Returns ErrAlreadyMining if the node has a miner already.
+func (node *Node) StartMining(interval time.Duration, workers int) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
 
-	return node
+	if node.miner != nil {
+		return ErrAlreadyMining
+	}
+
+	node.miner = NewMiner(node, interval, workers)
+	node.miner.Start()
+
+	return nil
 }
 
-// Connects to all the peer nodes listed in the config file
-// Allows for asynchronous connecting
-func (node *Node) ConnectNodes() error {
-	rpc.HandleHTTP()
+// GetHashrate returns the hashes per second of the miner of the node (0 if it does not mine).
+func (node *Node) GetHashrate() float64 {
+	miner := node.getMiner()
+	if miner == nil {
+		return 0
+	}
 
+	return miner.Hashrate()
+}
+
+// StopMining stops the miner of the node, if it has one. Mining can be started again afterwards.
+func (node *Node) StopMining() {
 	node.mutex.Lock()
-	selfAddress := node.Self.address
-	peerNodes := node.peerNodes
+	miner := node.miner
+	node.miner = nil
 	node.mutex.Unlock()
 
-	fmt.Println(selfAddress)
-
-	go http.ListenAndServe(selfAddress, nil)
-	log.Printf("Serving rpc on: " + selfAddress)
+	if miner != nil {
+		miner.Stop()
+	}
+}
 
-	for i, peerNode := range peerNodes {
-		//node.wg.Add(1)
-		go func(address string, index int) {
-			//defer node.wg.Done()
-			client, err := rpc.DialHTTP("tcp", address)
+// Returns the miner of the node (nil if it does not mine).
+func (node *Node) getMiner() *Miner {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
 
-			for err != nil {
-				client, err = rpc.DialHTTP("tcp", address)
-			}
+	return node.miner
+}
 
-			node.peerNodes[index] = ServerConnection{rpcConnection: client}
-			fmt.Println("Connected to " + address)
+// Puts transactions that were rolled back by a reorg back into the mempool.
+func (node *Node) restoreTransactions(rolledBack []Transaction) {
+	restored := 0
 
-		}(peerNode.address, i)
+	for _, transaction := range rolledBack {
+		hash, err := node.Mempool.Add(transaction)
+		if err != nil && !errors.Is(err, ErrDuplicateTransaction) {
+			logWarn(">>> Could not restore rolled back transaction %x: %v", hash, err)
+			continue
+		}
+		restored++
 	}
-	return nil
-}
 
-// Reads the config file and stores the addresses of the peer nodes in the node struct
-func (node *Node) ReadClusterConfig(filename string) {
-	nodeIndex := node.ID
+	logInfo(">>> Restored %d rolled back transactions", restored)
+}
 
-	file, err := os.Open(filename)
+// SubmitTransaction adds a transaction submitted to this node (through its API) to the mempool
+// and sends it to all peer nodes in the background, so the caller does not wait for slow peers.
+// The transaction is put in a block by the miner.
+func (node *Node) SubmitTransaction(transaction Transaction) error {
+	hash, err := node.Mempool.Add(transaction)
 	if err != nil {
-		fmt.Println("Error opening file: " + filename)
-		log.Fatal(err)
+		logWarn("Transaction was not added to the mempool: %v", err)
+		return err
 	}
-	defer file.Close()
+	logInfo("Adding transaction to mempool. Hash: %x", hash)
 
-	scanner := bufio.NewScanner(file)
-	var peerNodes []ServerConnection
+	// sending transactions to all of the nodes
+	go node.SendTransaction(transaction)
 
-	index := 0
-	for scanner.Scan() {
-		line := scanner.Text()
+	return nil
+}
 
-		if index != nodeIndex { // 0:4040 1:4041 2:4042 3:4043 4:4044
-			peerNodes = append(peerNodes, ServerConnection{address: line}) //read only, no need to use mutex
-		} else {
-			node.Self = ServerConnection{address: line}
+// BuildBlockTemplate builds a block on top of the root of the local chain
+// from the oldest transactions in the mempool (at most GetMax() transactions and maxBlockBytes of them).
+// The block gets the difficulty the chain expects next. It still has to be mined.
+func (node *Node) BuildBlockTemplate() *Block {
+	root, bits := node.LocalChain.GetRootAndNextBits()
+	block := MakeBlock(root.GetHash())
+	block.SetBits(bits)
+	size := 0
+
+	for _, transaction := range node.Mempool.Select(GetMax()) {
+		size += len(transaction.encode())
+		if size > maxBlockBytes {
+			break
 		}
 
-		index++
+		if err := block.Add(transaction); err != nil {
+			break
+		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading file: " + filename)
-		log.Fatal(err)
-	}
+	return block
+}
 
-	//looping through all the nodes as listed in the config file
-	for i := 0; i < len(peerNodes); i++ {
-		// creating the IDs for each node, without including its own ID
-		if i >= nodeIndex {
-			peerNodes[i].serverID = i + 1
-		} else {
-			peerNodes[i].serverID = i
-		}
+// Returns the local chain as a string
+func (node *Node) NodeChainToString() string {
+	return node.LocalChain.String()
+}
+
+// MakeNode creates a new node with the given ID
+func MakeNode(i int) *Node {
+	node := new(Node)
+	node.ID = i
+	node.Self = ServerConnection{serverID: i}
+	node.syncManager = NewSyncManager(node)
+	node.peerManager = NewPeerManager(node, DefaultTargetPeers)
+	node.seen = newSeenCache(seenCacheSize)
+	node.challenges = newChallengeSet()
+	node.sessions = newSessionSet()
+
+	return node
+}
+
+// SyncChain downloads and adds the blocks of the best chain of the connected peers that the node is missing.
+// Run before the node starts adding blocks of its own, and whenever a block with an unknown parent arrives.
+func (node *Node) SyncChain() error {
+	err := node.syncManager.Sync()
+	node.Mempool.RemoveMined()
+
+	if err != nil {
+		logWarn(">>> Error syncing chain: %v", err)
+	} else {
+		logInfo(">>> Chain is synced. Height: %d, hash: %x", node.LocalChain.GetHeight(), node.LocalChain.GetRoot().GetHash())
 	}
 
-	node.peerNodes = peerNodes
+	return err
+}
+
+// Starts serving the RPCs of the node and the peer manager,
+// which connects to the peers from the config and finds more nodes through the seeds and the peers
+func (node *Node) ConnectNodes() error {
+	rpc.HandleHTTP()
+
+	selfAddress := node.GetSelfAddress()
+
+	go http.ListenAndServe(selfAddress, nil)
+	log.Printf("Serving rpc on: " + selfAddress)
+
+	node.peerManager.Start()
+
+	return nil
+}
+
+// Sets the address the node serves its RPCs on (host:port)
+func (node *Node) SetListenAddress(address string) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	node.Self = ServerConnection{serverID: node.ID, address: address}
 }
 
 /*
@@ -0,0 +1,127 @@
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+	"time"
+)
+
+// Difficulty retargeting.
+// Every block stores its target in compact form (bits) in its header.
+// Every RetargetInterval blocks the target is adjusted so that blocks are mined every TargetBlockInterval on average:
+// the target of the previous block is scaled by how long the last RetargetInterval blocks actually took compared to how long they should have taken.
+// In between, a block must have the same bits as its parent.
+const (
+	RetargetInterval    = 16
+	TargetBlockInterval = 10 * time.Second
+
+	// the target never changes by more than this factor in a single retarget
+	maxRetargetFactor = 4
+)
+
+// Easiest target that a block may have (1 leading zero bit).
+var powLimit = new(big.Int).Lsh(big.NewInt(1), 255)
+
+var ErrBadDifficulty = errors.New("block difficulty does not follow the retarget rules")
+
+// BigToCompact returns the compact form of a target, the same format bitcoin uses:
+// the highest byte is the number of bytes of the target, the lower three bytes are its most significant bytes.
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	size := uint32(len(target.Bytes()))
+
+	var mantissa uint32
+	if size <= 3 {
+		mantissa = uint32(target.Uint64()) << (8 * (3 - size))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, uint(8*(size-3))).Uint64())
+	}
+
+	// the 0x00800000 bit is the sign bit, so the mantissa is moved down a byte if it is set
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		size++
+	}
+
+	return size<<24 | mantissa
+}
+
+// CompactToBig returns the target that the compact form stands for.
+// Negative targets cannot be stored in it, a set sign bit gives a target of 0 (no hash is below it).
+func CompactToBig(bits uint32) *big.Int {
+	size := bits >> 24
+	mantissa := bits & 0x007fffff
+
+	if bits&0x00800000 != 0 {
+		return new(big.Int)
+	}
+
+	target := big.NewInt(int64(mantissa))
+	if size <= 3 {
+		return target.Rsh(target, uint(8*(3-size)))
+	}
+
+	return target.Lsh(target, uint(8*(size-3)))
+}
+
+// Returns the bits the child of the given tree entry must have.
+// Walks back through the parents, so it works for side branches as well as for the canonical chain.
+func (blockChain *BlockChain) nextBits(parent *blockNode) uint32 {
+	height := parent.height + 1
+
+	if height%RetargetInterval != 0 {
+		return parent.block.GetBits()
+	}
+
+	// the timestamp of genesis is fixed when the chain is created, so the first period is measured from block 1
+	first := parent
+	for first.height > 1 && parent.height-first.height < RetargetInterval {
+		first = first.parent
+	}
+
+	intervals := int64(parent.height - first.height)
+	if intervals == 0 {
+		return parent.block.GetBits()
+	}
+
+	expected := intervals * int64(TargetBlockInterval)
+	actual := parent.block.GetTimestamp() - first.block.GetTimestamp()
+
+	if actual < expected/maxRetargetFactor {
+		actual = expected / maxRetargetFactor
+	}
+	if actual > expected*maxRetargetFactor {
+		actual = expected * maxRetargetFactor
+	}
+
+	target := CompactToBig(parent.block.GetBits())
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+
+	if target.Cmp(powLimit) > 0 {
+		target.Set(powLimit)
+	}
+
+	return BigToCompact(target)
+}
+
+// GetNextBits returns the bits that the next block on top of the root must have.
+// Block templates are built with these bits.
+func (blockChain *BlockChain) GetNextBits() uint32 {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	return blockChain.nextBits(blockChain.tip)
+}
+
+// GetRootAndNextBits returns the root and the bits of the block on top of it, read together
+// so the bits always belong to the root even if a block is added at the same time.
+func (blockChain *BlockChain) GetRootAndNextBits() (*Block, uint32) {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	return blockChain.root, blockChain.nextBits(blockChain.tip)
+}
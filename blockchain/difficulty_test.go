@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func hexBig(t *testing.T, value string) *big.Int {
+	target, ok := new(big.Int).SetString(value, 16)
+	if !ok {
+		t.Fatalf("invalid hex number %s", value)
+	}
+
+	return target
+}
+
+func TestCompactBits(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string // hex
+		bits   uint32
+	}{
+		{"zero", "0", 0x00000000},
+		{"one byte", "12", 0x01120000},
+		{"sign bit in one byte", "80", 0x02008000},
+		{"two bytes", "1234", 0x02123400},
+		{"three bytes", "123456", 0x03123456},
+		{"sign bit in three bytes", "923400", 0x04009234},
+		{"bitcoin genesis", "ffff0000000000000000000000000000000000000000000000000000", 0x1d00ffff},
+		{"bitcoin block 32256", "404cb000000000000000000000000000000000000000000000000", 0x1b0404cb},
+		{"pow limit", "8000000000000000000000000000000000000000000000000000000000000000", 0x21008000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := hexBig(t, test.target)
+
+			if bits := BigToCompact(target); bits != test.bits {
+				t.Errorf("BigToCompact(%s) = %#08x, want %#08x", test.target, bits, test.bits)
+			}
+			if back := CompactToBig(test.bits); back.Cmp(target) != 0 {
+				t.Errorf("CompactToBig(%#08x) = %x, want %s", test.bits, back, test.target)
+			}
+		})
+	}
+}
+
+// The compact form keeps only the three most significant bytes, lower bytes are cut off.
+func TestCompactBitsPrecision(t *testing.T) {
+	target := hexBig(t, "12345678")
+
+	if bits := BigToCompact(target); bits != 0x04123456 {
+		t.Fatalf("BigToCompact(12345678) = %#08x, want 0x04123456", bits)
+	}
+	if back := CompactToBig(0x04123456); back.Cmp(hexBig(t, "12345600")) != 0 {
+		t.Fatalf("CompactToBig(0x04123456) = %x, want 12345600", back)
+	}
+}
+
+// A set sign bit stands for a negative target, which no hash can be below.
+func TestCompactBitsNegative(t *testing.T) {
+	for _, bits := range []uint32{0x01800000, 0x04923456, 0x1d800001} {
+		if target := CompactToBig(bits); target.Sign() != 0 {
+			t.Errorf("CompactToBig(%#08x) = %x, want 0", bits, target)
+		}
+	}
+}
+
+// Builds a branch of tree entries from height 0 to height count-1, with blocks spacing apart and the given bits.
+func testBranch(t *testing.T, bits uint32, spacing time.Duration, count int) *blockNode {
+	var node *blockNode
+	for height := 0; height < count; height++ {
+		header := BlockHeader{version: BlockVersion, bits: bits, timestamp: int64(height) * int64(spacing)}
+		block, err := MakeBlockFromHeader(header, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		node = &blockNode{block: block, parent: node, height: height}
+	}
+
+	return node
+}
+
+func TestNextBits(t *testing.T) {
+	const bits = 0x1f00ffff
+	target := CompactToBig(bits)
+
+	scaled := func(numerator, denominator int64) uint32 {
+		scaled := new(big.Int).Mul(target, big.NewInt(numerator))
+		return BigToCompact(scaled.Div(scaled, big.NewInt(denominator)))
+	}
+
+	tests := []struct {
+		name    string
+		bits    uint32
+		spacing time.Duration
+		parent  int // height of the parent of the new block
+		want    uint32
+	}{
+		{"between retargets", bits, time.Second, 5, bits},
+		{"last block before a retarget", bits, time.Second, RetargetInterval - 2, bits},
+		{"on time", bits, TargetBlockInterval, RetargetInterval - 1, bits},
+		{"twice as fast", bits, TargetBlockInterval / 2, RetargetInterval - 1, scaled(1, 2)},
+		{"twice as slow", bits, 2 * TargetBlockInterval, RetargetInterval - 1, scaled(2, 1)},
+		{"too fast is clamped", bits, TargetBlockInterval / 100, RetargetInterval - 1, scaled(1, maxRetargetFactor)},
+		{"too slow is clamped", bits, 100 * TargetBlockInterval, RetargetInterval - 1, scaled(maxRetargetFactor, 1)},
+		{"second period", bits, TargetBlockInterval / 2, 2*RetargetInterval - 1, scaled(1, 2)},
+		{"never easier than the pow limit", BigToCompact(powLimit), 100 * TargetBlockInterval, RetargetInterval - 1, BigToCompact(powLimit)},
+	}
+
+	chain := &BlockChain{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent := testBranch(t, test.bits, test.spacing, test.parent+1)
+
+			if got := chain.nextBits(parent); got != test.want {
+				t.Errorf("nextBits = %#08x, want %#08x", got, test.want)
+			}
+		})
+	}
+}
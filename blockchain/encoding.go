@@ -0,0 +1,351 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/cbergoon/merkletree"
+)
+
+// Canonical binary encoding of transactions, block headers and blocks.
+// The same bytes are hashed, sent to other nodes and written to disk.
+//
+// Every encoding starts with a one byte encoding version.
+// Integers are big-endian and fixed size, byte slices are prefixed with their length as a uint32,
+// so two different values can never encode to the same bytes.
+//
+// Transaction	version | sender | recipient | timestamp (int64) | data | public key | signature
+// BlockHeader	version | header version (uint32) | parent block hash | merkle root | timestamp (int64) | bits (uint32) | nonce (uint64)
+// Block		version | header | number of transactions (uint32) | transaction | transaction | ...
+//
+// The hash of a block is not part of the encoding, it is calculated from the encoded header.
+const EncodingVersion uint8 = 1
+
+// Upper bounds that are checked while decoding so that a malformed message cannot make us allocate huge buffers.
+const (
+	maxFieldSize        = 1 << 20
+	maxBlockTransaction = 1 << 16
+)
+
+// Transactions are the only merkletree.Content that is sent between nodes.
+// Registering them lets gob send them even where they are held as merkletree.Content.
+func init() {
+	gob.Register(Transaction{})
+}
+
+var (
+	ErrInvalidEncoding        = errors.New("invalid encoding")
+	ErrUnknownEncodingVersion = errors.New("unknown encoding version")
+)
+
+// Writes the fields of a value into a buffer in the canonical format.
+type encoder struct {
+	buff bytes.Buffer
+}
+
+func (enc *encoder) writeUint8(num uint8) {
+	enc.buff.WriteByte(num)
+}
+
+func (enc *encoder) writeUint32(num uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], num)
+	enc.buff.Write(b[:])
+}
+
+func (enc *encoder) writeUint64(num uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], num)
+	enc.buff.Write(b[:])
+}
+
+func (enc *encoder) writeInt64(num int64) {
+	enc.writeUint64(uint64(num))
+}
+
+func (enc *encoder) writeBytes(data []byte) {
+	enc.writeUint32(uint32(len(data)))
+	enc.buff.Write(data)
+}
+
+func (enc *encoder) bytes() []byte {
+	return enc.buff.Bytes()
+}
+
+// Reads the fields of a value from the canonical format.
+// The first error is kept and every read after it returns a zero value.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (dec *decoder) next(size int) []byte {
+	if dec.err != nil {
+		return nil
+	}
+
+	if len(dec.data) < size {
+		dec.err = ErrInvalidEncoding
+		return nil
+	}
+
+	b := dec.data[:size]
+	dec.data = dec.data[size:]
+
+	return b
+}
+
+func (dec *decoder) readUint8() uint8 {
+	b := dec.next(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (dec *decoder) readUint32() uint32 {
+	b := dec.next(4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+func (dec *decoder) readUint64() uint64 {
+	b := dec.next(8)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+func (dec *decoder) readInt64() int64 {
+	return int64(dec.readUint64())
+}
+
+// Returns a copy of the next length-prefixed byte slice.
+// Empty slices are returned as []byte{} (not nil) so decoded values compare equal to the ones that were encoded.
+func (dec *decoder) readBytes() []byte {
+	size := dec.readUint32()
+	if dec.err != nil {
+		return nil
+	}
+
+	if size > maxFieldSize {
+		dec.err = ErrInvalidEncoding
+		return nil
+	}
+
+	b := dec.next(int(size))
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
+
+// Checks the encoding version at the start of the data.
+func (dec *decoder) readVersion() {
+	version := dec.readUint8()
+
+	if dec.err == nil && version != EncodingVersion {
+		dec.err = fmt.Errorf("%w: %d", ErrUnknownEncodingVersion, version)
+	}
+}
+
+// Returns the first error, or an error if there are bytes left over.
+func (dec *decoder) finish() error {
+	if dec.err != nil {
+		return dec.err
+	}
+
+	if len(dec.data) != 0 {
+		return ErrInvalidEncoding
+	}
+
+	return nil
+}
+
+// Canonical encoding of the transaction. Cannot fail.
+func (transaction Transaction) encode() []byte {
+	return transaction.encodeFields(true)
+}
+
+// Canonical encoding of the transaction, with or without the signature at the end.
+// The encoding without the signature is what the sender signs.
+func (transaction Transaction) encodeFields(withSignature bool) []byte {
+	var enc encoder
+
+	enc.writeUint8(EncodingVersion)
+	enc.writeBytes(transaction.Sender)
+	enc.writeBytes(transaction.Recipient)
+	enc.writeInt64(transaction.Timestamp)
+	enc.writeBytes(transaction.Data)
+	enc.writeBytes(transaction.PubKey)
+
+	if withSignature {
+		enc.writeBytes(transaction.Signature)
+	}
+
+	return enc.bytes()
+}
+
+// MarshalBinary returns the canonical encoding of the transaction.
+// Implements encoding.BinaryMarshaler, which gob also uses when transactions are sent over RPC.
+func (transaction Transaction) MarshalBinary() ([]byte, error) {
+	return transaction.encode(), nil
+}
+
+// UnmarshalBinary sets the transaction to the decoded canonical encoding.
+func (transaction *Transaction) UnmarshalBinary(data []byte) error {
+	dec := decoder{data: data}
+
+	dec.readVersion()
+	decoded := Transaction{
+		Sender:    dec.readBytes(),
+		Recipient: dec.readBytes(),
+		Timestamp: dec.readInt64(),
+		Data:      dec.readBytes(),
+		PubKey:    dec.readBytes(),
+		Signature: dec.readBytes(),
+	}
+
+	if err := dec.finish(); err != nil {
+		return err
+	}
+
+	*transaction = decoded
+
+	return nil
+}
+
+// Canonical encoding of the header, everything except the hash. Cannot fail.
+func (header BlockHeader) encode() []byte {
+	var enc encoder
+
+	enc.writeUint8(EncodingVersion)
+	enc.writeUint32(header.version)
+	enc.writeBytes(header.parentBlockHash)
+	enc.writeBytes(header.merkleRoot)
+	enc.writeInt64(header.timestamp)
+	enc.writeUint32(header.bits)
+	enc.writeUint64(header.nonce)
+
+	return enc.bytes()
+}
+
+// MarshalBinary returns the canonical encoding of the header.
+// The hash is not included since it is calculated from these bytes.
+func (header BlockHeader) MarshalBinary() ([]byte, error) {
+	return header.encode(), nil
+}
+
+// UnmarshalBinary sets the header to the decoded canonical encoding.
+// The hash of the header is recalculated.
+func (header *BlockHeader) UnmarshalBinary(data []byte) error {
+	dec := decoder{data: data}
+
+	dec.readVersion()
+	decoded := BlockHeader{
+		version:         dec.readUint32(),
+		parentBlockHash: dec.readBytes(),
+		merkleRoot:      dec.readBytes(),
+		timestamp:       dec.readInt64(),
+		bits:            dec.readUint32(),
+		nonce:           dec.readUint64(),
+	}
+
+	if err := dec.finish(); err != nil {
+		return err
+	}
+
+	decoded.hash = hashBytes(data)
+	*header = decoded
+
+	return nil
+}
+
+// MarshalBinary returns the canonical encoding of the block: its header followed by its transactions.
+func (block *Block) MarshalBinary() ([]byte, error) {
+	var enc encoder
+
+	enc.writeUint8(EncodingVersion)
+	enc.writeBytes(block.header.encode())
+	enc.writeUint32(uint32(len(block.dataList)))
+
+	for _, content := range block.dataList {
+		transaction, ok := content.(Transaction)
+		if !ok {
+			return nil, errors.New("block contains content that is not a transaction")
+		}
+
+		enc.writeBytes(transaction.encode())
+	}
+
+	return enc.bytes(), nil
+}
+
+// UnmarshalBinary sets the block to the decoded canonical encoding.
+// Rebuilds the Merkle Tree of the transactions and recalculates the hash of the block.
+// Does not check that the block is valid.
+func (block *Block) UnmarshalBinary(data []byte) error {
+	dec := decoder{data: data}
+
+	dec.readVersion()
+	headerData := dec.readBytes()
+	count := dec.readUint32()
+
+	if dec.err == nil && count > maxBlockTransaction {
+		dec.err = ErrInvalidEncoding
+	}
+
+	var header BlockHeader
+	if dec.err == nil {
+		dec.err = header.UnmarshalBinary(headerData)
+	}
+
+	dataList := []merkletree.Content{}
+	for i := uint32(0); i < count && dec.err == nil; i++ {
+		var transaction Transaction
+
+		transactionData := dec.readBytes()
+		if dec.err == nil {
+			dec.err = transaction.UnmarshalBinary(transactionData)
+		}
+
+		dataList = append(dataList, transaction)
+	}
+
+	if err := dec.finish(); err != nil {
+		return err
+	}
+
+	decoded, err := MakeBlockFromHeader(header, dataList)
+	if err != nil {
+		return err
+	}
+
+	block.header = decoded.header
+	block.data = decoded.data
+	block.dataList = decoded.dataList
+	block.pow = decoded.pow
+
+	return nil
+}
+
+// DecodeBlock returns the block in the canonical encoding.
+func DecodeBlock(data []byte) (*Block, error) {
+	block := new(Block)
+
+	if err := block.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
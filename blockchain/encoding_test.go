@@ -0,0 +1,192 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cbergoon/merkletree"
+)
+
+// Golden vectors of the canonical encoding. If one of these changes, every stored block and every
+// transaction hash changes with it, so EncodingVersion has to be bumped.
+
+func goldenTransaction() Transaction {
+	return Transaction{
+		Sender:    []byte("ab"),
+		Recipient: []byte("cd"),
+		Timestamp: 0x0102030405060708,
+		Data:      []byte("hi"),
+		PubKey:    []byte{0x01, 0x02},
+		Signature: []byte{0x03},
+	}
+}
+
+const goldenTransactionHex = "" +
+	"01" + // encoding version
+	"00000002" + "6162" + // sender
+	"00000002" + "6364" + // recipient
+	"0102030405060708" + // timestamp
+	"00000002" + "6869" + // data
+	"00000002" + "0102" + // public key
+	"00000001" + "03" // signature
+
+func goldenHeader() BlockHeader {
+	return BlockHeader{
+		version:         1,
+		parentBlockHash: []byte{0xaa, 0xbb},
+		merkleRoot:      []byte{0xcc},
+		timestamp:       1,
+		bits:            0x1d00ffff,
+		nonce:           42,
+	}
+}
+
+const goldenHeaderHex = "" +
+	"01" + // encoding version
+	"00000001" + // header version
+	"00000002" + "aabb" + // parent block hash
+	"00000001" + "cc" + // merkle root
+	"0000000000000001" + // timestamp
+	"1d00ffff" + // bits
+	"000000000000002a" // nonce
+
+// SHA-256 of the golden header, the hash of a block is calculated from its encoded header.
+const goldenHeaderHash = "e883c0a2fe1d142f2d6f348fbf4b3393965e787f827bd8d6216f075538d1a999"
+
+func goldenBlock(t *testing.T) *Block {
+	block, err := MakeBlockFromHeader(goldenHeader(), []merkletree.Content{goldenTransaction()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+var goldenBlockHex = "" +
+	"01" + // encoding version
+	"00000024" + goldenHeaderHex + // header
+	"00000001" + // number of transactions
+	"00000026" + goldenTransactionHex // transaction
+
+func decodeHex(t *testing.T, value string) []byte {
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestTransactionEncoding(t *testing.T) {
+	transaction := goldenTransaction()
+
+	encoded, err := transaction.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(encoded); got != goldenTransactionHex {
+		t.Fatalf("encoding is\n%s\nwant\n%s", got, goldenTransactionHex)
+	}
+
+	var decoded Transaction
+	if err := decoded.UnmarshalBinary(encoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, transaction) {
+		t.Fatalf("decoded %+v, want %+v", decoded, transaction)
+	}
+}
+
+func TestHeaderEncoding(t *testing.T) {
+	header := goldenHeader()
+
+	encoded, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(encoded); got != goldenHeaderHex {
+		t.Fatalf("encoding is\n%s\nwant\n%s", got, goldenHeaderHex)
+	}
+
+	var decoded BlockHeader
+	if err := decoded.UnmarshalBinary(encoded); err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(decoded.hash); got != goldenHeaderHash {
+		t.Fatalf("hash is %s, want %s", got, goldenHeaderHash)
+	}
+
+	decoded.hash = nil
+	if !reflect.DeepEqual(decoded, header) {
+		t.Fatalf("decoded %+v, want %+v", decoded, header)
+	}
+}
+
+func TestBlockEncoding(t *testing.T) {
+	block := goldenBlock(t)
+
+	encoded, err := block.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(encoded); got != goldenBlockHex {
+		t.Fatalf("encoding is\n%s\nwant\n%s", got, goldenBlockHex)
+	}
+
+	decoded, err := DecodeBlock(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(decoded.GetHash()); got != goldenHeaderHash {
+		t.Fatalf("hash is %s, want %s", got, goldenHeaderHash)
+	}
+	if !reflect.DeepEqual(decoded.GetTransactions(), block.GetTransactions()) {
+		t.Fatalf("decoded transactions %+v, want %+v", decoded.GetTransactions(), block.GetTransactions())
+	}
+
+	reencoded, err := decoded.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reencoded, encoded) {
+		t.Fatal("decoded block does not encode to the same bytes")
+	}
+}
+
+func TestEncodingRejectsWrongVersion(t *testing.T) {
+	wrongVersion := func(value string) []byte {
+		return decodeHex(t, "02"+strings.TrimPrefix(value, "01"))
+	}
+
+	var transaction Transaction
+	if err := transaction.UnmarshalBinary(wrongVersion(goldenTransactionHex)); !errors.Is(err, ErrUnknownEncodingVersion) {
+		t.Errorf("transaction: got %v, want ErrUnknownEncodingVersion", err)
+	}
+
+	var header BlockHeader
+	if err := header.UnmarshalBinary(wrongVersion(goldenHeaderHex)); !errors.Is(err, ErrUnknownEncodingVersion) {
+		t.Errorf("header: got %v, want ErrUnknownEncodingVersion", err)
+	}
+
+	if _, err := DecodeBlock(wrongVersion(goldenBlockHex)); !errors.Is(err, ErrUnknownEncodingVersion) {
+		t.Errorf("block: got %v, want ErrUnknownEncodingVersion", err)
+	}
+
+	// the version of the header inside the block is checked too
+	nested := decodeHex(t, goldenBlockHex)
+	nested[1+4] = 0x02
+	if _, err := DecodeBlock(nested); !errors.Is(err, ErrUnknownEncodingVersion) {
+		t.Errorf("header in block: got %v, want ErrUnknownEncodingVersion", err)
+	}
+}
+
+func TestEncodingRejectsTrailingBytes(t *testing.T) {
+	var transaction Transaction
+	if err := transaction.UnmarshalBinary(decodeHex(t, goldenTransactionHex+"00")); !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("got %v, want ErrInvalidEncoding", err)
+	}
+}
@@ -0,0 +1,157 @@
+package blockchain
+
+import (
+	"errors"
+	"sync"
+)
+
+// Events a subscriber may buffer before it is dropped.
+const DefaultEventBuffer = 256
+
+var ErrSubscriberTooSlow = errors.New("subscriber did not keep up with the events and was dropped")
+
+// EventType is what happened in the node.
+type EventType uint8
+
+const (
+	EventBlock       EventType = iota + 1 // a block was accepted into the block tree, on the canonical chain or a side branch
+	EventReorg                            // the canonical chain switched to another branch
+	EventNewHead                          // the root of the canonical chain changed
+	EventTransaction                      // a transaction was admitted to the mempool
+)
+
+func (eventType EventType) String() string {
+	switch eventType {
+	case EventBlock:
+		return "block"
+	case EventReorg:
+		return "reorg"
+	case EventNewHead:
+		return "newHead"
+	case EventTransaction:
+		return "transaction"
+	}
+
+	return "unknown"
+}
+
+// Event is something that happened in the node. Only the fields of its type are set.
+// Block			accepted block (EventBlock) or new root (EventNewHead)
+// Height			height of that block
+// Canonical		whether the accepted block is on the canonical chain (EventBlock)
+// Reorg			the switch to the other branch (EventReorg)
+// Transaction		transaction admitted to the mempool (EventTransaction)
+// Hash				hash of that transaction
+type Event struct {
+	Type EventType
+
+	Block     *Block
+	Height    int
+	Canonical bool
+
+	Reorg *Reorg
+
+	Transaction Transaction
+	Hash        []byte
+}
+
+// Reorg describes a switch of the canonical chain to a branch with more work.
+// ForkHeight		height of the last block both branches share
+// Disconnected		blocks that left the canonical chain, oldest first
+// Connected		blocks that joined it, oldest first (the last one is the new root)
+// RolledBack		transactions of the disconnected blocks that are not in the connected ones
+type Reorg struct {
+	OldRoot      *Block
+	NewRoot      *Block
+	ForkHeight   int
+	Disconnected []*Block
+	Connected    []*Block
+	RolledBack   []Transaction
+}
+
+// EventBus passes the events of the node to every subscriber.
+// Publishing never blocks: a subscriber whose buffer is full is dropped,
+// so a slow subscriber cannot hold up the chain or the mempool.
+type EventBus struct {
+	subscribers map[*Subscription]struct{}
+
+	mutex sync.Mutex
+}
+
+// Subscription receives the events of a bus until it is unsubscribed or dropped.
+type Subscription struct {
+	bus     *EventBus
+	events  chan Event
+	dropped bool // set under the mutex of the bus before events is closed
+}
+
+// NewEventBus creates a bus without subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe returns a subscription to every event published from now on.
+// buffer is the number of events it holds for the subscriber before it is dropped.
+func (bus *EventBus) Subscribe(buffer int) *Subscription {
+	subscription := &Subscription{bus: bus, events: make(chan Event, buffer)}
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.subscribers[subscription] = struct{}{}
+
+	return subscription
+}
+
+// Publish sends the events to every subscriber, in order.
+func (bus *EventBus) Publish(events ...Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	for _, event := range events {
+		for subscription := range bus.subscribers {
+			select {
+			case subscription.events <- event:
+			default:
+				logWarn(">>> Event subscriber is too slow, dropping it")
+				subscription.dropped = true
+				bus.remove(subscription)
+			}
+		}
+	}
+}
+
+// Removes the subscription and closes its channel. Mutex must be held.
+func (bus *EventBus) remove(subscription *Subscription) {
+	if _, ok := bus.subscribers[subscription]; !ok {
+		return
+	}
+
+	delete(bus.subscribers, subscription)
+	close(subscription.events)
+}
+
+// Events returns the channel the events are received on. It is closed when the subscription ends.
+func (subscription *Subscription) Events() <-chan Event {
+	return subscription.events
+}
+
+// Err returns ErrSubscriberTooSlow if the bus dropped the subscription, nil otherwise.
+func (subscription *Subscription) Err() error {
+	subscription.bus.mutex.Lock()
+	defer subscription.bus.mutex.Unlock()
+
+	if subscription.dropped {
+		return ErrSubscriberTooSlow
+	}
+
+	return nil
+}
+
+// Unsubscribe ends the subscription. It may be called more than once.
+func (subscription *Subscription) Unsubscribe() {
+	subscription.bus.mutex.Lock()
+	defer subscription.bus.mutex.Unlock()
+
+	subscription.bus.remove(subscription)
+}
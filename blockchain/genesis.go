@@ -0,0 +1,167 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/cbergoon/merkletree"
+)
+
+// Values of the genesis block that is used when no genesis file is given.
+const (
+	DefaultChainID          = "blockchain-local"
+	DefaultGenesisTimestamp = 1682708458208064000 // Fri Apr 28 2023, in UnixNano
+)
+
+// Highest difficulty of a genesis block. The genesis block is mined by a single thread from nonce 0
+// (see Block.solve), which takes about 2^difficulty hashes: around 16 million for 24 bits, some ten seconds.
+// Every bit more doubles that, so higher difficulties would keep "genesis init" and the node busy for hours.
+const MaxGenesisDifficulty = 24
+
+// GenesisSpec describes the genesis block of a chain.
+// Every node that builds its genesis block from the same spec gets the same genesis hash,
+// because nothing in it depends on the clock or the machine it runs on.
+// ChainID		name of the chain, written into every genesis transaction
+// Timestamp	timestamp of the genesis block and its transactions (UnixNano)
+// Payload		data of the genesis transactions, one transaction per entry
+// Difficulty	number of leading zero bits the genesis hash must have (at most MaxGenesisDifficulty), the chain retargets from there
+// Nonce		nonce of the genesis block, written by "genesis init" (optional, the block is mined from nonce 0 if it is not set)
+// Hash			hex hash of the genesis block, written by "genesis init" (optional, checked if set)
+type GenesisSpec struct {
+	ChainID    string   `json:"chainId"`
+	Timestamp  int64    `json:"timestamp"`
+	Payload    []string `json:"payload"`
+	Difficulty int      `json:"difficulty"`
+	Nonce      *uint64  `json:"nonce,omitempty"`
+	Hash       string   `json:"hash,omitempty"`
+}
+
+// DefaultGenesis returns the spec of the genesis block that is used when no genesis file is given.
+// Has max "init" transactions, like the genesis block always had.
+func DefaultGenesis() *GenesisSpec {
+	payload := make([]string, max)
+	for i := range payload {
+		payload[i] = "init"
+	}
+
+	return &GenesisSpec{
+		ChainID:    DefaultChainID,
+		Timestamp:  DefaultGenesisTimestamp,
+		Payload:    payload,
+		Difficulty: difficulty,
+	}
+}
+
+// LoadGenesis reads a genesis spec from a JSON file and validates it.
+func LoadGenesis(filename string) (*GenesisSpec, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := new(GenesisSpec)
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("genesis file %s: %v", filename, err)
+	}
+
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("genesis file %s: %v", filename, err)
+	}
+
+	return spec, nil
+}
+
+// Save writes the spec to a JSON file.
+func (spec *GenesisSpec) Save(filename string) error {
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, append(data, '\n'), 0o644)
+}
+
+// Validate checks that a genesis block can be built from the spec.
+func (spec *GenesisSpec) Validate() error {
+	if strings.TrimSpace(spec.ChainID) == "" {
+		return errors.New("chain ID cannot be empty")
+	}
+
+	if spec.Timestamp <= 0 {
+		return errors.New("timestamp must be set")
+	}
+
+	if len(spec.Payload) == 0 {
+		return errors.New("payload must have at least one entry")
+	}
+
+	if spec.Difficulty < 1 || spec.Difficulty > MaxGenesisDifficulty {
+		return fmt.Errorf("difficulty must be between 1 and %d", MaxGenesisDifficulty)
+	}
+
+	if spec.Hash != "" {
+		if _, err := hex.DecodeString(spec.Hash); err != nil {
+			return errors.New("hash is not valid hex")
+		}
+	}
+
+	return nil
+}
+
+// MakeBlock builds the genesis block of the spec.
+// With a nonce in the spec the block is only checked against its difficulty, without one it is mined,
+// always starting from nonce 0, so the result is the same on every node.
+// If the spec has a hash, the block must have that hash.
+func (spec *GenesisSpec) MakeBlock() (*Block, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	dataList := make([]merkletree.Content, 0, len(spec.Payload))
+	for _, data := range spec.Payload {
+		transaction := Transaction{
+			Sender:    []byte{},
+			Recipient: []byte(spec.ChainID),
+			Timestamp: spec.Timestamp,
+			Data:      []byte(data),
+		}
+
+		dataList = append(dataList, transaction)
+	}
+
+	bits := BigToCompact(NewPOWWithDifficulty(spec.Difficulty).target)
+
+	if spec.Nonce == nil {
+		genesis := MakeAddBlock(spec.Timestamp, []byte{}, bits, 0, dataList)
+		genesis.solve()
+
+		return genesis, spec.checkHash(genesis)
+	}
+
+	genesis := MakeAddBlock(spec.Timestamp, []byte{}, bits, *spec.Nonce, dataList)
+	hash, err := genesis.CalculateHash()
+	if err != nil {
+		return nil, err
+	}
+	genesis.SetHash(hash)
+
+	if new(big.Int).SetBytes(hash).Cmp(genesis.GetTarget()) != -1 {
+		return nil, fmt.Errorf("genesis hash %x with nonce %d does not have %d leading zero bits", hash, *spec.Nonce, spec.Difficulty)
+	}
+
+	return genesis, spec.checkHash(genesis)
+}
+
+// Checks the hash of the genesis block against the hash of the spec, if it has one.
+func (spec *GenesisSpec) checkHash(genesis *Block) error {
+	if spec.Hash != "" && spec.Hash != hex.EncodeToString(genesis.GetHash()) {
+		return fmt.Errorf("genesis hash %x does not match the hash in the genesis file %s", genesis.GetHash(), spec.Hash)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Mines the genesis block of the spec and returns the spec with the nonce and hash that "genesis init" writes.
+func initTestGenesis(t *testing.T, spec *GenesisSpec) (*GenesisSpec, *Block) {
+	genesis, err := spec.MakeBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := genesis.GetNonce()
+	initialized := *spec
+	initialized.Nonce = &nonce
+	initialized.Hash = hex.EncodeToString(genesis.GetHash())
+
+	return &initialized, genesis
+}
+
+func TestGenesisNonce(t *testing.T) {
+	spec, mined := initTestGenesis(t, DefaultGenesis())
+
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	if err := spec.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadGenesis(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Nonce == nil || *loaded.Nonce != mined.GetNonce() {
+		t.Fatalf("nonce %v was not kept in the genesis file, want %d", loaded.Nonce, mined.GetNonce())
+	}
+
+	genesis, err := loaded.MakeBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(genesis.GetHash(), mined.GetHash()) || genesis.GetNonce() != mined.GetNonce() {
+		t.Fatalf("genesis block is %x with nonce %d, want %x with nonce %d", genesis.GetHash(), genesis.GetNonce(), mined.GetHash(), mined.GetNonce())
+	}
+}
+
+func TestGenesisInvalid(t *testing.T) {
+	spec, mined := initTestGenesis(t, DefaultGenesis())
+
+	tests := []struct {
+		name   string
+		change func(spec *GenesisSpec)
+		want   string
+	}{
+		{"nonce that misses the target", func(spec *GenesisSpec) {
+			// the nonces before the mined one all miss the target, since mining stops at the first that hits it
+			nonce := mined.GetNonce() - 1
+			spec.Nonce = &nonce
+			spec.Hash = ""
+		}, "leading zero bits"},
+		{"other hash", func(spec *GenesisSpec) { spec.Hash = strings.Repeat("00", 32) }, "does not match"},
+		{"other hash without nonce", func(spec *GenesisSpec) {
+			spec.Nonce = nil
+			spec.Hash = strings.Repeat("00", 32)
+		}, "does not match"},
+		{"difficulty too high", func(spec *GenesisSpec) { spec.Difficulty = MaxGenesisDifficulty + 1 }, "difficulty"},
+		{"no chain ID", func(spec *GenesisSpec) { spec.ChainID = " " }, "chain ID"},
+		{"no payload", func(spec *GenesisSpec) { spec.Payload = nil }, "payload"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changed := *spec
+			test.change(&changed)
+
+			if _, err := changed.MakeBlock(); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("err = %v, want one about %q", err, test.want)
+			}
+		})
+	}
+}
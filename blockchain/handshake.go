@@ -0,0 +1,421 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version of the protocol nodes speak with each other.
+// Bump ProtocolVersion when an RPC or encoding changes, and MinProtocolVersion when older nodes can no longer be talked to.
+// Version 2 added the Challenge step to the handshake.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 2
+
+	handshakeTimeout   = 3 * time.Second
+	handshakeNonceSize = 16
+	selfBanDuration    = 24 * time.Hour // our own address, no point in ever dialing it again
+
+	challengeExpiry = 30 * time.Second // time a caller has to use a challenge in its handshake
+	maxChallenges   = 256              // challenges handed out and not used yet, the oldest are dropped first
+	maxSessions     = 1024             // sessions of accepted callers, the least recently used are dropped first
+)
+
+// Whose signature a handshake signature is, so one side cannot pass off a signature of the other as its own.
+const (
+	handshakeCaller = "handshake"
+	handshakeAnswer = "handshake reply"
+)
+
+var (
+	ErrProtocolVersion   = errors.New("incompatible protocol version")
+	ErrWrongChain        = errors.New("peer is on a different chain")
+	ErrInvalidIdentity   = errors.New("peer did not prove its identity key")
+	ErrSelfConnection    = errors.New("connected to ourselves")
+	ErrHandshakeRejected = errors.New("peer rejected the handshake")
+	ErrNoIdentityKey     = errors.New("node has no identity key")
+	ErrUnknownChallenge  = errors.New("handshake does not answer a challenge of the peer")
+	ErrPeerBanned        = errors.New("peer is banned")
+	ErrNoSession         = errors.New("caller has not completed a handshake")
+)
+
+// The handshake takes two calls, so both sides prove their identity key over a nonce the other side chose:
+// the caller asks the peer for a challenge, then sends its handshake with a nonce of its own.
+// The caller signs both nonces with its fields, and the peer signs both nonces with its own fields in the reply.
+
+// ChallengeArg asks a peer for a nonce to sign in the handshake that follows.
+type ChallengeArg struct{}
+
+// Nonce	random challenge the caller signs in its handshake, valid once and for challengeExpiry
+type ChallengeReply struct {
+	Nonce []byte
+}
+
+// HandshakeArg is the first thing a node sends to a peer it connected to.
+// Version			protocol version of the sender
+// ChainID			chain ID from the genesis spec of the sender
+// GenesisHash		hash of the genesis block of the sender
+// BestHeight		height of the root of the sender
+// ListenAddress	address the sender serves its RPCs on, so the peer can connect back
+// PublicKey		identity key of the sender
+// Nonce			random challenge of the sender that the peer has to sign in its reply
+// Challenge		nonce the peer handed out with Challenge
+// Signature		signature of the sender over its own fields and both nonces (see handshakeBytes)
+type HandshakeArg struct {
+	Version       int
+	ChainID       string
+	GenesisHash   []byte
+	BestHeight    int
+	ListenAddress string
+	PublicKey     []byte
+	Nonce         []byte
+	Challenge     []byte
+	Signature     []byte
+}
+
+// HandshakeReply has the same fields, about the peer. Its signature is over its own fields and both nonces,
+// so it proves the peer holds its identity key right now.
+// Rejected		why the peer does not accept the caller (empty if it does)
+// Session		token the caller sends with every block, transaction and announcement (empty if it was rejected)
+type HandshakeReply struct {
+	Version       int
+	ChainID       string
+	GenesisHash   []byte
+	BestHeight    int
+	ListenAddress string
+	PublicKey     []byte
+	Signature     []byte
+	Rejected      string
+	Session       []byte
+}
+
+// RPC that hands out a challenge to a node that is about to send its handshake.
+func (node *Node) Challenge(args ChallengeArg, reply *ChallengeReply) error {
+	nonce, err := node.challenges.Issue()
+	if err != nil {
+		return err
+	}
+
+	reply.Nonce = nonce
+
+	return nil
+}
+
+// RPC that answers the handshake of a node that connected to us.
+// The reply always describes this node, so the caller can tell why it does not match.
+// The caller has to sign a challenge this node handed out, which can only be used once,
+// and may not be banned by its listen address or by the identity it proved.
+// A caller that matches and proves its identity key gets a session and has its listen address added to the address book.
+func (node *Node) Handshake(args HandshakeArg, reply *HandshakeReply) error {
+	if node.Key == nil {
+		return ErrNoIdentityKey
+	}
+
+	reply.Version = ProtocolVersion
+	reply.ChainID = node.LocalChain.GetChainID()
+	reply.GenesisHash = node.LocalChain.GetGenesis().GetHash()
+	reply.BestHeight = node.LocalChain.GetHeight()
+	reply.ListenAddress = node.GetSelfAddress()
+	reply.PublicKey = node.Key.Public().(ed25519.PublicKey)
+	reply.Signature = ed25519.Sign(node.Key, handshakeBytes(handshakeAnswer, reply.ChainID, reply.GenesisHash, reply.ListenAddress, args.Nonce, args.Challenge))
+
+	err := node.checkHandshake(args.Version, args.ChainID, args.GenesisHash, args.PublicKey)
+	if err == nil && !node.challenges.Use(args.Challenge) {
+		err = ErrUnknownChallenge
+	}
+	if err == nil && !verifyHandshake(args.PublicKey, args.Signature, handshakeBytes(handshakeCaller, args.ChainID, args.GenesisHash, args.ListenAddress, args.Nonce, args.Challenge)) {
+		err = ErrInvalidIdentity
+	}
+	if err == nil && node.peerManager.book.IsBannedNode(args.ListenAddress, AddressFromPublicKey(args.PublicKey)) {
+		err = ErrPeerBanned
+	}
+
+	if err != nil {
+		logDebug("RPC >>> Rejected handshake of %s: %v", args.ListenAddress, err)
+		reply.Rejected = err.Error()
+
+		return nil
+	}
+
+	session, err := node.sessions.Open(args.ListenAddress, AddressFromPublicKey(args.PublicKey))
+	if err != nil {
+		return err
+	}
+	reply.Session = session
+
+	if args.ListenAddress != "" && node.peerManager.book.Add(args.ListenAddress) {
+		logDebug("RPC >>> Learned address %s from its handshake", args.ListenAddress)
+	}
+
+	return nil
+}
+
+// Asks a peer we just connected to for a challenge, sends our handshake and checks its reply.
+// Returns ErrProtocolVersion, ErrWrongChain, ErrInvalidIdentity or ErrSelfConnection if we do not accept the peer,
+// and ErrHandshakeRejected if the peer does not accept us (see rejectionError).
+func (node *Node) handshake(peer *ServerConnection) (*HandshakeReply, error) {
+	if node.Key == nil {
+		return nil, ErrNoIdentityKey
+	}
+
+	var challenge ChallengeReply
+	if err := callWithTimeout(peer.rpcConnection, "Node.Challenge", ChallengeArg{}, &challenge, handshakeTimeout); err != nil {
+		return nil, err
+	}
+	if len(challenge.Nonce) != handshakeNonceSize {
+		return nil, fmt.Errorf("%w: challenge of %d bytes", ErrInvalidIdentity, len(challenge.Nonce))
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	args := HandshakeArg{
+		Version:       ProtocolVersion,
+		ChainID:       node.LocalChain.GetChainID(),
+		GenesisHash:   node.LocalChain.GetGenesis().GetHash(),
+		BestHeight:    node.LocalChain.GetHeight(),
+		ListenAddress: node.GetSelfAddress(),
+		PublicKey:     node.Key.Public().(ed25519.PublicKey),
+		Nonce:         nonce,
+		Challenge:     challenge.Nonce,
+	}
+	args.Signature = ed25519.Sign(node.Key, handshakeBytes(handshakeCaller, args.ChainID, args.GenesisHash, args.ListenAddress, nonce, challenge.Nonce))
+
+	reply := new(HandshakeReply)
+	if err := callWithTimeout(peer.rpcConnection, "Node.Handshake", args, reply, handshakeTimeout); err != nil {
+		return nil, err
+	}
+
+	if err := node.checkHandshake(reply.Version, reply.ChainID, reply.GenesisHash, reply.PublicKey); err != nil {
+		return nil, err
+	}
+
+	if !verifyHandshake(reply.PublicKey, reply.Signature, handshakeBytes(handshakeAnswer, reply.ChainID, reply.GenesisHash, reply.ListenAddress, nonce, challenge.Nonce)) {
+		return nil, ErrInvalidIdentity
+	}
+
+	if reply.Rejected != "" {
+		return nil, rejectionError(reply.Rejected)
+	}
+
+	return reply, nil
+}
+
+// Returns the error for a peer that rejected our handshake for the reason in its reply.
+// The error wraps ErrHandshakeRejected, and also the error of this package the reason starts with,
+// so the caller can tell a permanent mismatch from a rejection that may pass (like ErrUnknownChallenge).
+func rejectionError(reason string) error {
+	for _, cause := range []error{ErrProtocolVersion, ErrWrongChain, ErrInvalidIdentity, ErrSelfConnection, ErrUnknownChallenge, ErrPeerBanned} {
+		if rest, found := strings.CutPrefix(reason, cause.Error()); found {
+			return fmt.Errorf("%w: %w%s", ErrHandshakeRejected, cause, rest)
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrHandshakeRejected, reason)
+}
+
+// Returns the protocol version two nodes speak with each other: the lower of their versions.
+func negotiateVersion(theirs int) int {
+	if theirs < ProtocolVersion {
+		return theirs
+	}
+
+	return ProtocolVersion
+}
+
+// Checks the fields of a handshake against this node.
+func (node *Node) checkHandshake(version int, chainID string, genesisHash []byte, publicKey []byte) error {
+	if version < MinProtocolVersion {
+		return fmt.Errorf("%w: peer speaks version %d, we need at least %d", ErrProtocolVersion, version, MinProtocolVersion)
+	}
+
+	if chainID != node.LocalChain.GetChainID() || !bytes.Equal(genesisHash, node.LocalChain.GetGenesis().GetHash()) {
+		return fmt.Errorf("%w: peer is on %s with genesis %x", ErrWrongChain, chainID, genesisHash)
+	}
+
+	if len(publicKey) != ed25519.PublicKeySize {
+		return ErrInvalidIdentity
+	}
+
+	if bytes.Equal(publicKey, node.Key.Public().(ed25519.PublicKey)) {
+		return ErrSelfConnection
+	}
+
+	return nil
+}
+
+func verifyHandshake(publicKey []byte, signature []byte, message []byte) bool {
+	return len(publicKey) == ed25519.PublicKeySize && ed25519.Verify(publicKey, message, signature)
+}
+
+// Bytes a handshake signature is over: whose signature it is, the chain, the listen address of the signer,
+// the nonce of the caller and the challenge of the peer.
+func handshakeBytes(role string, chainID string, genesisHash []byte, listenAddress string, nonce []byte, challenge []byte) []byte {
+	var enc encoder
+
+	enc.writeBytes([]byte(role))
+	enc.writeBytes([]byte(chainID))
+	enc.writeBytes(genesisHash)
+	enc.writeBytes([]byte(listenAddress))
+	enc.writeBytes(nonce)
+	enc.writeBytes(challenge)
+
+	return enc.bytes()
+}
+
+func newNonce() ([]byte, error) {
+	nonce := make([]byte, handshakeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return nonce, nil
+}
+
+// Challenges the node handed out and that were not used in a handshake yet, with the time they were handed out.
+type challengeSet struct {
+	issued map[string]time.Time
+
+	mutex sync.Mutex
+}
+
+func newChallengeSet() *challengeSet {
+	return &challengeSet{issued: make(map[string]time.Time)}
+}
+
+// Issue returns a new challenge. Expired challenges are forgotten,
+// and the oldest one if maxChallenges are still waiting to be used.
+func (challenges *challengeSet) Issue() ([]byte, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	challenges.mutex.Lock()
+	defer challenges.mutex.Unlock()
+
+	now := time.Now()
+	oldest := ""
+	for key, issued := range challenges.issued {
+		if now.Sub(issued) > challengeExpiry {
+			delete(challenges.issued, key)
+		} else if oldest == "" || issued.Before(challenges.issued[oldest]) {
+			oldest = key
+		}
+	}
+
+	if len(challenges.issued) >= maxChallenges {
+		delete(challenges.issued, oldest)
+	}
+
+	challenges.issued[string(nonce)] = now
+
+	return nonce, nil
+}
+
+// Use checks that the challenge was handed out and has not expired, and forgets it so it cannot be used again.
+func (challenges *challengeSet) Use(nonce []byte) bool {
+	challenges.mutex.Lock()
+	defer challenges.mutex.Unlock()
+
+	issued, ok := challenges.issued[string(nonce)]
+	delete(challenges.issued, string(nonce))
+
+	return ok && time.Since(issued) <= challengeExpiry
+}
+
+// Checks that the caller of an RPC completed a handshake with this node and is not banned since.
+func (node *Node) checkSession(token []byte) error {
+	caller, ok := node.sessions.Get(token)
+	if !ok {
+		return ErrNoSession
+	}
+
+	if node.peerManager.book.IsBannedNode(caller.address, caller.identity) {
+		node.sessions.Remove(token)
+		return ErrPeerBanned
+	}
+
+	return nil
+}
+
+// Caller whose handshake this node accepted.
+// address	listen address the caller sent in its handshake
+// identity	address of the identity key the caller proved
+// used		last time the caller sent its session
+type session struct {
+	address  string
+	identity string
+	used     time.Time
+}
+
+// Sessions of the callers whose handshake this node accepted, by their token.
+// A node has one session with each identity, a new handshake replaces the old session.
+type sessionSet struct {
+	sessions map[string]*session
+
+	mutex sync.Mutex
+}
+
+func newSessionSet() *sessionSet {
+	return &sessionSet{sessions: make(map[string]*session)}
+}
+
+// Open returns the token of a new session for the caller. The least recently used session is dropped
+// if there are maxSessions already.
+func (sessions *sessionSet) Open(address string, identity string) ([]byte, error) {
+	token, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	oldest := ""
+	for key, known := range sessions.sessions {
+		if known.identity == identity {
+			delete(sessions.sessions, key)
+		} else if oldest == "" || known.used.Before(sessions.sessions[oldest].used) {
+			oldest = key
+		}
+	}
+
+	if len(sessions.sessions) >= maxSessions {
+		delete(sessions.sessions, oldest)
+	}
+
+	sessions.sessions[string(token)] = &session{address: address, identity: identity, used: time.Now()}
+
+	return token, nil
+}
+
+// Get returns the caller of the session and marks the session as used.
+func (sessions *sessionSet) Get(token []byte) (session, bool) {
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	known, ok := sessions.sessions[string(token)]
+	if !ok {
+		return session{}, false
+	}
+	known.used = time.Now()
+
+	return *known, true
+}
+
+// Remove ends the session.
+func (sessions *sessionSet) Remove(token []byte) {
+	sessions.mutex.Lock()
+	defer sessions.mutex.Unlock()
+
+	delete(sessions.sessions, string(token))
+}
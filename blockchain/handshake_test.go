@@ -0,0 +1,385 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Answers the handshake like node, but changes the reply before it is sent.
+type tamperingNode struct {
+	node   *Node
+	tamper func(reply *HandshakeReply)
+}
+
+func (fake *tamperingNode) Challenge(args ChallengeArg, reply *ChallengeReply) error {
+	return fake.node.Challenge(args, reply)
+}
+
+func (fake *tamperingNode) Handshake(args HandshakeArg, reply *HandshakeReply) error {
+	if err := fake.node.Handshake(args, reply); err != nil {
+		return err
+	}
+	fake.tamper(reply)
+
+	return nil
+}
+
+// Creates a node on the chain of the genesis spec.
+func newTestNodeOn(t *testing.T, spec *GenesisSpec) *Node {
+	node := newTestNode(t, 0)
+
+	chain, err := NewBlockChain(spec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.SetLocalChain(chain)
+
+	return node
+}
+
+// Asks the peer for a challenge and returns the handshake of the caller that answers it.
+func signedHandshake(t *testing.T, caller *Node, peer *Node) HandshakeArg {
+	var challenge ChallengeReply
+	if err := peer.Challenge(ChallengeArg{}, &challenge); err != nil {
+		t.Fatal(err)
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := HandshakeArg{
+		Version:       ProtocolVersion,
+		ChainID:       caller.LocalChain.GetChainID(),
+		GenesisHash:   caller.LocalChain.GetGenesis().GetHash(),
+		ListenAddress: "localhost:5001",
+		PublicKey:     caller.Key.Public().(ed25519.PublicKey),
+		Nonce:         nonce,
+		Challenge:     challenge.Nonce,
+	}
+	args.Signature = ed25519.Sign(caller.Key, handshakeBytes(handshakeCaller, args.ChainID, args.GenesisHash, args.ListenAddress, nonce, challenge.Nonce))
+
+	return args
+}
+
+func TestHandshake(t *testing.T) {
+	caller := newTestNode(t, 0)
+	peer := newTestNode(t, 1)
+
+	reply, err := caller.handshake(&ServerConnection{serverID: -1, rpcConnection: dialTestNode(t, peer)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if AddressFromPublicKey(reply.PublicKey) != peer.GetAddress() {
+		t.Fatalf("handshake is from %s, want %s", AddressFromPublicKey(reply.PublicKey), peer.GetAddress())
+	}
+
+	session, ok := peer.sessions.Get(reply.Session)
+	if !ok {
+		t.Fatal("peer did not open a session for the caller")
+	}
+	if session.identity != caller.GetAddress() {
+		t.Fatalf("session is for %s, want %s", session.identity, caller.GetAddress())
+	}
+}
+
+// The caller does not accept a peer that does not match it, whatever the peer thinks of the caller.
+func TestHandshakeChecksPeer(t *testing.T) {
+	otherChain := DefaultGenesis()
+	otherChain.ChainID = "other chain"
+	otherGenesis := DefaultGenesis()
+	otherGenesis.Timestamp++
+
+	_, otherKey, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		peer    func(caller *Node) any
+		want    error // nil if the peer is accepted
+		version int   // version the caller speaks with an accepted peer
+	}{
+		{"same version", func(*Node) any { return newTestNode(t, 1) }, nil, ProtocolVersion},
+		{"newer peer", func(*Node) any {
+			return &tamperingNode{newTestNode(t, 1), func(reply *HandshakeReply) { reply.Version = ProtocolVersion + 1 }}
+		}, nil, ProtocolVersion},
+		{"peer too old", func(*Node) any {
+			return &tamperingNode{newTestNode(t, 1), func(reply *HandshakeReply) { reply.Version = MinProtocolVersion - 1 }}
+		}, ErrProtocolVersion, 0},
+		{"other chain ID", func(*Node) any { return newTestNodeOn(t, otherChain) }, ErrWrongChain, 0},
+		{"other genesis block", func(*Node) any { return newTestNodeOn(t, otherGenesis) }, ErrWrongChain, 0},
+		{"bad signature", func(*Node) any {
+			return &tamperingNode{newTestNode(t, 1), func(reply *HandshakeReply) { reply.Signature[0] ^= 1 }}
+		}, ErrInvalidIdentity, 0},
+		{"signature of another key", func(*Node) any {
+			return &tamperingNode{newTestNode(t, 1), func(reply *HandshakeReply) { reply.PublicKey = otherKey.Public().(ed25519.PublicKey) }}
+		}, ErrInvalidIdentity, 0},
+		{"short public key", func(*Node) any {
+			return &tamperingNode{newTestNode(t, 1), func(reply *HandshakeReply) { reply.PublicKey = reply.PublicKey[:8] }}
+		}, ErrInvalidIdentity, 0},
+		{"self connection", func(caller *Node) any { return caller }, ErrSelfConnection, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			caller := newTestNode(t, 0)
+
+			reply, err := caller.handshake(&ServerConnection{serverID: -1, rpcConnection: dialTestReceiver(t, test.peer(caller))})
+			if test.want != nil {
+				if !errors.Is(err, test.want) {
+					t.Fatalf("err = %v, want %v", err, test.want)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version := negotiateVersion(reply.Version); version != test.version {
+				t.Fatalf("negotiated version %d, want %d", version, test.version)
+			}
+		})
+	}
+}
+
+// Two nodes speak the lower of their versions.
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		theirs int
+		want   int
+	}{
+		{ProtocolVersion - 1, ProtocolVersion - 1},
+		{ProtocolVersion, ProtocolVersion},
+		{ProtocolVersion + 1, ProtocolVersion},
+	}
+
+	for _, test := range tests {
+		if got := negotiateVersion(test.theirs); got != test.want {
+			t.Errorf("negotiateVersion(%d) = %d, want %d", test.theirs, got, test.want)
+		}
+	}
+}
+
+// The peer rejects a caller that does not match it or does not answer a fresh challenge,
+// and the caller can tell from the rejection why.
+func TestHandshakeRejectsCaller(t *testing.T) {
+	otherChain := DefaultGenesis()
+	otherChain.ChainID = "other chain"
+
+	tests := []struct {
+		name   string
+		caller func(t *testing.T, peer *Node) HandshakeArg
+		want   error
+	}{
+		{"old version", func(t *testing.T, peer *Node) HandshakeArg {
+			args := signedHandshake(t, newTestNode(t, 0), peer)
+			args.Version = MinProtocolVersion - 1
+			return args
+		}, ErrProtocolVersion},
+		{"other chain", func(t *testing.T, peer *Node) HandshakeArg {
+			return signedHandshake(t, newTestNodeOn(t, otherChain), peer)
+		}, ErrWrongChain},
+		{"bad signature", func(t *testing.T, peer *Node) HandshakeArg {
+			args := signedHandshake(t, newTestNode(t, 0), peer)
+			args.Signature[0] ^= 1
+			return args
+		}, ErrInvalidIdentity},
+		{"other listen address than signed", func(t *testing.T, peer *Node) HandshakeArg {
+			args := signedHandshake(t, newTestNode(t, 0), peer)
+			args.ListenAddress = "localhost:5002"
+			return args
+		}, ErrInvalidIdentity},
+		{"challenge the peer did not hand out", func(t *testing.T, peer *Node) HandshakeArg {
+			args := signedHandshake(t, newTestNode(t, 0), newTestNode(t, 2))
+			return args
+		}, ErrUnknownChallenge},
+		{"challenge used twice", func(t *testing.T, peer *Node) HandshakeArg {
+			args := signedHandshake(t, newTestNode(t, 0), peer)
+
+			var first HandshakeReply
+			if err := peer.Handshake(args, &first); err != nil {
+				t.Fatal(err)
+			}
+			if first.Rejected != "" || first.Session == nil {
+				t.Fatalf("first use of the challenge was rejected: %s", first.Rejected)
+			}
+
+			return args
+		}, ErrUnknownChallenge},
+		{"banned caller", func(t *testing.T, peer *Node) HandshakeArg {
+			args := signedHandshake(t, newTestNode(t, 0), peer)
+			peer.peerManager.book.Add(args.ListenAddress)
+			peer.peerManager.book.Ban(args.ListenAddress, DefaultBanDuration)
+			return args
+		}, ErrPeerBanned},
+		{"peer itself", func(t *testing.T, peer *Node) HandshakeArg {
+			return signedHandshake(t, peer, peer)
+		}, ErrSelfConnection},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peer := newTestNode(t, 1)
+			args := test.caller(t, peer)
+
+			var reply HandshakeReply
+			if err := peer.Handshake(args, &reply); err != nil {
+				t.Fatal(err)
+			}
+
+			if reply.Session != nil {
+				t.Fatal("rejected caller got a session")
+			}
+
+			err := rejectionError(reply.Rejected)
+			if !errors.Is(err, ErrHandshakeRejected) || !errors.Is(err, test.want) {
+				t.Fatalf("rejection %q gives %v, want %v", reply.Rejected, err, test.want)
+			}
+		})
+	}
+}
+
+func TestRejectionError(t *testing.T) {
+	err := rejectionError("something else")
+	if !errors.Is(err, ErrHandshakeRejected) || errors.Is(err, ErrWrongChain) {
+		t.Fatalf("unknown rejection gives %v", err)
+	}
+
+	err = rejectionError(ErrWrongChain.Error() + ": peer is on other with genesis 00")
+	if !errors.Is(err, ErrWrongChain) || !strings.HasSuffix(err.Error(), "peer is on other with genesis 00") {
+		t.Fatalf("rejection with details gives %v", err)
+	}
+}
+
+// Only peers that can never match are banned, the others are dialed again with backoff.
+func TestHandshakeFailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		banned bool
+	}{
+		{"timeout", errors.New("Node.Handshake timed out"), false},
+		{"wrong chain", ErrWrongChain, true},
+		{"old peer", ErrProtocolVersion, true},
+		{"invalid identity", ErrInvalidIdentity, true},
+		{"self connection", ErrSelfConnection, true},
+		{"rejected for another chain", rejectionError(ErrWrongChain.Error()), true},
+		{"rejected as too old", rejectionError(ErrProtocolVersion.Error()), true},
+		{"rejected for a stale challenge", rejectionError(ErrUnknownChallenge.Error()), false},
+		{"rejected since we are banned", rejectionError(ErrPeerBanned.Error()), false},
+		{"rejected for an unknown reason", rejectionError("busy"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newTestNode(t, 0)
+			address := "localhost:5001"
+			node.peerManager.book.Add(address)
+
+			node.peerManager.handshakeFailed(address, test.err)
+
+			if banned := node.peerManager.book.IsBanned(address); banned != test.banned {
+				t.Fatalf("banned = %v, want %v", banned, test.banned)
+			}
+
+			if !test.banned {
+				info := node.peerManager.book.Info()
+				if len(info) != 1 || info[0].Attempts != 1 || info[0].NextAttempt.IsZero() {
+					t.Fatalf("failed address was not backed off: %+v", info)
+				}
+			}
+		})
+	}
+}
+
+// Blocks, transactions and announcements are only taken from callers that completed a handshake and are not banned.
+func TestSessionRequired(t *testing.T) {
+	node := newTestNode(t, 0)
+	block := mineTestBlock(t, newTestNode(t, 1), 1)
+	transaction := newTestTransaction(t, "without a session")
+	hash, _ := transaction.CalculateHash()
+
+	send := func(session []byte) []error {
+		return []error{
+			node.ReceiveBlock(BlockArg{
+				Header:       makeHeaderArg(block.GetHeader()),
+				Hash:         block.GetHash(),
+				Transactions: block.GetTransactions(),
+				Session:      session,
+			}, &BlockReply{}),
+			node.ReceiveTransaction(TransactionArg{Transaction: transaction, Session: session}, &TransactionReply{}),
+			node.Inv(InvArg{Items: []InvItem{{Type: InvTransaction, Hash: hash}}, Session: session}, &InvReply{}),
+		}
+	}
+
+	for _, session := range [][]byte{nil, []byte("made up")} {
+		for _, err := range send(session) {
+			if !errors.Is(err, ErrNoSession) {
+				t.Fatalf("err = %v, want ErrNoSession", err)
+			}
+		}
+	}
+	if node.LocalChain.GetBlock(block.GetHash()) != nil || node.Mempool.Has(hash) {
+		t.Fatal("data of a caller without a session was taken")
+	}
+
+	// once the caller is banned its session is over
+	session, err := node.sessions.Open("localhost:5001", "banned caller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.peerManager.book.Add("localhost:5001")
+	node.peerManager.book.Ban("localhost:5001", DefaultBanDuration)
+
+	if err := node.Inv(InvArg{Session: session}, &InvReply{}); !errors.Is(err, ErrPeerBanned) {
+		t.Fatalf("err = %v, want ErrPeerBanned", err)
+	}
+	if _, ok := node.sessions.Get(session); ok {
+		t.Fatal("session of a banned caller was kept")
+	}
+}
+
+// A new handshake of the same identity replaces its session, and the least recently used session goes first.
+func TestSessionSet(t *testing.T) {
+	sessions := newSessionSet()
+
+	first, _ := sessions.Open("localhost:5001", "a")
+	second, _ := sessions.Open("localhost:5001", "a")
+	if _, ok := sessions.Get(first); ok {
+		t.Fatal("old session of the identity was kept")
+	}
+	if _, ok := sessions.Get(second); !ok {
+		t.Fatal("new session of the identity is missing")
+	}
+
+	for i := 1; i < maxSessions; i++ {
+		sessions.Open("localhost:5002", string(rune('b'+i)))
+	}
+	sessions.Get(second) // the session of a is now used most recently
+	sessions.Open("localhost:5003", "last")
+
+	if len(sessions.sessions) != maxSessions {
+		t.Fatalf("%d sessions, want %d", len(sessions.sessions), maxSessions)
+	}
+	if _, ok := sessions.Get(second); !ok {
+		t.Fatal("recently used session was dropped")
+	}
+}
+
+// Blocks with an unknown parent start at most one sync per minTriggerPeriod.
+func TestSyncTrigger(t *testing.T) {
+	manager := newTestNode(t, 0).syncManager
+
+	if !manager.Trigger() {
+		t.Fatal("first trigger did not start a sync")
+	}
+	if manager.Trigger() {
+		t.Fatal("second trigger right after the first started a sync")
+	}
+}
@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Number of bytes of the public key hash that make up an address.
+const addressLength = 20
+
+// GenerateKey creates a new Ed25519 key pair.
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// AddressFromPublicKey returns the address that belongs to a public key:
+// the hex encoding of the first 20 bytes of the SHA-256 hash of the key.
+func AddressFromPublicKey(publicKey ed25519.PublicKey) string {
+	hash := sha256.Sum256(publicKey)
+
+	return hex.EncodeToString(hash[:addressLength])
+}
+
+// IsValidAddress checks if the string has the format of an address (40 lowercase hex characters).
+func IsValidAddress(address string) bool {
+	if len(address) != 2*addressLength || strings.ToLower(address) != address {
+		return false
+	}
+
+	_, err := hex.DecodeString(address)
+
+	return err == nil
+}
+
+// LoadOrCreateKey reads the private key stored in the file (as a hex encoded seed).
+// If the file does not exist, a new key is generated and written to it.
+func LoadOrCreateKey(filename string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(filename)
+
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("key file %s does not contain a valid key", filename)
+		}
+
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	_, key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(filename, []byte(hex.EncodeToString(key.Seed())+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
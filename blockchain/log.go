@@ -0,0 +1,59 @@
+package blockchain
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// LogLevel is how important a message of the node is.
+// Messages below the level set with SetLogLevel are not printed.
+type LogLevel int32
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(int32(LogInfo))
+}
+
+// ParseLogLevel returns the level with the given name (debug, info, warn or error).
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LogDebug, nil
+	case "info":
+		return LogInfo, nil
+	case "warn":
+		return LogWarn, nil
+	case "error":
+		return LogError, nil
+	}
+
+	return LogInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// SetLogLevel sets the least important level that is still printed.
+func SetLogLevel(level LogLevel) {
+	logLevel.Store(int32(level))
+}
+
+// Prints the message if its level is not below the log level.
+func logf(level LogLevel, format string, args ...any) {
+	if int32(level) < logLevel.Load() {
+		return
+	}
+
+	fmt.Printf(format+"\n", args...)
+}
+
+func logDebug(format string, args ...any) { logf(LogDebug, format, args...) }
+func logInfo(format string, args ...any)  { logf(LogInfo, format, args...) }
+func logWarn(format string, args ...any)  { logf(LogWarn, format, args...) }
+func logError(format string, args ...any) { logf(LogError, format, args...) }
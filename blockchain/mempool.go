@@ -0,0 +1,239 @@
+package blockchain
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default limits of the mempool.
+const (
+	DefaultMempoolBytes  = 8 << 20 // 8 MiB of encoded transactions
+	DefaultMempoolExpiry = time.Hour
+)
+
+var (
+	ErrDuplicateTransaction = errors.New("transaction is already pending")
+	ErrTransactionMined     = errors.New("transaction is already in the chain")
+	ErrTransactionTooLarge  = errors.New("transaction is larger than the mempool")
+)
+
+// Mempool holds the transactions that are waiting to be put in a block.
+// Transactions are validated before they are admitted and indexed by hash, so the same transaction
+// is never pending twice and transactions that are already in the canonical chain are rejected.
+// The mempool is capped by the size of the encoded transactions. Transactions carry no fee,
+// so when it is full the oldest transactions are evicted first. Transactions older than expiry are dropped.
+// chain	chain that mined transactions are looked up in
+// entries	pending transactions by hash
+// size		sum of the encoded sizes of the pending transactions
+type Mempool struct {
+	chain    *BlockChain
+	entries  map[string]*mempoolEntry
+	size     int
+	maxBytes int
+	expiry   time.Duration
+
+	mutex sync.Mutex
+}
+
+// Pending transaction and when it was admitted.
+type mempoolEntry struct {
+	transaction Transaction
+	hash        []byte
+	size        int
+	added       time.Time
+}
+
+// NewMempool creates an empty mempool for the chain.
+// maxBytes caps the encoded size of all pending transactions, expiry is how long a transaction may stay pending.
+func NewMempool(chain *BlockChain, maxBytes int, expiry time.Duration) *Mempool {
+	return &Mempool{
+		chain:    chain,
+		entries:  make(map[string]*mempoolEntry),
+		maxBytes: maxBytes,
+		expiry:   expiry,
+	}
+}
+
+// Add validates the transaction and admits it to the mempool.
+// Returns the hash of the transaction.
+// Evicts the oldest transactions if there is not enough room for it.
+// Admitted transactions are published on the events of the chain.
+func (mempool *Mempool) Add(transaction Transaction) ([]byte, error) {
+	hash, _ := transaction.CalculateHash()
+
+	if err := transaction.Verify(); err != nil {
+		return hash, err
+	}
+
+	size := len(transaction.encode())
+	if size > mempool.maxBytes {
+		return hash, ErrTransactionTooLarge
+	}
+
+	if mempool.chain.HasTransaction(hash) {
+		return hash, ErrTransactionMined
+	}
+
+	if err := mempool.admit(transaction, hash, size); err != nil {
+		return hash, err
+	}
+
+	mempool.chain.Events().Publish(Event{Type: EventTransaction, Transaction: transaction, Hash: hash})
+
+	return hash, nil
+}
+
+// Puts a verified transaction into the mempool, evicting the oldest ones if there is not enough room for it.
+func (mempool *Mempool) admit(transaction Transaction, hash []byte, size int) error {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	if _, ok := mempool.entries[string(hash)]; ok {
+		return ErrDuplicateTransaction
+	}
+
+	mempool.expire()
+
+	for mempool.size+size > mempool.maxBytes {
+		oldest := mempool.sorted()[0]
+		logWarn(">>> Mempool is full, evicting transaction %x", oldest.hash)
+		mempool.remove(oldest.hash)
+	}
+
+	mempool.entries[string(hash)] = &mempoolEntry{
+		transaction: transaction,
+		hash:        hash,
+		size:        size,
+		added:       time.Now(),
+	}
+	mempool.size += size
+
+	return nil
+}
+
+// Get returns the pending transaction with the given hash.
+func (mempool *Mempool) Get(hash []byte) (Transaction, bool) {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	entry, ok := mempool.entries[string(hash)]
+	if !ok {
+		return Transaction{}, false
+	}
+
+	return entry.transaction, true
+}
+
+// Has checks if the transaction with the given hash is pending.
+func (mempool *Mempool) Has(hash []byte) bool {
+	_, ok := mempool.Get(hash)
+
+	return ok
+}
+
+// Size returns the number of pending transactions.
+func (mempool *Mempool) Size() int {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	return len(mempool.entries)
+}
+
+// Select returns up to limit pending transactions for a block template, oldest first.
+// Transactions that were mined in the meantime are removed instead of returned.
+func (mempool *Mempool) Select(limit int) []Transaction {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	mempool.expire()
+
+	var selected []Transaction
+	for _, entry := range mempool.sorted() {
+		if len(selected) >= limit {
+			break
+		}
+
+		if mempool.chain.HasTransaction(entry.hash) {
+			mempool.remove(entry.hash)
+			continue
+		}
+
+		selected = append(selected, entry.transaction)
+	}
+
+	return selected
+}
+
+// Transactions returns all pending transactions, oldest first.
+func (mempool *Mempool) Transactions() []Transaction {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	var transactions []Transaction
+	for _, entry := range mempool.sorted() {
+		transactions = append(transactions, entry.transaction)
+	}
+
+	return transactions
+}
+
+// RemoveMined removes the pending transactions that are in the canonical chain.
+// Called after blocks are added to the chain.
+func (mempool *Mempool) RemoveMined() {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	for _, entry := range mempool.entries {
+		if mempool.chain.HasTransaction(entry.hash) {
+			mempool.remove(entry.hash)
+		}
+	}
+}
+
+// Drops the transactions that have been pending longer than expiry. Mutex must be held.
+func (mempool *Mempool) expire() {
+	if mempool.expiry <= 0 {
+		return
+	}
+
+	deadline := time.Now().Add(-mempool.expiry)
+	for _, entry := range mempool.entries {
+		if entry.added.Before(deadline) {
+			logDebug(">>> Transaction %x expired from the mempool", entry.hash)
+			mempool.remove(entry.hash)
+		}
+	}
+}
+
+// Removes a transaction from the mempool. Mutex must be held.
+func (mempool *Mempool) remove(hash []byte) {
+	entry, ok := mempool.entries[string(hash)]
+	if !ok {
+		return
+	}
+
+	delete(mempool.entries, string(hash))
+	mempool.size -= entry.size
+}
+
+// Returns the entries ordered by when they were admitted, then by transaction timestamp. Mutex must be held.
+func (mempool *Mempool) sorted() []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(mempool.entries))
+	for _, entry := range mempool.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].added.Equal(entries[j].added) {
+			return entries[i].added.Before(entries[j].added)
+		}
+		if entries[i].transaction.Timestamp != entries[j].transaction.Timestamp {
+			return entries[i].transaction.Timestamp < entries[j].transaction.Timestamp
+		}
+		return string(entries[i].hash) < string(entries[j].hash)
+	})
+
+	return entries
+}
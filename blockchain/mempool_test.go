@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Creates a mempool for a fresh chain with the given limits.
+func newTestMempool(t *testing.T, maxBytes int, expiry time.Duration) *Mempool {
+	chain, err := NewBlockChain(DefaultGenesis(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewMempool(chain, maxBytes, expiry)
+}
+
+func addTestTransactions(t *testing.T, mempool *Mempool, count int) []Transaction {
+	var transactions []Transaction
+	for i := 0; i < count; i++ {
+		transaction := newTestTransaction(t, fmt.Sprintf("pending %d", i))
+		if _, err := mempool.Add(transaction); err != nil {
+			t.Fatal(err)
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	return transactions
+}
+
+func TestMempoolAddErrors(t *testing.T) {
+	node := newTestNode(t, 0)
+	mined := newTestTransaction(t, "mined")
+	if _, err := node.Mempool.Add(mined); err != nil {
+		t.Fatal(err)
+	}
+	mineTestBlock(t, node, 0)
+
+	pending := newTestTransaction(t, "pending")
+	if _, err := node.Mempool.Add(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned := newTestTransaction(t, "unsigned")
+	unsigned.Signature = nil
+
+	tampered := newTestTransaction(t, "tampered")
+	tampered.Data = []byte("changed after signing")
+
+	large := newTestTransaction(t, string(make([]byte, 2*DefaultMempoolBytes)))
+
+	tests := []struct {
+		name        string
+		transaction Transaction
+		want        error // nil if any error will do
+	}{
+		{"duplicate", pending, ErrDuplicateTransaction},
+		{"already mined", mined, ErrTransactionMined},
+		{"larger than the mempool", large, ErrTransactionTooLarge},
+		{"unsigned", unsigned, nil},
+		{"tampered", tampered, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := node.Mempool.Add(test.transaction)
+			if err == nil || (test.want != nil && !errors.Is(err, test.want)) {
+				t.Fatalf("err = %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	if size := node.Mempool.Size(); size != 1 {
+		t.Fatalf("mempool has %d transactions, want only the pending one", size)
+	}
+}
+
+// When a transaction does not fit, the oldest ones are evicted until it does.
+func TestMempoolEviction(t *testing.T) {
+	size := len(newTestTransaction(t, "pending 0").encode())
+	mempool := newTestMempool(t, 3*size, time.Hour)
+
+	transactions := addTestTransactions(t, mempool, 5)
+
+	if got := mempool.Size(); got != 3 {
+		t.Fatalf("mempool has %d transactions, want 3", got)
+	}
+	for i, transaction := range transactions {
+		hash, _ := transaction.CalculateHash()
+		if want := i >= 2; mempool.Has(hash) != want {
+			t.Errorf("transaction %d pending = %v, want %v", i, !want, want)
+		}
+	}
+}
+
+// Transactions that have been pending longer than expiry are dropped on the next Add or Select.
+func TestMempoolExpiry(t *testing.T) {
+	mempool := newTestMempool(t, DefaultMempoolBytes, time.Minute)
+	transactions := addTestTransactions(t, mempool, 3)
+
+	hash, _ := transactions[0].CalculateHash()
+	mempool.mutex.Lock()
+	mempool.entries[string(hash)].added = time.Now().Add(-2 * time.Minute)
+	mempool.mutex.Unlock()
+
+	selected := mempool.Select(10)
+	if len(selected) != 2 || mempool.Has(hash) {
+		t.Fatalf("selected %d transactions, want the 2 that did not expire", len(selected))
+	}
+
+	// the expired transaction may be admitted again
+	if _, err := mempool.Add(transactions[0]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Select returns the oldest transactions first and skips the ones that were mined in the meantime.
+func TestMempoolSelect(t *testing.T) {
+	node := newTestNode(t, 0)
+	transactions := addTestTransactions(t, node.Mempool, 4)
+
+	selected := node.Mempool.Select(2)
+	if len(selected) != 2 {
+		t.Fatalf("selected %d transactions, want 2", len(selected))
+	}
+	for i := range selected {
+		if string(selected[i].Data) != string(transactions[i].Data) {
+			t.Fatalf("transaction %d is %q, want %q", i, selected[i].Data, transactions[i].Data)
+		}
+	}
+
+	// mined and added without RemoveMined, as if the block had come from another node
+	block := node.BuildBlockTemplate()
+	if _, err := block.MineParallel(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.LocalChain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	if selected := node.Mempool.Select(10); len(selected) != 0 {
+		t.Fatalf("selected %d mined transactions", len(selected))
+	}
+	if size := node.Mempool.Size(); size != 0 {
+		t.Fatalf("mempool still has %d mined transactions", size)
+	}
+}
@@ -0,0 +1,172 @@
+package blockchain
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default time between two attempts of the miner to build a block template.
+const DefaultMineInterval = 5 * time.Second
+
+// Upper bound on the encoded size of the transactions in a block template.
+const maxBlockBytes = 1 << 20
+
+// Miner builds block templates from the mempool in the background and mines them.
+// Every interval it takes up to GetMax() pending transactions (and at most maxBlockBytes of them),
+// builds a block on top of the root of the local chain and mines it.
+// When the root changes while a template is being mined, the template is abandoned and a new one is built on the new root.
+// Templates are mined on all cores (see Block.MineParallel).
+// node		node whose mempool and chain are used, and that mined blocks are sent from
+// interval	time between two templates when the mempool has nothing to mine
+// workers	number of goroutines that mine a template (GOMAXPROCS if < 1)
+// newTip	signalled (without blocking) when the root of the local chain changes
+// hashrate	hashes per second of the last template, stored as the bits of a float64
+// started	closes done right away if the miner is stopped before it was started
+// stopped	makes sure quit is only closed once
+type Miner struct {
+	node     *Node
+	interval time.Duration
+	workers  int
+	newTip   chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+	hashrate atomic.Uint64
+
+	started sync.Once
+	stopped sync.Once
+}
+
+// NewMiner creates a miner for the node. It does not start mining until Start is called.
+func NewMiner(node *Node, interval time.Duration, workers int) *Miner {
+	return &Miner{
+		node:     node,
+		interval: interval,
+		workers:  workers,
+		newTip:   make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Hashrate returns the hashes per second the miner reached on the last template it mined.
+func (miner *Miner) Hashrate() float64 {
+	return math.Float64frombits(miner.hashrate.Load())
+}
+
+// Start runs the mining loop in the background. A miner only runs once, later calls do nothing.
+func (miner *Miner) Start() {
+	miner.started.Do(func() {
+		go miner.loop()
+	})
+}
+
+// Stop stops the mining loop, abandoning the template that is being mined, and waits for it to exit.
+// It may be called more than once, and before Start (the miner can then not be started anymore).
+func (miner *Miner) Stop() {
+	miner.stopped.Do(func() {
+		close(miner.quit)
+	})
+	miner.started.Do(func() {
+		close(miner.done)
+	})
+
+	<-miner.done
+}
+
+// NotifyNewTip tells the miner that the root of the local chain changed.
+// Never blocks, so it can be called while the chain is being updated.
+func (miner *Miner) NotifyNewTip() {
+	select {
+	case miner.newTip <- struct{}{}:
+	default:
+	}
+}
+
+// Mining loop. Mines a template every interval, and right away again after a template was abandoned for a new tip
+// or while the mempool still has transactions after a block was mined.
+func (miner *Miner) loop() {
+	defer close(miner.done)
+
+	ticker := time.NewTicker(miner.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-miner.quit:
+			return
+		case <-ticker.C:
+		}
+
+		for miner.mineTemplate() {
+		}
+	}
+}
+
+// Builds a template from the mempool and mines it.
+// If it is mined, the block is added to the local chain and sent to the peer nodes.
+// Returns true if a new template should be built right away: the template was abandoned because of a new tip,
+// or it was mined and there are still transactions in the mempool.
+func (miner *Miner) mineTemplate() bool {
+	// the root the template is built on is the current one, earlier notifications are outdated
+	select {
+	case <-miner.newTip:
+	default:
+	}
+
+	block := miner.node.BuildBlockTemplate()
+	if len(block.GetDataList()) == 0 {
+		return false
+	}
+
+	logDebug(">>> Mining block with %d transactions on top of %x", len(block.GetDataList()), block.GetParentBlockHash())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		stats MiningStats
+		err   error
+	}
+	found := make(chan result, 1)
+	go func() {
+		stats, err := block.MineParallel(ctx, miner.workers)
+		found <- result{stats, err}
+	}()
+
+	var mined result
+	select {
+	case mined = <-found:
+	case <-miner.newTip:
+		cancel()
+		mined = <-found
+		miner.hashrate.Store(math.Float64bits(mined.stats.Hashrate()))
+		logDebug(">>> Root of the chain changed, restarting mining on the new root")
+		return true
+	case <-miner.quit:
+		cancel()
+		<-found
+		return false
+	}
+
+	miner.hashrate.Store(math.Float64bits(mined.stats.Hashrate()))
+	if mined.err != nil {
+		logError(">>> Could not mine block: %v", mined.err)
+		return false
+	}
+	logInfo(">>> Mined block in %v (%d hashes, %.0f H/s)", mined.stats.Duration.Round(time.Millisecond), mined.stats.Hashes, mined.stats.Hashrate())
+
+	if err := miner.node.LocalChain.AddBlock(block); err != nil {
+		logWarn(">>> Could not add mined block to chain: %v", err)
+		return false
+	}
+	miner.node.Mempool.RemoveMined()
+	logInfo(">>> Succesfully mined and added block to chain. Hash: %x", block.GetHash())
+
+	miner.node.SendBlock(block)
+	logDebug(">>> Succesfully sent block (to be added to chain) to nodes")
+
+	return miner.node.Mempool.Size() > 0
+}
@@ -0,0 +1,121 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Creates a block with one transaction and the given target (in compact form), ready to be mined.
+func newMiningBlock(t *testing.T, bits uint32) *Block {
+	block := MakeBlock(make([]byte, 32))
+	block.SetBits(bits)
+	if err := block.Add(newTestTransaction(t, "mined")); err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+// Checks that the block has its hash set and that the hash is below its target.
+func checkMined(t *testing.T, block *Block) {
+	t.Helper()
+
+	hash, err := block.CalculateHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(block.GetHash(), hash) {
+		t.Fatalf("block hash is %x, the header hashes to %x", block.GetHash(), hash)
+	}
+	if new(big.Int).SetBytes(hash).Cmp(block.GetTarget()) != -1 {
+		t.Fatalf("hash %x is not below the target", hash)
+	}
+}
+
+func TestMineParallel(t *testing.T) {
+	for _, workers := range []int{0, 1, 2, 7} {
+		block := newMiningBlock(t, BigToCompact(NewPOWWithDifficulty(14).target))
+
+		stats, err := block.MineParallel(context.Background(), workers)
+		if err != nil {
+			t.Fatalf("%d workers: %v", workers, err)
+		}
+		checkMined(t, block)
+
+		if stats.Hashes == 0 || stats.Duration <= 0 {
+			t.Fatalf("%d workers: stats are %+v", workers, stats)
+		}
+	}
+}
+
+// One worker searches the nonces in order from 0, so it finds the same nonce as the sequential search.
+// More workers may find another nonce, but it has to be one the sequential search accepts.
+func TestMineParallelMatchesSolve(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		block := newMiningBlock(t, BigToCompact(NewPOWWithDifficulty(12).target))
+
+		if _, err := block.MineParallel(context.Background(), workers); err != nil {
+			t.Fatal(err)
+		}
+		nonce := block.GetNonce()
+
+		// the same header, searched from nonce 0 or from the nonce that was found
+		data, err := block.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sequential, err := DecodeBlock(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if workers == 1 {
+			sequential.SetNonce(0)
+		}
+
+		found, hash := sequential.solve()
+		if uint64(found) != nonce || !bytes.Equal(hash[:], block.GetHash()) {
+			t.Fatalf("%d workers found nonce %d with hash %x, the sequential search nonce %d with hash %x",
+				workers, nonce, block.GetHash(), found, hash)
+		}
+	}
+}
+
+func TestMineParallelCancel(t *testing.T) {
+	// a target no search can hit
+	block := newMiningBlock(t, BigToCompact(big.NewInt(1)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	stats, err := block.MineParallel(ctx, 2)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("mining returned %v, want the error of the context", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("mining stopped %v after it was cancelled", elapsed)
+	}
+	if len(block.GetHash()) != 0 {
+		t.Fatalf("cancelled block has hash %x", block.GetHash())
+	}
+	if stats.Hashes == 0 {
+		t.Fatal("cancelled mining reported no hashes")
+	}
+
+	// an already cancelled context stops mining right away
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := newMiningBlock(t, BigToCompact(big.NewInt(1))).MineParallel(cancelled, 2); !errors.Is(err, context.Canceled) {
+		t.Fatalf("mining with a cancelled context returned %v", err)
+	}
+}
+
+func TestMineParallelTransactionCount(t *testing.T) {
+	if _, err := MakeBlock(make([]byte, 32)).MineParallel(context.Background(), 1); err == nil {
+		t.Fatal("block without transactions was mined")
+	}
+}
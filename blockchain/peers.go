@@ -0,0 +1,484 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Peer discovery settings.
+const (
+	DefaultTargetPeers = 8
+
+	peerManagerInterval    = 5 * time.Second
+	dialTimeout            = 3 * time.Second
+	pingTimeout            = 3 * time.Second
+	addressTimeout         = 3 * time.Second // time a new peer gets to answer GetPeers and AddrAnnounce
+	maxPingFailures        = 3               // pings in a row that may fail before the peer is disconnected
+	maxAddressesPerMessage = 100
+
+	DefaultBanDuration = 10 * time.Minute
+)
+
+// GetPeersArg asks a peer for addresses of nodes it knows.
+type GetPeersArg struct {
+	Limit int
+}
+
+// Addresses	addresses from the address book of the peer, best score first
+type GetPeersReply struct {
+	Addresses []string
+}
+
+// AddrAnnounceArg tells a peer about addresses of nodes, usually the listen address of the sender.
+type AddrAnnounceArg struct {
+	Addresses []string
+}
+
+// Added	number of addresses that were new to the peer
+type AddrAnnounceReply struct {
+	Added int
+}
+
+// PingArg checks that a peer is still there. The peer sends the nonce back.
+type PingArg struct {
+	Nonce int64
+}
+
+// Nonce	nonce of the ping
+// Height	height of the root of the peer
+type PingReply struct {
+	Nonce  int64
+	Height int
+}
+
+// PeerInfoArg asks a node about its peers.
+type PeerInfoArg struct{}
+
+// Peers	every address the node knows, with the state of its connection
+type PeerInfoReply struct {
+	Peers []PeerInfo
+}
+
+// RPC that answers a health check of a peer.
+func (node *Node) Ping(args PingArg, reply *PingReply) error {
+	reply.Nonce = args.Nonce
+	reply.Height = node.LocalChain.GetHeight()
+
+	return nil
+}
+
+// RPC that returns the addresses the node knows and the state of its connection to each of them.
+func (node *Node) PeerInfo(args PeerInfoArg, reply *PeerInfoReply) error {
+	reply.Peers = node.GetPeerInfo()
+
+	return nil
+}
+
+// RPC that returns addresses from the address book, so the caller can find more nodes.
+func (node *Node) GetPeers(args GetPeersArg, reply *GetPeersReply) error {
+	limit := args.Limit
+	if limit <= 0 || limit > maxAddressesPerMessage {
+		limit = maxAddressesPerMessage
+	}
+
+	reply.Addresses = append(reply.Addresses, node.peerManager.book.Addresses(limit)...)
+
+	return nil
+}
+
+// RPC that adds the announced addresses to the address book.
+// The peer manager connects to them when it needs more peers.
+func (node *Node) AddrAnnounce(args AddrAnnounceArg, reply *AddrAnnounceReply) error {
+	if len(args.Addresses) > maxAddressesPerMessage {
+		return errors.New("too many addresses in one announcement")
+	}
+
+	for _, address := range args.Addresses {
+		if address != node.GetSelfAddress() && node.peerManager.book.Add(address) {
+			reply.Added++
+		}
+	}
+
+	if reply.Added > 0 {
+		logDebug(">>> Learned %d new peer addresses", reply.Added)
+	}
+
+	return nil
+}
+
+// PeerManager keeps the node connected to enough peers.
+// Addresses come from the config (persistent peers), seed nodes, and the GetPeers / AddrAnnounce RPCs.
+// Every peerManagerInterval it pings the connected peers and disconnects the ones that stopped answering,
+// connects to the best addresses of the address book until the node has target peers
+// (persistent peers are always reconnected to), and announces the address of the node.
+// Failed addresses are dialed again with exponential backoff, banned ones not until their ban is over.
+// started		closes done right away if the peer manager is stopped before it was started
+// stopped		makes sure quit is only closed once
+// firstPeer	closed once the first peer is connected
+type PeerManager struct {
+	node   *Node
+	book   *AddressBook
+	target atomic.Int32
+
+	quit chan struct{}
+	done chan struct{}
+
+	started sync.Once
+	stopped sync.Once
+
+	firstPeer     chan struct{}
+	firstPeerOnce sync.Once
+}
+
+// NewPeerManager creates a peer manager for the node that aims for target connected peers.
+func NewPeerManager(node *Node, target int) *PeerManager {
+	manager := &PeerManager{
+		node: node,
+		book: NewAddressBook(),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+
+		firstPeer: make(chan struct{}),
+	}
+	manager.target.Store(int32(target))
+
+	return manager
+}
+
+// Start runs the peer manager in the background. It only runs once, later calls do nothing.
+func (manager *PeerManager) Start() {
+	manager.started.Do(func() {
+		go manager.loop()
+	})
+}
+
+// Stop stops the peer manager and waits for it to exit.
+// It may be called more than once, and before Start (the peer manager can then not be started anymore).
+func (manager *PeerManager) Stop() {
+	manager.stopped.Do(func() {
+		close(manager.quit)
+	})
+	manager.started.Do(func() {
+		close(manager.done)
+	})
+
+	<-manager.done
+}
+
+func (manager *PeerManager) loop() {
+	defer close(manager.done)
+
+	ticker := time.NewTicker(peerManagerInterval)
+	defer ticker.Stop()
+
+	for {
+		manager.checkPeers()
+		manager.fillPeers()
+		manager.announce()
+
+		select {
+		case <-manager.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Connects to persistent peers that are not connected, and to the best other addresses until there are target peers.
+func (manager *PeerManager) fillPeers() {
+	connected := map[string]bool{manager.node.GetSelfAddress(): true}
+	for _, peer := range manager.node.connectedPeers() {
+		connected[peer.address] = true
+	}
+	count := len(connected) - 1
+
+	dial := manager.book.Persistent(connected)
+	for _, address := range dial {
+		connected[address] = true
+		count++
+	}
+
+	if target := int(manager.target.Load()); count < target {
+		dial = append(dial, manager.book.Candidates(connected, target-count)...)
+	}
+
+	var wg sync.WaitGroup
+	for _, address := range dial {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			manager.connect(address)
+		}(address)
+	}
+	wg.Wait()
+}
+
+// Dials the address and adds it to the connected peers.
+// Asks the new peer for the addresses it knows and tells it the address of this node.
+func (manager *PeerManager) connect(address string) {
+	manager.book.Attempt(address)
+
+	client, err := dialPeer(address, dialTimeout)
+	if err != nil {
+		manager.book.Bad(address)
+		logDebug(">>> Could not connect to %s: %v", address, err)
+		return
+	}
+
+	peer := ServerConnection{serverID: manager.book.ID(address), address: address, rpcConnection: client}
+
+	info, err := manager.node.handshake(&peer)
+	if err != nil {
+		client.Close()
+		manager.handshakeFailed(address, err)
+		return
+	}
+	peer.publicKey = info.PublicKey
+	peer.version = negotiateVersion(info.Version)
+	peer.session = info.Session
+
+	if !manager.node.addConnectedPeer(peer) {
+		client.Close()
+		manager.book.Disconnected(address)
+		return
+	}
+	manager.book.Good(address)
+	manager.firstPeerOnce.Do(func() { close(manager.firstPeer) })
+	manager.book.Identified(address, AddressFromPublicKey(info.PublicKey), info.BestHeight, peer.version)
+	logInfo("Connected to %s (node %s, height %d, protocol version %d)", address, AddressFromPublicKey(info.PublicKey), info.BestHeight, peer.version)
+
+	var reply GetPeersReply
+	if err := callWithTimeout(client, "Node.GetPeers", GetPeersArg{Limit: maxAddressesPerMessage}, &reply, addressTimeout); err != nil {
+		logDebug("Response >>> could not get peers from %s: %v", address, err)
+	} else {
+		for _, learned := range reply.Addresses {
+			if learned != manager.node.GetSelfAddress() {
+				manager.book.Add(learned)
+			}
+		}
+	}
+
+	announce := AddrAnnounceArg{Addresses: []string{manager.node.GetSelfAddress()}}
+	if err := callWithTimeout(client, "Node.AddrAnnounce", announce, &AddrAnnounceReply{}, addressTimeout); err != nil {
+		logDebug("Response >>> could not announce our address to %s: %v", address, err)
+	}
+
+	// the new peer may have blocks that we do not
+	go manager.node.SyncChain()
+}
+
+// Drops an address whose handshake failed. Peers that can never match this node (another protocol version
+// or chain, or no proof of their identity key) are banned, whichever side found the mismatch.
+// Peers that did not answer, or rejected us for a reason that may pass (a stale challenge, a ban on us),
+// are dialed again with backoff like any failed connection.
+func (manager *PeerManager) handshakeFailed(address string, err error) {
+	switch {
+	case errors.Is(err, ErrSelfConnection):
+		manager.book.Ban(address, selfBanDuration)
+	case errors.Is(err, ErrProtocolVersion), errors.Is(err, ErrWrongChain), errors.Is(err, ErrInvalidIdentity):
+		manager.Ban(address, DefaultBanDuration, err)
+	default:
+		manager.book.Bad(address)
+		logDebug(">>> Handshake with %s failed: %v", address, err)
+	}
+}
+
+// Pings every connected peer. Peers whose connection is closed, or that did not answer
+// maxPingFailures pings in a row, are disconnected so the next fillPeers reconnects to them.
+func (manager *PeerManager) checkPeers() {
+	var wg sync.WaitGroup
+
+	for _, peer := range manager.node.connectedPeers() {
+		wg.Add(1)
+		go func(peer ServerConnection) {
+			defer wg.Done()
+
+			start := time.Now()
+			var reply PingReply
+			err := callWithTimeout(peer.rpcConnection, "Node.Ping", PingArg{Nonce: start.UnixNano()}, &reply, pingTimeout)
+			if err == nil {
+				manager.book.Pinged(peer.address, time.Since(start), reply.Height)
+				return
+			}
+
+			if errors.Is(err, rpc.ErrShutdown) || manager.book.PingFailed(peer.address) >= maxPingFailures {
+				logWarn(">>> Lost connection to %s: %v", peer.address, err)
+				manager.disconnect(peer.address)
+			}
+		}(peer)
+	}
+
+	wg.Wait()
+}
+
+// Closes the connection to the peer. It is dialed again after the shortest reconnect delay.
+func (manager *PeerManager) disconnect(address string) {
+	manager.node.removeConnectedPeer(address)
+	manager.book.Disconnected(address)
+}
+
+// Ban disconnects the peer and does not connect to it again for duration.
+func (manager *PeerManager) Ban(address string, duration time.Duration, reason error) {
+	logWarn(">>> Banning %s for %v: %v", address, duration, reason)
+
+	manager.node.removeConnectedPeer(address)
+	manager.book.Ban(address, duration)
+}
+
+// Tells every connected peer the address of this node, so nodes that only learned about us can connect back.
+func (manager *PeerManager) announce() {
+	args := AddrAnnounceArg{Addresses: []string{manager.node.GetSelfAddress()}}
+
+	for _, peer := range manager.node.connectedPeers() {
+		peer.rpcConnection.Go("Node.AddrAnnounce", args, &AddrAnnounceReply{}, nil)
+	}
+}
+
+// Calls the RPC and gives up after timeout. The call itself keeps running until the client is closed.
+// After a timeout reply may still be written to, so callers must not read it unless the call succeeded.
+func callWithTimeout(client *rpc.Client, method string, args any, reply any, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-timer.C:
+		return errors.New(method + " timed out")
+	}
+}
+
+// Connects to the RPC server of a node the way rpc.DialHTTP does, but gives up after timeout.
+func dialPeer(address string, timeout time.Duration) (*rpc.Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+
+	response, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && response.Status != "200 Connected to Go RPC" {
+		err = errors.New("unexpected HTTP response: " + response.Status)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return rpc.NewClient(conn), nil
+}
+
+// Returns a copy of the connected peers.
+func (node *Node) connectedPeers() []ServerConnection {
+	node.mutex.RLock()
+	defer node.mutex.RUnlock()
+
+	var peers []ServerConnection
+	for _, peer := range node.peerNodes {
+		if peer.rpcConnection != nil {
+			peers = append(peers, peer)
+		}
+	}
+
+	return peers
+}
+
+// Adds a peer to the connected peers.
+// Returns false if a peer with that address or identity key is already connected.
+func (node *Node) addConnectedPeer(peer ServerConnection) bool {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	for _, connected := range node.peerNodes {
+		if connected.address == peer.address || (peer.publicKey != nil && bytes.Equal(connected.publicKey, peer.publicKey)) {
+			return false
+		}
+	}
+
+	node.peerNodes = append(node.peerNodes, peer)
+
+	return true
+}
+
+// Removes the peer with the address from the connected peers and closes its connection.
+func (node *Node) removeConnectedPeer(address string) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	for i, peer := range node.peerNodes {
+		if peer.address == address {
+			if peer.rpcConnection != nil {
+				peer.rpcConnection.Close()
+			}
+			node.peerNodes = append(node.peerNodes[:i], node.peerNodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Adds a peer from the config, with its ID there. The node always stays connected to it.
+func (node *Node) AddPeer(id int, address string) {
+	node.peerManager.book.AddPersistent(id, address)
+}
+
+// Adds a seed node: an address to ask for other nodes when the node starts.
+func (node *Node) AddSeed(address string) bool {
+	return node.peerManager.book.Add(address)
+}
+
+// BanPeer disconnects the peer with the address and does not connect to it again for DefaultBanDuration.
+func (node *Node) BanPeer(address string, reason error) {
+	node.peerManager.Ban(address, DefaultBanDuration, reason)
+}
+
+// GetPeerInfo returns every address the node knows and the state of its connection to each of them.
+func (node *Node) GetPeerInfo() []PeerInfo {
+	return node.peerManager.book.Info()
+}
+
+// Sets how many peers the node tries to stay connected to.
+func (node *Node) SetTargetPeers(target int) {
+	node.peerManager.target.Store(int32(target))
+}
+
+// WaitForPeer waits until the peer manager connected to its first peer, and returns false if that took longer than timeout.
+// A node that knows no address to connect to does not wait.
+func (node *Node) WaitForPeer(timeout time.Duration) bool {
+	manager := node.peerManager
+	if manager.book.Size() == 0 {
+		return false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-manager.firstPeer:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// GetPeerAddresses returns the addresses of the connected peers.
+func (node *Node) GetPeerAddresses() []string {
+	var addresses []string
+	for _, peer := range node.connectedPeers() {
+		addresses = append(addresses, peer.address)
+	}
+
+	return addresses
+}
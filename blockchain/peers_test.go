@@ -0,0 +1,65 @@
+package blockchain
+
+import (
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeerManagerStop(t *testing.T) {
+	// stopped twice after it ran
+	node := newTestNode(t, 0)
+	node.peerManager.Start()
+	node.peerManager.Stop()
+	node.peerManager.Stop()
+
+	// stopped before it was started, it cannot be started anymore
+	unstarted := newTestNode(t, 1)
+	unstarted.peerManager.Stop()
+	unstarted.peerManager.Start()
+	unstarted.peerManager.Stop()
+}
+
+func TestWaitForPeer(t *testing.T) {
+	// a node that knows no address does not wait
+	alone := newTestNode(t, 0)
+	start := time.Now()
+	if alone.WaitForPeer(time.Minute) {
+		t.Fatal("node without addresses reported a peer")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("node without addresses waited %v", time.Since(start))
+	}
+
+	// a node whose peer does not answer gives up after the timeout
+	unreachable := newTestNode(t, 1)
+	unreachable.AddPeer(2, "127.0.0.1:1")
+	unreachable.peerManager.Start()
+	t.Cleanup(unreachable.peerManager.Stop)
+	if unreachable.WaitForPeer(100 * time.Millisecond) {
+		t.Fatal("node reported a peer it could not connect to")
+	}
+
+	// a node reports its peer as soon as the handshake with it is done
+	peer := newTestNode(t, 2)
+	server := rpc.NewServer()
+	if err := server.RegisterName("Node", peer); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	node := newTestNode(t, 1)
+	node.AddPeer(2, strings.TrimPrefix(httpServer.URL, "http://"))
+	node.peerManager.Start()
+	t.Cleanup(node.peerManager.Stop)
+
+	if !node.WaitForPeer(5 * time.Second) {
+		t.Fatal("node did not report its peer")
+	}
+	if addresses := node.GetPeerAddresses(); len(addresses) != 1 {
+		t.Fatalf("node is connected to %v, want its peer", addresses)
+	}
+}
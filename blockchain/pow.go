@@ -2,11 +2,19 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"math/big"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Inspired by Noah Hein's "Building a Blockchain in Go PT:II - Proof of Work"
@@ -17,6 +25,12 @@ import (
 // In the real world, difficulty is set in each block and is based on the number of nodes in the network
 const difficulty = 12
 
+// Number of nonces that are tried between two checks of whether mining should stop.
+const quitCheckInterval = 1024
+
+// Highest nonce that is tried before the timestamp of the block is rolled forward.
+const maxNonce = math.MaxUint32
+
 type ProofOfWork struct {
 	target *big.Int
 }
@@ -26,14 +40,43 @@ type ProofOfWork struct {
 // sets z = x << n and returns z
 // z = 1 * 2^T(256 - difficulty)
 func NewPOW() *ProofOfWork {
+	return NewPOWWithDifficulty(difficulty)
+}
+
+// Same as NewPOW, but with the given number of leading zero bits instead of the default difficulty.
+// Used for the genesis block, whose difficulty comes from the genesis file.
+func NewPOWWithDifficulty(bits int) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-difficulty)) // left shift
+	target.Lsh(target, uint(256-bits)) // left shift
 
 	pow := &ProofOfWork{target}
 
 	return pow
 }
 
+// Proof of work with the target stored in the header of a block (see CompactToBig).
+func NewPOWFromBits(bits uint32) *ProofOfWork {
+	return &ProofOfWork{CompactToBig(bits)}
+}
+
+// Expected number of hashes needed to find a hash below the target of the block: 2^256 / (target + 1)
+// Used to compare how much proof of work different branches of the chain have.
+func blockWork(block *Block) *big.Int {
+	return targetWork(block.GetTarget())
+}
+
+// Same as blockWork, for a header whose block has not been downloaded yet.
+func headerWork(header *BlockHeader) *big.Int {
+	return targetWork(CompactToBig(header.bits))
+}
+
+func targetWork(target *big.Int) *big.Int {
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+
+	return work.Div(work, denominator)
+}
+
 // ToHex converts int64 to []byte
 func ToHex(num int64) []byte {
 	buff := new(bytes.Buffer)
@@ -44,46 +87,42 @@ func ToHex(num int64) []byte {
 	return buff.Bytes()
 }
 
-// Takes nonce, timestamps, parentBlockHash, and root hash of the transaction Merkle Tree
-// Returns []byte for Nonce
+// Takes the header version, parentBlockHash, root hash of the transaction Merkle Tree, timestamp, bits and nonce
+// Returns the canonical encoding of the block header, which is what gets hashed
 func (block *Block) BlockDataToBytes() []byte {
-	data := bytes.Join(
-		[][]byte{
-			block.header.parentBlockHash,
-			block.GetData().Root.Tree.MerkleRoot(), // root hash of merkle tree
-			ToHex(int64(block.GetNonce())),
-			ToHex(int64(block.GetTimestamp())),
-		},
-		[]byte{},
-	)
-	return data
+	return block.header.encode()
 }
 
 // Mining of the block.
 // Gets a specific hash that is less than the target hash
+// Blocks may hold anywhere from one transaction up to GetMax() transactions
 // Returns nonce and hash
 func (block *Block) Mine() (int, [32]byte) {
-	var intHash big.Int
 	var hash [32]byte
 
-	nonce := 0
-
-	if len(block.dataList) != max {
+	if len(block.dataList) == 0 || len(block.dataList) > max {
 		fmt.Println()
-		log.Println("Not enough transactions to mine block")
+		log.Println("Block must have between 1 and " + strconv.Itoa(max) + " transactions to be mined")
 		fmt.Println()
 		return 0, hash
 	}
 
-	for nonce < math.MaxInt64 {
-		hash, _ := block.CalculateHash()
+	return block.solve()
+}
 
-		// prints out all the hashes
-		/*
-			fmt.Printf("\r%x", hash) // \r is carriage return, %x is hex
-			fmt.Println()
-		*/
-		intHash.SetBytes(hash[:])
+// Runs the nonce loop of Mine without checking how many transactions the block has.
+// The genesis block is mined with this since its number of transactions comes from the genesis file.
+// The search is sequential and starts from the current nonce of the block, so every node finds the same nonce.
+// Returns the nonce that was found, which is also set in the block, and the hash of the block.
+func (block *Block) solve() (int, [32]byte) {
+	var intHash big.Int
+	var hash [32]byte
+
+	nonce := block.GetNonce()
+
+	for nonce < math.MaxInt64 {
+		digest, _ := block.CalculateHash()
+		intHash.SetBytes(digest)
 
 		// if intHash < target, then we have found a valid hash
 		// Cmp compares x and y and returns:
@@ -91,18 +130,156 @@ func (block *Block) Mine() (int, [32]byte) {
 		// 0 if x == y
 		// +1 if x > y
 		if intHash.Cmp(block.GetTarget()) == -1 {
-			//fmt.Printf("%x", hash)
-			block.SetHash(hash)
-			//fmt.Printf("Hash has been set: %x", hash)
+			block.SetHash(digest)
+			copy(hash[:], digest)
 			break
-		} else {
-			nonce++
-			block.SetNonce(uint64(nonce))
 		}
+
+		nonce++
+		block.SetNonce(nonce)
 	}
-	fmt.Println()
 
-	return nonce, hash
+	return int(nonce), hash
+}
+
+// MiningStats describes how much work went into mining a block.
+// Hashes		number of header hashes that were calculated
+// Duration		time spent mining
+type MiningStats struct {
+	Hashes   uint64
+	Duration time.Duration
+}
+
+// Hashrate returns the number of hashes per second.
+func (stats MiningStats) Hashrate() float64 {
+	if stats.Duration <= 0 {
+		return 0
+	}
+
+	return float64(stats.Hashes) / stats.Duration.Seconds()
+}
+
+// MineParallel mines the block on several cores.
+// The nonces are split into one contiguous range per worker (GOMAXPROCS workers if workers < 1).
+// If no worker finds a hash below the target, the timestamp is rolled forward, which changes every hash, and the search starts over.
+// Stops when ctx is cancelled and returns the error of ctx, the block then has no hash.
+func (block *Block) MineParallel(ctx context.Context, workers int) (MiningStats, error) {
+	var hashes atomic.Uint64
+
+	if len(block.dataList) == 0 || len(block.dataList) > max {
+		return MiningStats{}, errors.New("block must have between 1 and " + strconv.Itoa(max) + " transactions to be mined")
+	}
+
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	start := time.Now()
+	target := block.GetTarget()
+
+	for {
+		nonce, found := searchNonces(ctx, block.BlockDataToBytes(), target, workers, &hashes)
+
+		stats := MiningStats{Hashes: hashes.Load(), Duration: time.Since(start)}
+
+		if found {
+			block.SetNonce(nonce)
+			hash, _ := block.CalculateHash()
+			block.SetHash(hash)
+
+			return stats, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		block.rollTimestamp()
+	}
+}
+
+// Moves the timestamp of the block forward to now, or by one nanosecond if the clock has not moved on.
+// Gives the block a fresh set of hashes when all the nonces have been tried.
+func (block *Block) rollTimestamp() {
+	timestamp := time.Now().UnixNano()
+	if timestamp <= block.header.timestamp {
+		timestamp = block.header.timestamp + 1
+	}
+
+	block.header.timestamp = timestamp
+}
+
+// Searches the nonces from 0 up to maxNonce for the encoded header, split over the workers.
+// Returns the nonce that a worker found first, or false if none was found or ctx was cancelled.
+func searchNonces(ctx context.Context, header []byte, target *big.Int, workers int, hashes *atomic.Uint64) (uint64, bool) {
+	var wg sync.WaitGroup
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan uint64, workers)
+	size := maxNonce/uint64(workers) + 1
+
+	for worker := 0; worker < workers; worker++ {
+		first := uint64(worker) * size
+		last := first + size - 1
+		if last > maxNonce {
+			last = maxNonce
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if nonce, found := searchNonceRange(ctx, header, target, first, last, hashes); found {
+				results <- nonce
+				cancel() // stops the other workers
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case nonce := <-results:
+		return nonce, true
+	default:
+		return 0, false
+	}
+}
+
+// Tries the nonces from first up to and including last.
+// The nonce is the last field of the encoded header, so only its 8 bytes are rewritten for every try.
+func searchNonceRange(ctx context.Context, header []byte, target *big.Int, first, last uint64, hashes *atomic.Uint64) (uint64, bool) {
+	var intHash big.Int
+
+	data := append([]byte{}, header...)
+	nonceBytes := data[len(data)-8:]
+
+	count := uint64(0)
+	defer func() { hashes.Add(count) }()
+
+	for nonce := first; nonce <= last; nonce++ {
+		if count == quitCheckInterval {
+			hashes.Add(count)
+			count = 0
+
+			select {
+			case <-ctx.Done():
+				return 0, false
+			default:
+			}
+		}
+
+		binary.BigEndian.PutUint64(nonceBytes, nonce)
+		hash := sha256.Sum256(data)
+		count++
+
+		if intHash.SetBytes(hash[:]).Cmp(target) == -1 {
+			return nonce, true
+		}
+	}
+
+	return 0, false
 }
 
 // This was only for presenation purposes
@@ -110,9 +287,7 @@ func (block *Block) TestPOW(newDiff int) {
 	target := big.NewInt(1)
 	target.Lsh(target, uint(256-newDiff)) // left shift
 
-	pow := &ProofOfWork{target}
-
-	block.pow = pow
+	block.SetBits(BigToCompact(target))
 }
 
 // This was only for presentation purposes
@@ -143,4 +318,4 @@ func (block *Block) TestPrintMine() (int, [32]byte) {
 	fmt.Println()
 
 	return nonce, hash
-}
\ No newline at end of file
+}
@@ -0,0 +1,179 @@
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limits of the relay.
+const (
+	maxInvItems   = 500   // items in one announcement
+	seenCacheSize = 20000 // items the node remembers having seen, the oldest are forgotten first
+)
+
+// InvType is the kind of item an announcement is about.
+type InvType uint8
+
+const (
+	InvTransaction InvType = iota + 1
+	InvBlock
+)
+
+// InvItem names a block or transaction by its hash.
+type InvItem struct {
+	Type InvType
+	Hash []byte
+}
+
+func (item InvItem) key() string {
+	return string(append([]byte{byte(item.Type)}, item.Hash...))
+}
+
+// InvArg announces items the sender has, without their data.
+// Session	token the receiver handed the sender in its handshake
+type InvArg struct {
+	Items   []InvItem
+	Session []byte
+}
+
+// Wanted	items the receiver has not seen and wants the sender to send (the getdata of the announcement)
+type InvReply struct {
+	Wanted []InvItem
+}
+
+// Blocks and transactions are relayed in two steps, so the topology does not have to be a full mesh:
+// the node announces the hash to every peer with the Inv RPC, each peer answers with the items it has not seen,
+// and only those peers get the full data through ReceiveBlock or ReceiveTransaction.
+// A peer that accepts the data announces it to its own peers in turn.
+// Every node remembers what it has seen, so an item is only fetched once and announcements stop at nodes that have it.
+// An item is also remembered as requested once one peer is asked for it, so peers that announce it at the same time
+// do not all send it. If it does not arrive within broadcastTimeout, the next peer that announces it is asked instead.
+//
+// RPC that receives an announcement and answers with the items the node wants.
+// Only peers that completed a handshake with this node can announce items.
+func (node *Node) Inv(args InvArg, reply *InvReply) error {
+	if err := node.checkSession(args.Session); err != nil {
+		return err
+	}
+
+	if len(args.Items) > maxInvItems {
+		return fmt.Errorf("cannot announce more than %d items at once", maxInvItems)
+	}
+
+	for _, item := range args.Items {
+		if !node.hasItem(item) && node.seen.Request(item, broadcastTimeout) {
+			reply.Wanted = append(reply.Wanted, item)
+		}
+	}
+
+	return nil
+}
+
+// Checks if the block is in the local chain, or the transaction in the mempool or the local chain.
+func (node *Node) hasItem(item InvItem) bool {
+	switch item.Type {
+	case InvBlock:
+		return node.LocalChain.GetBlock(item.Hash) != nil
+	case InvTransaction:
+		if node.Mempool.Has(item.Hash) {
+			return true
+		}
+		transaction, _ := node.LocalChain.GetTransaction(item.Hash)
+		return transaction != nil
+	}
+
+	// unknown types are never wanted
+	return true
+}
+
+// Announces the item to the peer. Returns whether the peer wants it.
+func (node *Node) announce(peer ServerConnection, item InvItem) (bool, error) {
+	var reply InvReply
+	if err := callWithTimeout(peer.rpcConnection, "Node.Inv", InvArg{Items: []InvItem{item}, Session: peer.session}, &reply, broadcastTimeout); err != nil {
+		return false, err
+	}
+
+	return len(reply.Wanted) > 0, nil
+}
+
+// Items the node has seen (created, received or announced), so it neither fetches nor relays them twice.
+// Holds at most size items, the oldest are forgotten first.
+// requested	items a peer was asked to send that have not been seen yet, with the time the request expires
+type seenCache struct {
+	items     map[string]struct{}
+	order     []string // ring of the keys in the order they were added
+	next      int      // position in order that the next key is written to
+	requested map[string]time.Time
+
+	mutex sync.Mutex
+}
+
+func newSeenCache(size int) *seenCache {
+	return &seenCache{
+		items:     make(map[string]struct{}, size),
+		order:     make([]string, size),
+		requested: make(map[string]time.Time),
+	}
+}
+
+// Add marks the item as seen. Returns false if it was seen before.
+func (cache *seenCache) Add(item InvItem) bool {
+	key := item.key()
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if _, seen := cache.items[key]; seen {
+		return false
+	}
+	delete(cache.requested, key)
+
+	if oldest := cache.order[cache.next]; oldest != "" {
+		delete(cache.items, oldest)
+	}
+
+	cache.order[cache.next] = key
+	cache.next = (cache.next + 1) % len(cache.order)
+	cache.items[key] = struct{}{}
+
+	return true
+}
+
+// Has checks if the item was seen.
+func (cache *seenCache) Has(item InvItem) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	_, seen := cache.items[item.key()]
+
+	return seen
+}
+
+// Request marks the item as requested for timeout, unless it was seen or an earlier request has not expired yet.
+// Returns whether the caller should ask for the item.
+func (cache *seenCache) Request(item InvItem, timeout time.Duration) bool {
+	key := item.key()
+	now := time.Now()
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if _, seen := cache.items[key]; seen {
+		return false
+	}
+	if expires, ok := cache.requested[key]; ok && now.Before(expires) {
+		return false
+	}
+
+	// forgets the requests that expired, so items that never arrive do not pile up
+	for requested, expires := range cache.requested {
+		if !now.Before(expires) {
+			delete(cache.requested, requested)
+		}
+	}
+
+	cache.requested[key] = now.Add(timeout)
+
+	return true
+}
@@ -0,0 +1,55 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+)
+
+// Peers that announce the same item at the same time are not all asked to send it.
+func TestInvRequestsOnce(t *testing.T) {
+	node := newTestNode(t, 0)
+	transaction := newTestTransaction(t, "announced twice")
+	hash, _ := transaction.CalculateHash()
+	item := InvItem{Type: InvTransaction, Hash: hash}
+	session := openTestSession(t, node)
+
+	var first, second InvReply
+	if err := node.Inv(InvArg{Items: []InvItem{item}, Session: session}, &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Inv(InvArg{Items: []InvItem{item}, Session: session}, &second); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first.Wanted) != 1 || len(second.Wanted) != 0 {
+		t.Fatalf("wanted by the first announcement %d times and by the second %d times, want 1 and 0", len(first.Wanted), len(second.Wanted))
+	}
+
+	// being requested is not being seen, the item is still relayed once it arrives
+	if report := node.SendTransaction(transaction); report == nil {
+		t.Fatal("requested transaction was not relayed")
+	}
+	if node.SendTransaction(transaction) != nil {
+		t.Fatal("transaction was relayed twice")
+	}
+}
+
+func TestSeenCacheRequest(t *testing.T) {
+	cache := newSeenCache(4)
+	item := InvItem{Type: InvBlock, Hash: []byte{1}}
+	other := InvItem{Type: InvBlock, Hash: []byte{2}}
+
+	if !cache.Request(item, time.Hour) || cache.Request(item, time.Hour) {
+		t.Fatal("item was requested again before the request expired")
+	}
+	if !cache.Request(other, -time.Second) || !cache.Request(other, time.Hour) {
+		t.Fatal("item was not requested again after the request expired")
+	}
+
+	if !cache.Add(item) {
+		t.Fatal("requested item was already seen")
+	}
+	if cache.Request(item, time.Hour) {
+		t.Fatal("seen item was requested")
+	}
+}
@@ -0,0 +1,248 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Creates a node with its own in-memory chain and an identity key.
+func newTestNode(t *testing.T, id int) *Node {
+	chain, err := NewBlockChain(DefaultGenesis(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := MakeNode(id)
+	node.Key = key
+	node.SetLocalChain(chain)
+
+	return node
+}
+
+// Serves the RPCs of the node over HTTP, the way ConnectNodes does, and returns a client dialled with rpc.DialHTTP.
+func dialTestNode(t *testing.T, node *Node) *rpc.Client {
+	return dialTestReceiver(t, node)
+}
+
+// Serves the methods of the receiver as the RPCs of a node ("Node.*"), so tests can stand in for a node.
+func dialTestReceiver(t *testing.T, receiver any) *rpc.Client {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Node", receiver); err != nil {
+		t.Fatal(err)
+	}
+
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	client, err := rpc.DialHTTP("tcp", strings.TrimPrefix(httpServer.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// Completes a handshake of the sender with the node behind the client and returns the session the node handed out.
+func handshakeTestNode(t *testing.T, sender *Node, client *rpc.Client) []byte {
+	reply, err := sender.handshake(&ServerConnection{serverID: -1, rpcConnection: client})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return reply.Session
+}
+
+// Opens a session with the node for a caller that did not go through a handshake, for tests that call its RPCs directly.
+func openTestSession(t *testing.T, node *Node) []byte {
+	session, err := node.sessions.Open("localhost:1", "test caller")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return session
+}
+
+// Signs a transaction to a fresh address.
+func newTestTransaction(t *testing.T, data string) Transaction {
+	_, key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transaction, err := MakeSignedTransaction(key, AddressFromPublicKey(recipient), time.Now().UnixNano(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return *transaction
+}
+
+// Mines a block with the given number of new transactions on top of the root of the node and adds it to its chain.
+func mineTestBlock(t *testing.T, node *Node, transactions int) *Block {
+	for i := 0; i < transactions; i++ {
+		if _, err := node.Mempool.Add(newTestTransaction(t, fmt.Sprintf("transaction %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	block := node.BuildBlockTemplate()
+	if _, err := block.MineParallel(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.LocalChain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	node.Mempool.RemoveMined()
+
+	return block
+}
+
+// Checks that a block that went through an RPC is the block that was sent, hash included.
+func checkBlock(t *testing.T, got *Block, want *Block) {
+	t.Helper()
+
+	if !reflect.DeepEqual(got.GetHeader(), want.GetHeader()) {
+		t.Fatalf("header is\n%+v\nwant\n%+v", got.GetHeader(), want.GetHeader())
+	}
+	if !reflect.DeepEqual(got.GetTransactions(), want.GetTransactions()) {
+		t.Fatalf("transactions are\n%+v\nwant\n%+v", got.GetTransactions(), want.GetTransactions())
+	}
+}
+
+func TestRPCGetHeadersAndBlocks(t *testing.T) {
+	node := newTestNode(t, 0)
+	var mined []*Block
+	for i := 0; i < 3; i++ {
+		mined = append(mined, mineTestBlock(t, node, 2))
+	}
+
+	client := dialTestNode(t, node)
+
+	var headers GetHeadersReply
+	args := GetHeadersArg{Locator: [][]byte{node.LocalChain.GetGenesis().GetHash()}}
+	if err := client.Call("Node.GetHeaders", args, &headers); err != nil {
+		t.Fatal(err)
+	}
+
+	if headers.BestHeight != 3 || headers.BestWork.Cmp(node.LocalChain.GetTotalWork()) != 0 {
+		t.Fatalf("best height %d and work %v, want 3 and %v", headers.BestHeight, headers.BestWork, node.LocalChain.GetTotalWork())
+	}
+
+	decoded, err := decodeHeaderChain(headers.Headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(mined) {
+		t.Fatalf("got %d headers, want %d", len(decoded), len(mined))
+	}
+
+	var hashes [][]byte
+	for i, header := range decoded {
+		if !reflect.DeepEqual(header, mined[i].GetHeader()) {
+			t.Fatalf("header %d is\n%+v\nwant\n%+v", i, header, mined[i].GetHeader())
+		}
+		hashes = append(hashes, header.hash)
+	}
+
+	var blocks GetBlocksReply
+	if err := client.Call("Node.GetBlocks", GetBlocksArg{Hashes: hashes}, &blocks); err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks.Blocks) != len(mined) {
+		t.Fatalf("got %d blocks, want %d", len(blocks.Blocks), len(mined))
+	}
+
+	for i, data := range blocks.Blocks {
+		block, err := DecodeBlock(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkBlock(t, block, mined[i])
+	}
+}
+
+func TestRPCReceiveBlock(t *testing.T) {
+	miner := newTestNode(t, 0)
+	receiver := newTestNode(t, 1)
+	block := mineTestBlock(t, miner, 3)
+
+	client := dialTestNode(t, receiver)
+
+	args := BlockArg{
+		Header:       makeHeaderArg(block.GetHeader()),
+		Hash:         block.GetHash(),
+		Transactions: block.GetTransactions(),
+		Session:      handshakeTestNode(t, miner, client),
+	}
+
+	var reply BlockReply
+	if err := client.Call("Node.ReceiveBlock", args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !reply.Success {
+		t.Fatal("block was not accepted")
+	}
+
+	received := receiver.LocalChain.GetBlock(block.GetHash())
+	if received == nil {
+		t.Fatal("block is not in the chain of the receiver")
+	}
+	checkBlock(t, received, block)
+
+	if !reflect.DeepEqual(receiver.LocalChain.GetRoot().GetHash(), block.GetHash()) {
+		t.Fatal("block is not the root of the chain of the receiver")
+	}
+}
+
+func TestRPCReceiveTransaction(t *testing.T) {
+	node := newTestNode(t, 0)
+	client := dialTestNode(t, node)
+	session := handshakeTestNode(t, newTestNode(t, 1), client)
+
+	transaction := newTestTransaction(t, "sent over RPC")
+	hash, err := transaction.CalculateHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reply TransactionReply
+	if err := client.Call("Node.ReceiveTransaction", TransactionArg{Transaction: transaction, Session: session}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !reply.Success {
+		t.Fatal("transaction was not accepted")
+	}
+
+	received, ok := node.Mempool.Get(hash)
+	if !ok {
+		t.Fatal("transaction is not in the mempool")
+	}
+	if !reflect.DeepEqual(received, transaction) {
+		t.Fatalf("transaction is\n%+v\nwant\n%+v", received, transaction)
+	}
+
+	// the same transaction again is a duplicate
+	var duplicate TransactionReply
+	if err := client.Call("Node.ReceiveTransaction", TransactionArg{Transaction: transaction, Session: session}, &duplicate); err != nil {
+		t.Fatal(err)
+	}
+	if duplicate.Success {
+		t.Fatal("duplicate transaction was accepted")
+	}
+}
@@ -0,0 +1,274 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Blocks are written to segment files named blocks-000000.dat, blocks-000001.dat, ...
+// A new segment is started once the current one grows past maxSegmentSize.
+const (
+	segmentPrefix  = "blocks-"
+	segmentSuffix  = ".dat"
+	maxSegmentSize = 64 << 20
+
+	recordHeaderSize = 8       // 4 bytes length + 4 bytes CRC32 checksum
+	maxRecordSize    = 1 << 24 // no single block can be bigger than this
+)
+
+// Returned by readSegment when the last record of the segment was not completely written
+// (the node stopped while appending it). Any other error means the segment is corrupted.
+var errTornRecord = errors.New("last record is incomplete")
+
+var ErrStoreClosed = errors.New("block store is closed")
+
+// BlockStore persists the blocks accepted by a BlockChain in append-only segment files.
+// Every record in a segment is a 4 byte length, a 4 byte CRC32 checksum and the canonical encoding of the block.
+// dir				directory that holds the segment files
+// segment			segment file that new blocks are appended to
+// segmentIndex		index of the segment that is currently appended to
+// segmentSize		size of the current segment in bytes
+// segmentLimit		size past which a new segment is started (maxSegmentSize, tests make it smaller)
+// broken			error that left the end of the segment in an unknown state, every Append after it fails with it
+type BlockStore struct {
+	dir          string
+	segment      *os.File
+	segmentIndex int
+	segmentSize  int64
+	segmentLimit int64
+	broken       error
+
+	mutex sync.Mutex
+}
+
+// OpenBlockStore opens (or creates) the block store in the given directory.
+// New blocks are appended to the last segment file.
+func OpenBlockStore(dir string) (*BlockStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	store := &BlockStore{dir: dir, segmentLimit: maxSegmentSize}
+
+	segments, err := store.segmentIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) > 0 {
+		store.segmentIndex = segments[len(segments)-1]
+	}
+
+	if err := store.openSegment(store.segmentIndex); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Path of the segment file with the given index.
+func (store *BlockStore) segmentPath(index int) string {
+	return filepath.Join(store.dir, fmt.Sprintf("%s%06d%s", segmentPrefix, index, segmentSuffix))
+}
+
+// Returns the indexes of all segment files in the store directory in ascending order.
+func (store *BlockStore) segmentIndexes() ([]int, error) {
+	matches, err := filepath.Glob(filepath.Join(store.dir, segmentPrefix+"*"+segmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []int
+	for _, match := range matches {
+		var index int
+		if _, err := fmt.Sscanf(filepath.Base(match), segmentPrefix+"%06d"+segmentSuffix, &index); err != nil {
+			continue // not one of our segment files
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	return indexes, nil
+}
+
+// Opens the segment file with the given index for appending.
+func (store *BlockStore) openSegment(index int) error {
+	file, err := os.OpenFile(store.segmentPath(index), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if store.segment != nil {
+		store.segment.Close()
+	}
+
+	store.segment = file
+	store.segmentIndex = index
+	store.segmentSize = info.Size()
+
+	return nil
+}
+
+// Append writes the block to the end of the current segment and syncs it to disk.
+// Starts a new segment if the current one is full.
+// A failed write is cut off again, so the next block does not follow half a record.
+// If that fails too, or the sync fails, the store is broken: what is on disk is unknown and every later Append fails.
+func (store *BlockStore) Append(block *Block) error {
+	payload, err := block.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.segment == nil {
+		return ErrStoreClosed
+	}
+	if store.broken != nil {
+		return fmt.Errorf("block store is broken: %w", store.broken)
+	}
+
+	if store.segmentSize > 0 && store.segmentSize+int64(len(record)) > store.segmentLimit {
+		if err := store.openSegment(store.segmentIndex + 1); err != nil {
+			return err
+		}
+	}
+
+	if _, err := store.segment.Write(record); err != nil {
+		if truncateErr := store.segment.Truncate(store.segmentSize); truncateErr != nil {
+			store.broken = truncateErr
+			logError("Block store: could not cut off a failed write to segment %d: %v", store.segmentIndex, truncateErr)
+		}
+		return err
+	}
+	store.segmentSize += int64(len(record))
+
+	if err := store.segment.Sync(); err != nil {
+		store.broken = err
+		return err
+	}
+
+	return nil
+}
+
+// LoadBlocks reads every block in the store, in the order they were appended.
+// A partially written record at the end of the last segment (e.g. the node crashed while writing)
+// is cut off so that new blocks are appended after the last complete one.
+// A broken record anywhere else is an error, so valid blocks after it are never thrown away.
+func (store *BlockStore) LoadBlocks() ([]*Block, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.segment == nil {
+		return nil, ErrStoreClosed
+	}
+
+	segments, err := store.segmentIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []*Block
+	for i, index := range segments {
+		segmentBlocks, validSize, err := readSegment(store.segmentPath(index))
+
+		if err != nil {
+			if !errors.Is(err, errTornRecord) || i != len(segments)-1 || index != store.segmentIndex {
+				return nil, fmt.Errorf("segment %d is corrupted: %v", index, err)
+			}
+
+			logWarn("Block store: dropping incomplete record at the end of segment %d: %v", index, err)
+			if err := store.segment.Truncate(validSize); err != nil {
+				return nil, err
+			}
+			store.segmentSize = validSize
+		}
+
+		blocks = append(blocks, segmentBlocks...)
+	}
+
+	return blocks, nil
+}
+
+// Reads all complete records from a segment file.
+// Returns the blocks, the number of bytes that were read successfully and the error that stopped the read (if any).
+// A record that is cut short by the end of the file, or whose checksum fails and that is the last one in the file,
+// is reported as errTornRecord.
+func readSegment(path string) ([]*Block, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var blocks []*Block
+	offset := int64(0)
+
+	for offset < int64(len(data)) {
+		if int64(len(data))-offset < recordHeaderSize {
+			return blocks, offset, fmt.Errorf("%w: %v", errTornRecord, io.ErrUnexpectedEOF)
+		}
+
+		size := int64(binary.BigEndian.Uint32(data[offset : offset+4]))
+		checksum := binary.BigEndian.Uint32(data[offset+4 : offset+8])
+
+		if size > maxRecordSize {
+			return blocks, offset, errors.New("record is too large")
+		}
+		if int64(len(data))-offset-recordHeaderSize < size {
+			return blocks, offset, fmt.Errorf("%w: %v", errTornRecord, io.ErrUnexpectedEOF)
+		}
+
+		end := offset + recordHeaderSize + size
+		payload := data[offset+recordHeaderSize : end]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			if end == int64(len(data)) {
+				return blocks, offset, fmt.Errorf("%w: checksum mismatch", errTornRecord)
+			}
+			return blocks, offset, fmt.Errorf("checksum mismatch in the record at offset %d", offset)
+		}
+
+		block, err := DecodeBlock(payload)
+		if err != nil {
+			return blocks, offset, err
+		}
+
+		blocks = append(blocks, block)
+		offset = end
+	}
+
+	return blocks, offset, nil
+}
+
+// Close closes the current segment file.
+func (store *BlockStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.segment == nil {
+		return nil
+	}
+
+	err := store.segment.Close()
+	store.segment = nil
+
+	return err
+}
@@ -0,0 +1,252 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Mines the given number of blocks on a test node and returns them, genesis first.
+func testStoreBlocks(t *testing.T, count int) []*Block {
+	node := newTestNode(t, 0)
+	blocks := []*Block{node.LocalChain.GetGenesis()}
+	for i := 0; i < count; i++ {
+		blocks = append(blocks, mineTestBlock(t, node, 1))
+	}
+
+	return blocks
+}
+
+// Opens the store in dir and fails the test if that does not work. The store is closed when the test ends.
+func openTestStore(t *testing.T, dir string) *BlockStore {
+	store, err := OpenBlockStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func appendTestBlocks(t *testing.T, store *BlockStore, blocks []*Block) {
+	for _, block := range blocks {
+		if err := store.Append(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Checks that the loaded blocks are the given ones, in the same order.
+// The hash covers the header, and with it the merkle root of the transactions.
+func checkLoadedBlocks(t *testing.T, loaded []*Block, want []*Block) {
+	t.Helper()
+
+	if len(loaded) != len(want) {
+		t.Fatalf("loaded %d blocks, want %d", len(loaded), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(loaded[i].GetHash(), want[i].GetHash()) {
+			t.Fatalf("block %d has hash %x, want %x", i, loaded[i].GetHash(), want[i].GetHash())
+		}
+		if len(loaded[i].GetTransactions()) != len(want[i].GetTransactions()) {
+			t.Fatalf("block %d has %d transactions, want %d", i, len(loaded[i].GetTransactions()), len(want[i].GetTransactions()))
+		}
+	}
+}
+
+func TestBlockStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	blocks := testStoreBlocks(t, 3)
+
+	store := openTestStore(t, dir)
+	appendTestBlocks(t, store, blocks)
+	store.Close()
+
+	loaded, err := openTestStore(t, dir).LoadBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLoadedBlocks(t, loaded, blocks)
+}
+
+// A record that was only partly written is cut off, and the next block is appended where it started.
+func TestBlockStoreTornRecord(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(data []byte) []byte
+		want   int // blocks that are still loaded
+	}{
+		{"partial header", func(data []byte) []byte { return append(data, 0, 0, 1) }, 4},
+		{"partial payload", func(data []byte) []byte { return data[:len(data)-10] }, 3},
+		{"checksum of the last record", func(data []byte) []byte {
+			data[len(data)-1] ^= 0xff
+			return data
+		}, 3},
+	}
+
+	blocks := testStoreBlocks(t, 3)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := openTestStore(t, dir)
+			appendTestBlocks(t, store, blocks)
+			store.Close()
+
+			path := store.segmentPath(0)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, test.damage(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			store = openTestStore(t, dir)
+			loaded, err := store.LoadBlocks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkLoadedBlocks(t, loaded, blocks[:test.want])
+
+			// a block appended now follows the last complete record
+			appendTestBlocks(t, store, blocks[:1])
+			store.Close()
+
+			loaded, err = openTestStore(t, dir).LoadBlocks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkLoadedBlocks(t, loaded, append(append([]*Block{}, blocks[:test.want]...), blocks[0]))
+		})
+	}
+}
+
+// A broken record that is followed by other records is not a torn write, the store refuses to load.
+func TestBlockStoreCorruption(t *testing.T) {
+	dir := t.TempDir()
+	blocks := testStoreBlocks(t, 3)
+
+	store := openTestStore(t, dir)
+	appendTestBlocks(t, store, blocks)
+	store.Close()
+
+	path := store.segmentPath(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[recordHeaderSize+5] ^= 0xff // payload of the first record
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := openTestStore(t, dir).LoadBlocks(); err == nil {
+		t.Fatal("corrupted segment was loaded")
+	}
+
+	// the segment is left as it was for inspection
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, data) {
+		t.Fatal("corrupted segment was changed")
+	}
+}
+
+// Blocks go to a new segment once the current one is full, and are loaded across segments in order.
+// A torn record at the end of a segment that is not the last one is corruption.
+func TestBlockStoreSegmentRollover(t *testing.T) {
+	dir := t.TempDir()
+	blocks := testStoreBlocks(t, 4)
+
+	store := openTestStore(t, dir)
+	store.segmentLimit = 1 // every block gets a segment of its own
+	appendTestBlocks(t, store, blocks)
+	store.Close()
+
+	store = openTestStore(t, dir)
+	indexes, err := store.segmentIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != len(blocks) {
+		t.Fatalf("blocks were written to %d segments, want %d", len(indexes), len(blocks))
+	}
+	if store.segmentIndex != len(blocks)-1 {
+		t.Fatalf("store appends to segment %d, want the last one %d", store.segmentIndex, len(blocks)-1)
+	}
+
+	loaded, err := store.LoadBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLoadedBlocks(t, loaded, blocks)
+	store.Close()
+
+	path := store.segmentPath(1)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)-10], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := openTestStore(t, dir).LoadBlocks(); err == nil {
+		t.Fatal("segment with a torn record before the last segment was loaded")
+	}
+}
+
+func TestBlockStoreAppendAfterClose(t *testing.T) {
+	store := openTestStore(t, t.TempDir())
+	store.Close()
+
+	if err := store.Append(testStoreBlocks(t, 0)[0]); !errors.Is(err, ErrStoreClosed) {
+		t.Fatalf("err = %v, want ErrStoreClosed", err)
+	}
+	if _, err := store.LoadBlocks(); !errors.Is(err, ErrStoreClosed) {
+		t.Fatalf("err = %v, want ErrStoreClosed", err)
+	}
+}
+
+// A write that fails and cannot be cut off breaks the store, so no block is appended after an unknown tail.
+func TestBlockStoreBroken(t *testing.T) {
+	dir := t.TempDir()
+	blocks := testStoreBlocks(t, 2)
+
+	store := openTestStore(t, dir)
+	appendTestBlocks(t, store, blocks[:2])
+
+	// a read-only handle fails both the write and the truncate
+	readOnly, err := os.Open(store.segmentPath(store.segmentIndex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.segment.Close()
+	store.segment = readOnly
+
+	if err := store.Append(blocks[2]); err == nil {
+		t.Fatal("write to a read-only segment did not fail")
+	}
+	if store.broken == nil {
+		t.Fatal("store is not broken after a write that could not be cut off")
+	}
+
+	if err := store.openSegment(store.segmentIndex); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append(blocks[2]); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("err = %v, want the store to stay broken", err)
+	}
+	store.Close()
+
+	loaded, err := openTestStore(t, dir).LoadBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLoadedBlocks(t, loaded, blocks[:2])
+}
@@ -0,0 +1,407 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits of a single GetHeaders / GetBlocks call.
+const (
+	maxHeadersPerCall = 500
+	maxBlocksPerCall  = 16
+	syncCallTimeout   = 10 * time.Second // time a peer gets to answer one GetHeaders or GetBlocks call
+	maxSyncRounds     = 1000             // header batches of one Sync call, so no peer can keep it going forever
+	minTriggerPeriod  = 5 * time.Second  // time between two syncs started by blocks with an unknown parent
+)
+
+// Misbehaviour of a peer during sync. The peer is banned for it.
+var (
+	errFalseWork   = errors.New("peer claimed more work than the headers it sent")
+	errNoNewBlocks = errors.New("peer claimed more work but sent no new blocks")
+)
+
+// GetHeadersArg asks a peer for the headers of its canonical chain that we are missing.
+// Locator		hashes of our canonical chain from the root back to genesis (see GetLocator)
+type GetHeadersArg struct {
+	Locator [][]byte
+}
+
+// Headers		canonical encodings of the headers after the fork point, in chain order
+// BestHeight	height of the root of the peer
+// BestWork		cumulative proof of work of the canonical chain of the peer
+type GetHeadersReply struct {
+	Headers    [][]byte
+	BestHeight int
+	BestWork   *big.Int
+}
+
+// GetBlocksArg asks a peer for full blocks by hash.
+type GetBlocksArg struct {
+	Hashes [][]byte
+}
+
+// Blocks	canonical encodings of the requested blocks that the peer knows, in the requested order
+type GetBlocksReply struct {
+	Blocks [][]byte
+}
+
+// GetLocator returns hashes of the canonical chain, starting at the root and going back to genesis.
+// The first 10 hashes are consecutive, after that the step doubles every time,
+// so a peer can find where our chains fork with only a few hashes.
+func (blockChain *BlockChain) GetLocator() [][]byte {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	var locator [][]byte
+
+	step := 1
+	for height := blockChain.tip.height; height > 0; height -= step {
+		locator = append(locator, blockChain.blockByHeight(height).GetHash())
+
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+
+	return append(locator, blockChain.genesis.GetHash())
+}
+
+// GetBlocksAfter returns up to limit canonical blocks that follow the first locator hash that is on our canonical chain.
+// If no locator hash is on our canonical chain, the blocks right after genesis are returned.
+func (blockChain *BlockChain) GetBlocksAfter(locator [][]byte, limit int) []*Block {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	return blockChain.blocksAfter(locator, limit)
+}
+
+// Returns the encoded headers GetHeaders answers the locator with, and the height and work of the canonical chain,
+// all read under the same lock so the work always belongs to the chain the headers come from.
+func (blockChain *BlockChain) headersAfter(locator [][]byte, limit int) ([][]byte, int, *big.Int) {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	var headers [][]byte
+	for _, block := range blockChain.blocksAfter(locator, limit) {
+		headers = append(headers, block.header.encode())
+	}
+
+	return headers, blockChain.tip.height, new(big.Int).Set(blockChain.tip.work)
+}
+
+// See GetBlocksAfter. The caller holds the lock.
+func (blockChain *BlockChain) blocksAfter(locator [][]byte, limit int) []*Block {
+	start := 1
+
+	for _, hash := range locator {
+		if blockChain.isCanonical(hash) {
+			start = blockChain.blocks[string(hash)].height + 1
+			break
+		}
+	}
+
+	var blocks []*Block
+	for height := start; height <= blockChain.tip.height && len(blocks) < limit; height++ {
+		blocks = append(blocks, blockChain.blockByHeight(height))
+	}
+
+	return blocks
+}
+
+// RPC that returns the headers of our canonical chain that follow the locator of the caller,
+// together with the height and work of our chain so the caller can pick the best peer.
+func (node *Node) GetHeaders(args GetHeadersArg, reply *GetHeadersReply) error {
+	reply.Headers, reply.BestHeight, reply.BestWork = node.LocalChain.headersAfter(args.Locator, maxHeadersPerCall)
+
+	return nil
+}
+
+// RPC that returns the blocks with the given hashes. Unknown hashes are skipped.
+func (node *Node) GetBlocks(args GetBlocksArg, reply *GetBlocksReply) error {
+	if len(args.Hashes) > maxBlocksPerCall {
+		return fmt.Errorf("cannot request more than %d blocks at once", maxBlocksPerCall)
+	}
+
+	for _, hash := range args.Hashes {
+		block := node.LocalChain.GetBlock(hash)
+		if block == nil {
+			continue
+		}
+
+		data, err := block.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		reply.Blocks = append(reply.Blocks, data)
+	}
+
+	return nil
+}
+
+// SyncManager downloads the blocks a node is missing from its peers (initial block download).
+// Asks every peer for the headers after our locator, picks the peer with the most work,
+// checks the headers and then downloads, validates and adds the blocks in batches.
+// Repeats until no peer has more work than we do.
+type SyncManager struct {
+	node *Node
+
+	mutex     sync.Mutex   // only one sync runs at a time
+	triggered atomic.Int64 // time in Unix nanoseconds of the last sync Trigger allowed
+}
+
+func NewSyncManager(node *Node) *SyncManager {
+	return &SyncManager{node: node}
+}
+
+// Trigger returns whether a block with an unknown parent may start a sync.
+// Peers can send such blocks in bursts (or on purpose), so at most one sync is started every minTriggerPeriod.
+// A block that is refused one is picked up by the sync that is already running or by the next one.
+func (manager *SyncManager) Trigger() bool {
+	now := time.Now().UnixNano()
+	last := manager.triggered.Load()
+
+	if now-last < int64(minTriggerPeriod) {
+		return false
+	}
+
+	return manager.triggered.CompareAndSwap(last, now)
+}
+
+// Result of asking one peer for headers.
+// complete is set if the peer sent fewer headers than it could, so they go all the way to its root.
+type peerHeaders struct {
+	peer     ServerConnection
+	headers  []BlockHeader
+	work     *big.Int
+	height   int
+	complete bool
+}
+
+// Sync catches the local chain up to the best chain of the connected peers.
+// Returns once no connected peer has more work than the local chain, or after maxSyncRounds batches of headers.
+// If every block of a batch is already known (it is on one of our side branches), the next batch is asked for
+// after the last header of this one, since our own locator would get the same headers again.
+// A peer whose last batch adds nothing is banned: the work it claimed does not exist.
+func (manager *SyncManager) Sync() error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	var locator [][]byte
+	for round := 0; ; round++ {
+		if round == maxSyncRounds {
+			return fmt.Errorf("sync stopped after %d rounds", maxSyncRounds)
+		}
+
+		if locator == nil {
+			locator = manager.node.LocalChain.GetLocator()
+		}
+
+		best := manager.bestPeer(locator)
+		if best == nil {
+			return nil
+		}
+
+		logInfo(">>> Syncing %d headers from %s (height %d)", len(best.headers), best.peer.address, best.height)
+
+		added, err := manager.downloadBlocks(best)
+		if err != nil {
+			return fmt.Errorf("sync from %s failed: %w", best.peer.address, err)
+		}
+
+		locator = nil
+		if added == 0 {
+			if best.complete {
+				manager.node.BanPeer(best.peer.address, errNoNewBlocks)
+				continue
+			}
+
+			last := best.headers[len(best.headers)-1].hash
+			locator = append([][]byte{last}, manager.node.LocalChain.GetLocator()...)
+		}
+	}
+}
+
+// Asks every connected peer for headers at the same time and returns the one with the most work,
+// or nil if no peer has more work than the local chain.
+// The claimed work of a peer is checked against the work of the headers it sent: if they reach its root,
+// the work of the headers counts instead of the claim, and a peer that claimed more than its headers have is banned.
+// A peer answers with its headers and its work from one state of its chain, so an honest peer never claims more.
+func (manager *SyncManager) bestPeer(locator [][]byte) *peerHeaders {
+	chain := manager.node.LocalChain
+	ourWork := chain.GetTotalWork()
+	args := GetHeadersArg{Locator: locator}
+
+	peers := manager.node.connectedPeers()
+	replies := make([]*GetHeadersReply, len(peers))
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer ServerConnection) {
+			defer wg.Done()
+
+			reply := new(GetHeadersReply)
+			if err := callWithTimeout(peer.rpcConnection, "Node.GetHeaders", args, reply, syncCallTimeout); err != nil {
+				// after a timeout the call may still be writing to reply
+				logWarn("Response >>> could not get headers from %s: %v", peer.address, err)
+				return
+			}
+			replies[i] = reply
+		}(i, peer)
+	}
+	wg.Wait()
+
+	var best *peerHeaders
+	for i, reply := range replies {
+		peer := peers[i]
+		if reply == nil || reply.BestWork == nil || reply.BestWork.Cmp(ourWork) <= 0 || len(reply.Headers) == 0 {
+			continue
+		}
+
+		headers, err := decodeHeaderChain(reply.Headers)
+		if err != nil {
+			logWarn("Response >>> invalid headers from %s: %v", peer.address, err)
+			manager.node.BanPeer(peer.address, err)
+			continue
+		}
+
+		work := chain.getWork(headers[0].parentBlockHash)
+		if work == nil {
+			logWarn("Response >>> headers from %s do not follow a known block", peer.address)
+			continue
+		}
+		for j := range headers {
+			work.Add(work, headerWork(&headers[j]))
+		}
+
+		candidate := &peerHeaders{peer: peer, headers: headers, work: reply.BestWork, height: reply.BestHeight}
+
+		if len(reply.Headers) < maxHeadersPerCall {
+			candidate.complete = true
+			candidate.work = work
+
+			if work.Cmp(reply.BestWork) < 0 {
+				logWarn("Response >>> %s claimed work its headers do not have", peer.address)
+				manager.node.BanPeer(peer.address, errFalseWork)
+				continue
+			}
+		}
+
+		if best != nil && candidate.work.Cmp(best.work) <= 0 {
+			continue
+		}
+
+		best = candidate
+	}
+
+	return best
+}
+
+// Returns the cumulative work of a known block, or nil if the block is unknown.
+func (blockChain *BlockChain) getWork(hash []byte) *big.Int {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	node, ok := blockChain.blocks[string(hash)]
+	if !ok {
+		return nil
+	}
+
+	return new(big.Int).Set(node.work)
+}
+
+// Decodes headers and checks that they link to each other and meet the proof of work target in their bits.
+// Whether the bits follow the retarget rules is checked when the blocks are added.
+func decodeHeaderChain(encoded [][]byte) ([]BlockHeader, error) {
+	headers := make([]BlockHeader, 0, len(encoded))
+
+	for i, data := range encoded {
+		var header BlockHeader
+		if err := header.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+
+		if i > 0 && !bytes.Equal(header.parentBlockHash, headers[i-1].hash) {
+			return nil, fmt.Errorf("header %d does not link to the previous header", i)
+		}
+
+		target := CompactToBig(header.bits)
+		if target.Cmp(powLimit) > 0 {
+			return nil, fmt.Errorf("header %d has a target above the limit", i)
+		}
+
+		if new(big.Int).SetBytes(header.hash).Cmp(target) != -1 {
+			return nil, fmt.Errorf("header %d is not below the target", i)
+		}
+
+		headers = append(headers, header)
+	}
+
+	return headers, nil
+}
+
+// Downloads the blocks of the headers from the peer in batches and adds them to the local chain.
+// Returns how many blocks were added (0 without an error if all of them were known already).
+func (manager *SyncManager) downloadBlocks(best *peerHeaders) (int, error) {
+	chain := manager.node.LocalChain
+
+	if chain.GetBlock(best.headers[0].parentBlockHash) == nil {
+		return 0, ErrUnknownParent
+	}
+
+	added := 0
+	for start := 0; start < len(best.headers); start += maxBlocksPerCall {
+		end := start + maxBlocksPerCall
+		if end > len(best.headers) {
+			end = len(best.headers)
+		}
+
+		batch := best.headers[start:end]
+		args := GetBlocksArg{}
+		for _, header := range batch {
+			args.Hashes = append(args.Hashes, header.hash)
+		}
+
+		var reply GetBlocksReply
+		if err := callWithTimeout(best.peer.rpcConnection, "Node.GetBlocks", args, &reply, syncCallTimeout); err != nil {
+			return added, err
+		}
+
+		if len(reply.Blocks) != len(batch) {
+			return added, errors.New("peer did not send all requested blocks")
+		}
+
+		for i, data := range reply.Blocks {
+			block, err := DecodeBlock(data)
+			if err == nil && !bytes.Equal(block.GetHash(), batch[i].hash) {
+				err = errors.New("block does not match its header")
+			}
+			if err != nil {
+				manager.node.BanPeer(best.peer.address, err)
+				return added, err
+			}
+
+			err = chain.AddConsensusBlock(block, batch[i].hash)
+			var blockErr *BlockError
+			if errors.As(err, &blockErr) && !errors.Is(err, ErrUnknownParent) && !errors.Is(err, ErrTimestampTooNew) {
+				// the peer sent a block that breaks the consensus rules
+				// (a block from the future only means that the clocks differ, the sync from the peer just stops)
+				manager.node.BanPeer(best.peer.address, err)
+			}
+			if err != nil && !errors.Is(err, ErrBlockExists) {
+				return added, err
+			}
+
+			if err == nil {
+				added++
+			}
+		}
+	}
+
+	return added, nil
+}
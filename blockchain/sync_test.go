@@ -0,0 +1,228 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Connects the node to the peer through RPC, as if the handshake with it had succeeded.
+func connectTestPeer(t *testing.T, node *Node, address string, peer any) {
+	connection := ServerConnection{serverID: -1, address: address, rpcConnection: dialTestReceiver(t, peer)}
+	if !node.addConnectedPeer(connection) {
+		t.Fatalf("%s is already connected", address)
+	}
+}
+
+// Checks that both chains have the same root.
+func checkSameRoot(t *testing.T, got *BlockChain, want *BlockChain) {
+	t.Helper()
+
+	if got.GetHeight() != want.GetHeight() || !bytes.Equal(got.GetRoot().GetHash(), want.GetRoot().GetHash()) {
+		t.Fatalf("root is %x at height %d, want %x at height %d",
+			got.GetRoot().GetHash(), got.GetHeight(), want.GetRoot().GetHash(), want.GetHeight())
+	}
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		name  string
+		peer  int // blocks the peer mines
+		local int // blocks the syncing node mines on its own branch first
+	}{
+		{"already synced", 0, 0},
+		{"one batch", 3, 0},
+		{"several batches", 2*maxBlocksPerCall + 3, 0},
+		{"fork with less work", 4, 2},
+		{"node has more work", 1, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peer := newTestNode(t, 0)
+			for i := 0; i < test.peer; i++ {
+				mineTestBlock(t, peer, 1)
+			}
+
+			node := newTestNode(t, 1)
+			for i := 0; i < test.local; i++ {
+				mineTestBlock(t, node, 1)
+			}
+			own := node.LocalChain.GetRoot()
+
+			connectTestPeer(t, node, "peer", peer)
+			if err := node.syncManager.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.local >= test.peer {
+				if node.LocalChain.GetRoot() != own {
+					t.Fatal("node left its own chain, which has at least as much work")
+				}
+			} else {
+				checkSameRoot(t, node.LocalChain, peer.LocalChain)
+			}
+
+			if len(node.connectedPeers()) != 1 {
+				t.Fatal("honest peer was disconnected")
+			}
+			if checked, err := node.LocalChain.VerifyChain(); err != nil {
+				t.Fatalf("chain is invalid at block %d: %v", checked, err)
+			}
+		})
+	}
+}
+
+// Serves the chain of a node but claims more work than it has.
+type lyingNode struct {
+	node *Node
+}
+
+func (liar *lyingNode) GetHeaders(args GetHeadersArg, reply *GetHeadersReply) error {
+	if err := liar.node.GetHeaders(args, reply); err != nil {
+		return err
+	}
+
+	reply.BestWork = new(big.Int).Lsh(big.NewInt(1), 300)
+
+	return nil
+}
+
+func (liar *lyingNode) GetBlocks(args GetBlocksArg, reply *GetBlocksReply) error {
+	return liar.node.GetBlocks(args, reply)
+}
+
+// A peer whose headers reach its root but have less work than our chain is banned, and nothing is downloaded from it.
+func TestSyncFalseWork(t *testing.T) {
+	liar := newTestNode(t, 0)
+	mineTestBlock(t, liar, 1)
+
+	node := newTestNode(t, 1)
+	mineTestBlock(t, node, 1)
+	own := mineTestBlock(t, node, 1)
+
+	connectTestPeer(t, node, "liar", &lyingNode{node: liar})
+	if err := node.syncManager.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if node.LocalChain.GetRoot() != own {
+		t.Fatal("node left its own chain for the chain of the liar")
+	}
+	if node.LocalChain.GetBlock(liar.LocalChain.GetRoot().GetHash()) != nil {
+		t.Fatal("block of the liar was downloaded")
+	}
+	if len(node.connectedPeers()) != 0 {
+		t.Fatal("liar is still connected")
+	}
+}
+
+// Of two peers the one with more work is synced from.
+func TestSyncBestPeer(t *testing.T) {
+	short := newTestNode(t, 0)
+	mineTestBlock(t, short, 1)
+
+	long := newTestNode(t, 1)
+	for i := 0; i < 3; i++ {
+		mineTestBlock(t, long, 1)
+	}
+
+	node := newTestNode(t, 2)
+	connectTestPeer(t, node, "short", short)
+	connectTestPeer(t, node, "long", long)
+
+	if err := node.syncManager.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSameRoot(t, node.LocalChain, long.LocalChain)
+	if node.LocalChain.GetBlock(short.LocalChain.GetRoot().GetHash()) != nil {
+		t.Fatal("block of the peer with less work was downloaded")
+	}
+}
+
+// Serves a single block on top of genesis whose timestamp is ahead of the clock of the syncing node.
+type futureNode struct {
+	block *Block
+	work  *big.Int
+}
+
+func (future *futureNode) GetHeaders(args GetHeadersArg, reply *GetHeadersReply) error {
+	reply.Headers = [][]byte{future.block.header.encode()}
+	reply.BestHeight = 1
+	reply.BestWork = future.work
+
+	return nil
+}
+
+func (future *futureNode) GetBlocks(args GetBlocksArg, reply *GetBlocksReply) error {
+	data, err := future.block.MarshalBinary()
+	reply.Blocks = [][]byte{data}
+
+	return err
+}
+
+// A block from the future only means that the clocks of the nodes differ: the sync stops, but the peer is not banned.
+func TestSyncTimestampTooNew(t *testing.T) {
+	node := newTestNode(t, 0)
+	addPending(t, node, newTestTransaction(t, "from the future"))
+
+	block := node.BuildBlockTemplate()
+	block.header.timestamp += int64(2 * MaxFutureBlockTime)
+	if _, err := block.MineParallel(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	work := new(big.Int).Add(node.LocalChain.GetTotalWork(), blockWork(block))
+	connectTestPeer(t, node, "future", &futureNode{block: block, work: work})
+
+	if err := node.syncManager.Sync(); !errors.Is(err, ErrTimestampTooNew) {
+		t.Fatalf("err = %v, want %v", err, ErrTimestampTooNew)
+	}
+	if len(node.connectedPeers()) != 1 {
+		t.Fatal("peer was disconnected for a block from the future")
+	}
+}
+
+// Answers GetHeaders like node, after a delay.
+type slowNode struct {
+	node  *Node
+	delay time.Duration
+}
+
+func (slow *slowNode) GetHeaders(args GetHeadersArg, reply *GetHeadersReply) error {
+	time.Sleep(slow.delay)
+	return slow.node.GetHeaders(args, reply)
+}
+
+// Peers are asked for headers at the same time, so slow peers do not add up.
+func TestBestPeerParallel(t *testing.T) {
+	const peers = 4
+	const delay = 200 * time.Millisecond
+
+	node := newTestNode(t, 0)
+	for i := 0; i < peers; i++ {
+		peer := newTestNode(t, i+1)
+		for j := 0; j <= i; j++ {
+			mineTestBlock(t, peer, 1)
+		}
+		connectTestPeer(t, node, fmt.Sprintf("peer%d", i), &slowNode{node: peer, delay: delay})
+	}
+
+	start := time.Now()
+	best := node.syncManager.bestPeer(node.LocalChain.GetLocator())
+	if elapsed := time.Since(start); elapsed >= peers*delay/2 {
+		t.Fatalf("asking %d peers took %v, each answers after %v", peers, elapsed, delay)
+	}
+
+	if best == nil || best.peer.address != fmt.Sprintf("peer%d", peers-1) || len(best.headers) != peers {
+		t.Fatalf("best peer is %+v, want the one with %d blocks", best, peers)
+	}
+	if len(node.connectedPeers()) != peers {
+		t.Fatal("an honest peer was banned")
+	}
+}
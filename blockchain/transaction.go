@@ -1,28 +1,43 @@
 package blockchain
 
 import (
-	"bytes"
+	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"reflect"
 
 	"github.com/cbergoon/merkletree"
 )
 
+var (
+	ErrMissingSignature = errors.New("transaction is not signed")
+	ErrInvalidSignature = errors.New("transaction signature is invalid")
+	ErrSenderMismatch   = errors.New("transaction sender is not the address of its public key")
+	ErrInvalidRecipient = errors.New("transaction recipient is not a valid address")
+)
+
 // sender and recipient in cryptocurrency are referring to the keys of the wallets
-// in our implementation, we will use the ip address of the sender and recipient. This is only used for metadata for hashing purposes
+// in our implementation, they are addresses derived from Ed25519 public keys (see AddressFromPublicKey).
+// This is only used for metadata for hashing purposes
 // not actually sending anything to the recipient (no actual cryptocurrency)
 // in real-life cryptocurrency, recipient does not have to be online to receive
-// sender		ip address of the sender
-// recipient	ip address of the recipient
+// sender		address of the sender
+// recipient	address of the recipient
 // timestamp	time when transaction is created
 // data			message that sender wants to send to recipient. In real-life cryptocurrency,
 //
 //	this is the amount of cryptocurrency that the sender wants to send to the recipient
+//
+// pubKey		public key of the sender, its address must be the sender
+// signature	Ed25519 signature of the sender over everything above (see SigningBytes)
 type Transaction struct {
 	Sender    []byte
 	Recipient []byte
 	Timestamp int64
 	Data      []byte
+	PubKey    []byte
+	Signature []byte
 }
 
 // Creates a new transaction on in its own block, not in other nodes' blocks
@@ -38,19 +53,105 @@ func MakeTransaction(sender string, recipient string, timestamp int64, data stri
 	return transaction
 }
 
+// Creates a new transaction from the address of the key and signs it with the key.
+func MakeSignedTransaction(key ed25519.PrivateKey, recipient string, timestamp int64, data string) (*Transaction, error) {
+	sender := AddressFromPublicKey(key.Public().(ed25519.PublicKey))
+	transaction := MakeTransaction(sender, recipient, timestamp, data)
+
+	if err := transaction.Sign(key); err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
+}
+
+// Sign sets the public key of the transaction and signs it.
+// The sender of the transaction must be the address of the key.
+func (transaction *Transaction) Sign(key ed25519.PrivateKey) error {
+	publicKey := key.Public().(ed25519.PublicKey)
+
+	if string(transaction.Sender) != AddressFromPublicKey(publicKey) {
+		return ErrSenderMismatch
+	}
+
+	transaction.PubKey = append([]byte{}, publicKey...)
+	transaction.Signature = ed25519.Sign(key, transaction.SigningBytes())
+
+	return nil
+}
+
+// Verify checks that the transaction is signed by the key of its sender and that the recipient is an address.
+func (transaction *Transaction) Verify() error {
+	if len(transaction.Signature) == 0 || len(transaction.PubKey) == 0 {
+		return ErrMissingSignature
+	}
+
+	if len(transaction.PubKey) != ed25519.PublicKeySize {
+		return ErrInvalidSignature
+	}
+
+	if string(transaction.Sender) != AddressFromPublicKey(transaction.PubKey) {
+		return ErrSenderMismatch
+	}
+
+	if !IsValidAddress(string(transaction.Recipient)) {
+		return ErrInvalidRecipient
+	}
+
+	if !ed25519.Verify(transaction.PubKey, transaction.SigningBytes(), transaction.Signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// SigningBytes returns the bytes the sender signs: the canonical encoding without the signature.
+func (transaction *Transaction) SigningBytes() []byte {
+	return transaction.encodeFields(false)
+}
+
+// Checks the signatures of all the transactions in the block, and that none of them is in it twice.
+func verifyTransactions(block *Block) error {
+	seen := make(map[string]bool, len(block.GetDataList()))
+
+	for _, content := range block.GetDataList() {
+		transaction, ok := content.(Transaction)
+		if !ok {
+			return errors.New("block contains content that is not a transaction")
+		}
+
+		hash, _ := transaction.CalculateHash()
+		if seen[string(hash)] {
+			return &TransactionError{Hash: hash, Err: ErrDuplicateInBlock}
+		}
+		seen[string(hash)] = true
+
+		if err := transaction.Verify(); err != nil {
+			return &TransactionError{Hash: hash, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// TransactionError tells which transaction of a block is invalid.
+type TransactionError struct {
+	Hash []byte
+	Err  error
+}
+
+func (err *TransactionError) Error() string {
+	return "transaction " + hex.EncodeToString(err.Hash) + ": " + err.Err.Error()
+}
+
+func (err *TransactionError) Unwrap() error {
+	return err.Err
+}
+
 // Turns everything in the Transaction struct into a byte array
+// Uses the canonical encoding, so different transactions never turn into the same bytes
 func (transaction *Transaction) TransactionDataToBytes() []byte {
-	data := bytes.Join(
-		[][]byte{
-			transaction.Sender,
-			transaction.Recipient,
-			ToHex(int64(transaction.Timestamp)),
-			transaction.Data,
-		},
-		[]byte{},
-	)
-
-	return data
+	return transaction.encode()
 }
 
 // Calculates the hash of the transaction
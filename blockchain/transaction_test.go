@@ -0,0 +1,95 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Creates a key pair and returns it with its address.
+func newTestKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey, string) {
+	publicKey, key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return publicKey, key, AddressFromPublicKey(publicKey)
+}
+
+func TestTransactionSignature(t *testing.T) {
+	_, key, _ := newTestKey(t)
+	otherPublicKey, otherKey, otherAddress := newTestKey(t)
+
+	signed, err := MakeSignedTransaction(key, otherAddress, time.Now().UnixNano(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signed.Verify(); err != nil {
+		t.Fatalf("signed transaction does not verify: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(transaction *Transaction)
+		want   error
+	}{
+		{"data changed", func(transaction *Transaction) { transaction.Data = []byte("goodbye") }, ErrInvalidSignature},
+		{"timestamp changed", func(transaction *Transaction) { transaction.Timestamp++ }, ErrInvalidSignature},
+		{"recipient changed", func(transaction *Transaction) {
+			transaction.Recipient = []byte(AddressFromPublicKey(transaction.PubKey))
+		}, ErrInvalidSignature},
+		{"signature changed", func(transaction *Transaction) { transaction.Signature[0] ^= 1 }, ErrInvalidSignature},
+		{"signature of another key", func(transaction *Transaction) {
+			transaction.Signature = ed25519.Sign(otherKey, transaction.SigningBytes())
+		}, ErrInvalidSignature},
+		{"public key swapped", func(transaction *Transaction) { transaction.PubKey = otherPublicKey }, ErrSenderMismatch},
+		{"public key and sender swapped", func(transaction *Transaction) {
+			transaction.PubKey = otherPublicKey
+			transaction.Sender = []byte(otherAddress)
+		}, ErrInvalidSignature},
+		{"sender is not the address of the key", func(transaction *Transaction) { transaction.Sender = []byte(otherAddress) }, ErrSenderMismatch},
+		{"public key too short", func(transaction *Transaction) { transaction.PubKey = transaction.PubKey[:16] }, ErrInvalidSignature},
+		{"recipient is not an address", func(transaction *Transaction) { transaction.Recipient = []byte("bob") }, ErrInvalidRecipient},
+		{"no signature", func(transaction *Transaction) { transaction.Signature = nil }, ErrMissingSignature},
+		{"no public key", func(transaction *Transaction) { transaction.PubKey = nil }, ErrMissingSignature},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transaction := *signed
+			transaction.PubKey = append([]byte{}, signed.PubKey...)
+			transaction.Signature = append([]byte{}, signed.Signature...)
+			test.change(&transaction)
+
+			if err := transaction.Verify(); !errors.Is(err, test.want) {
+				t.Fatalf("Verify returned %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	if err := signed.Verify(); err != nil {
+		t.Fatalf("changing copies changed the signed transaction: %v", err)
+	}
+}
+
+// A key only signs transactions whose sender is its own address.
+func TestTransactionSignWrongKey(t *testing.T) {
+	_, key, address := newTestKey(t)
+	_, otherKey, _ := newTestKey(t)
+
+	transaction := MakeTransaction(address, address, time.Now().UnixNano(), "hello")
+	if err := transaction.Sign(otherKey); !errors.Is(err, ErrSenderMismatch) {
+		t.Fatalf("signing with another key returned %v, want %v", err, ErrSenderMismatch)
+	}
+	if len(transaction.Signature) != 0 || len(transaction.PubKey) != 0 {
+		t.Fatal("failed signing left a signature")
+	}
+
+	if err := transaction.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := transaction.Verify(); err != nil {
+		t.Fatalf("transaction signed by its sender does not verify: %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Timestamp rules of blocks.
+// A block may not be more than MaxFutureBlockTime ahead of the clock of the node that checks it,
+// and must be later than the median timestamp of the medianTimeBlocks blocks before it.
+const (
+	MaxFutureBlockTime = 2 * time.Minute
+	medianTimeBlocks   = 11
+)
+
+// Rules a block can break. ValidateBlock wraps them in a BlockError, so errors.Is tells which rule failed.
+var (
+	ErrMissingHash         = errors.New("block has no hash")
+	ErrHashMismatch        = errors.New("block hash does not match its header")
+	ErrHashAboveTarget     = errors.New("block hash is not below its target")
+	ErrTargetAboveLimit    = errors.New("block target is easier than the limit")
+	ErrUnknownBlockVersion = errors.New("block version is unknown")
+	ErrNoTransactions      = errors.New("block has no transactions")
+	ErrTooManyTransactions = errors.New("block has too many transactions")
+	ErrMerkleRootMismatch  = errors.New("merkle root does not match the transactions of the block")
+	ErrTimestampTooNew     = errors.New("block timestamp is too far in the future")
+	ErrTimestampTooOld     = errors.New("block timestamp is not after the median timestamp of the blocks before it")
+	ErrBrokenLink          = errors.New("block is not a child of the block before it in the canonical chain")
+)
+
+// Rules a transaction of a block can break. They are wrapped in a TransactionError inside the BlockError.
+var (
+	ErrDuplicateInBlock    = errors.New("transaction is in the block more than once")
+	ErrTransactionInBranch = errors.New("transaction is already in a block before it on its branch")
+)
+
+// ErrInvalidChainTree means the Merkle tree of the canonical chain does not match its blocks.
+var ErrInvalidChainTree = errors.New("merkle tree of the chain does not match its blocks")
+
+// BlockError tells which block is invalid and which rule it broke.
+type BlockError struct {
+	Hash []byte
+	Err  error
+}
+
+func (err *BlockError) Error() string {
+	return "block " + hex.EncodeToString(err.Hash) + ": " + err.Err.Error()
+}
+
+func (err *BlockError) Unwrap() error {
+	return err.Err
+}
+
+// ValidateBlock checks everything about a block that does not depend on the chain:
+// the header hash is recalculated once from the transmitted nonce and must match the hash of the block
+// and be below the target in its bits, the Merkle root must match the transactions,
+// the number of transactions must be between 1 and GetMax(), every transaction must be signed by its sender
+// and be in the block only once, and the timestamp may not be too far in the future.
+// The block is never mined again.
+func ValidateBlock(block *Block) error {
+	if err := validateBlock(block); err != nil {
+		return &BlockError{Hash: block.GetHash(), Err: err}
+	}
+
+	return nil
+}
+
+func validateBlock(block *Block) error {
+	var intHash big.Int
+
+	if len(block.GetHash()) == 0 {
+		return ErrMissingHash
+	}
+
+	if block.header.version != BlockVersion {
+		return ErrUnknownBlockVersion
+	}
+
+	hash, err := block.CalculateHash()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(hash, block.GetHash()) {
+		return ErrHashMismatch
+	}
+
+	target := CompactToBig(block.GetBits())
+	if target.Cmp(powLimit) > 0 {
+		return ErrTargetAboveLimit
+	}
+
+	if intHash.SetBytes(hash).Cmp(target) != -1 {
+		return ErrHashAboveTarget
+	}
+
+	if len(block.GetDataList()) == 0 || block.GetData() == nil {
+		return ErrNoTransactions
+	}
+
+	if len(block.GetDataList()) > GetMax() {
+		return ErrTooManyTransactions
+	}
+
+	if !bytes.Equal(block.GetData().MerkleRoot(), block.GetMerkleRoot()) {
+		return ErrMerkleRootMismatch
+	}
+
+	if block.GetTimestamp() > time.Now().Add(MaxFutureBlockTime).UnixNano() {
+		return ErrTimestampTooNew
+	}
+
+	return verifyTransactions(block)
+}
+
+// ValidateBlock checks a block with the package level ValidateBlock, which needs no chain, and then against this chain:
+// its parent must be known, its bits must follow the retarget rules for its parent,
+// its timestamp must be later than the median timestamp of the blocks before it,
+// and none of its transactions may be in a block between genesis and its parent.
+// Returns a BlockError that wraps the rule that failed.
+func (blockChain *BlockChain) ValidateBlock(block *Block) error {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	return blockChain.validateBlock(block)
+}
+
+func (blockChain *BlockChain) validateBlock(block *Block) error {
+	if err := ValidateBlock(block); err != nil {
+		return err
+	}
+
+	parent, known := blockChain.blocks[string(block.GetParentBlockHash())]
+	if !known {
+		return &BlockError{Hash: block.GetHash(), Err: ErrUnknownParent}
+	}
+
+	if block.GetBits() != blockChain.nextBits(parent) {
+		return &BlockError{Hash: block.GetHash(), Err: ErrBadDifficulty}
+	}
+
+	if block.GetTimestamp() <= medianTimestamp(parent) {
+		return &BlockError{Hash: block.GetHash(), Err: ErrTimestampTooOld}
+	}
+
+	if err := blockChain.checkBranchTransactions(block, parent); err != nil {
+		return &BlockError{Hash: block.GetHash(), Err: err}
+	}
+
+	return nil
+}
+
+// Checks that no transaction of the block is in one of the blocks from genesis up to and including its parent.
+// The blocks of a side branch are checked one by one until the branch joins the canonical chain,
+// the canonical part is looked up in the transaction index, where only blocks up to the fork point count.
+func (blockChain *BlockChain) checkBranchTransactions(block *Block, parent *blockNode) error {
+	hashes := make(map[string][]byte, len(block.GetDataList()))
+	for _, content := range block.GetDataList() {
+		hash, _ := content.CalculateHash()
+		hashes[string(hash)] = hash
+	}
+
+	fork := parent
+	for ; !blockChain.isCanonical(fork.block.GetHash()); fork = fork.parent {
+		for _, content := range fork.block.GetDataList() {
+			hash, _ := content.CalculateHash()
+			if _, ok := hashes[string(hash)]; ok {
+				return &TransactionError{Hash: hash, Err: ErrTransactionInBranch}
+			}
+		}
+	}
+
+	for key, hash := range hashes {
+		if mined, ok := blockChain.transactions[key]; ok && blockChain.blocks[string(mined.GetHash())].height <= fork.height {
+			return &TransactionError{Hash: hash, Err: ErrTransactionInBranch}
+		}
+	}
+
+	return nil
+}
+
+// Returns the median timestamp of the tree entry and the blocks before it (at most medianTimeBlocks of them).
+func medianTimestamp(node *blockNode) int64 {
+	var timestamps []int64
+
+	for ; node != nil && len(timestamps) < medianTimeBlocks; node = node.parent {
+		timestamps = append(timestamps, node.block.GetTimestamp())
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2]
+}
+
+// VerifyChain checks the whole canonical chain again: its Merkle tree, and every block after genesis
+// against the rules of ValidateBlock and the chain and against the block before it.
+// Returns the number of blocks that were checked (genesis included) and the first problem found.
+func (blockChain *BlockChain) VerifyChain() (int, error) {
+	blockChain.mutex.RLock()
+	defer blockChain.mutex.RUnlock()
+
+	valid, err := blockChain.chain.VerifyTree()
+	if err != nil {
+		return 0, err
+	}
+	if !valid {
+		return 0, ErrInvalidChainTree
+	}
+
+	for height := 1; height < len(blockChain.blockList); height++ {
+		block := blockChain.blockList[height].(*Block)
+		parent := blockChain.blockList[height-1].(*Block)
+
+		if !bytes.Equal(block.GetParentBlockHash(), parent.GetHash()) {
+			return height, &BlockError{Hash: block.GetHash(), Err: ErrBrokenLink}
+		}
+
+		if err := blockChain.validateBlock(block); err != nil {
+			return height, err
+		}
+	}
+
+	return len(blockChain.blockList), nil
+}
@@ -0,0 +1,143 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/cbergoon/merkletree"
+)
+
+// Builds a block with the transactions on top of the parent and mines it.
+func buildTestBlock(t *testing.T, parent *Block, bits uint32, timestamp int64, transactions ...Transaction) *Block {
+	contents := make([]merkletree.Content, 0, len(transactions))
+	for _, transaction := range transactions {
+		contents = append(contents, transaction)
+	}
+
+	block := MakeAddBlock(timestamp, parent.GetHash(), bits, 0, contents)
+	if _, err := block.MineParallel(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+// Every rule of block validation returns its own error, wrapped in a BlockError (and a TransactionError
+// for the rules about one transaction) that names the block and the transaction.
+func TestValidateBlockRules(t *testing.T) {
+	node := newTestNode(t, 0)
+	chain := node.LocalChain
+	genesis := chain.GetGenesis()
+
+	mined := newTestTransaction(t, "already mined")
+	addPending(t, node, mined)
+	root := mineTestBlock(t, node, 0)
+	_, bits := chain.GetRootAndNextBits()
+	now := time.Now().UnixNano()
+
+	fresh := newTestTransaction(t, "fresh")
+
+	tests := []struct {
+		name        string
+		block       func() *Block
+		want        error
+		transaction *Transaction // transaction the TransactionError names, nil if the rule is about the whole block
+	}{
+		{"hash above the target", func() *Block {
+			block := buildTestBlock(t, root, bits, now, fresh)
+			var hash big.Int
+			for {
+				block.SetNonce(block.GetNonce() + 1)
+				digest, _ := block.CalculateHash()
+				if hash.SetBytes(digest).Cmp(block.GetTarget()) >= 0 {
+					block.SetHash(digest)
+					return block
+				}
+			}
+		}, ErrHashAboveTarget, nil},
+		{"merkle root of other transactions", func() *Block {
+			block := MakeAddBlock(now, root.GetHash(), bits, 0, []merkletree.Content{fresh})
+			block.header.merkleRoot = bytes.Repeat([]byte{1}, 32)
+			if _, err := block.MineParallel(context.Background(), 0); err != nil {
+				t.Fatal(err)
+			}
+			return block
+		}, ErrMerkleRootMismatch, nil},
+		{"timestamp not after the median", func() *Block {
+			return buildTestBlock(t, root, bits, genesis.GetTimestamp(), fresh)
+		}, ErrTimestampTooOld, nil},
+		{"timestamp too far in the future", func() *Block {
+			return buildTestBlock(t, root, bits, time.Now().Add(2*MaxFutureBlockTime).UnixNano(), fresh)
+		}, ErrTimestampTooNew, nil},
+		{"bits that do not follow the retarget rules", func() *Block {
+			harder := new(big.Int).Rsh(CompactToBig(bits), 1)
+			return buildTestBlock(t, root, BigToCompact(harder), now, fresh)
+		}, ErrBadDifficulty, nil},
+		{"unknown parent", func() *Block {
+			block := buildTestBlock(t, root, bits, now, fresh)
+			block.SetBlockParentHash(bytes.Repeat([]byte{2}, 32))
+			if _, err := block.MineParallel(context.Background(), 0); err != nil {
+				t.Fatal(err)
+			}
+			return block
+		}, ErrUnknownParent, nil},
+		{"transaction twice in the block", func() *Block {
+			return buildTestBlock(t, root, bits, now, fresh, fresh)
+		}, ErrDuplicateInBlock, &fresh},
+		{"transaction already on the branch", func() *Block {
+			return buildTestBlock(t, root, bits, now, fresh, mined)
+		}, ErrTransactionInBranch, &mined},
+		{"transaction already on the branch, from a side branch", func() *Block {
+			// a sibling of the root with the transaction is only on its own branch, not on the one of the root
+			sibling := buildTestBlock(t, genesis, bits, now, mined)
+			if err := chain.AddBlock(sibling); err != nil {
+				t.Fatal(err)
+			}
+			return buildTestBlock(t, sibling, bits, now+1, fresh, mined)
+		}, ErrTransactionInBranch, &mined},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block := test.block()
+			err := chain.ValidateBlock(block)
+
+			if !errors.Is(err, test.want) {
+				t.Fatalf("err = %v, want %v", err, test.want)
+			}
+
+			var blockErr *BlockError
+			if !errors.As(err, &blockErr) || !bytes.Equal(blockErr.Hash, block.GetHash()) {
+				t.Fatalf("err = %v, want a BlockError for block %x", err, block.GetHash())
+			}
+
+			var transactionErr *TransactionError
+			if test.transaction == nil {
+				if errors.As(err, &transactionErr) {
+					t.Fatalf("rule about the whole block is reported for transaction %x", transactionErr.Hash)
+				}
+				return
+			}
+
+			hash, _ := test.transaction.CalculateHash()
+			if !errors.As(err, &transactionErr) || !bytes.Equal(transactionErr.Hash, hash) {
+				t.Fatalf("err = %v, want a TransactionError for transaction %x", err, hash)
+			}
+		})
+	}
+}
+
+// A valid block passes, so the cases above fail only for the rule they break.
+func TestValidateBlockValid(t *testing.T) {
+	node := newTestNode(t, 0)
+	root, bits := node.LocalChain.GetRootAndNextBits()
+
+	block := buildTestBlock(t, root, bits, time.Now().UnixNano(), newTestTransaction(t, "valid"))
+	if err := node.LocalChain.ValidateBlock(block); err != nil {
+		t.Fatal(err)
+	}
+}
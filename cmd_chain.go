@@ -0,0 +1,80 @@
+// chain command
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Lqvendar/blockchain/api"
+)
+
+const chainUsage = `Usage: chain <command> [-api host:port] [-config file] [arguments]
+
+Commands:
+  head           print the root of the canonical chain
+  show <hash>    print the block, or the transaction, with the hash
+  verify         check every block of the canonical chain again, exits with 1 if the chain is invalid`
+
+// Runs "chain head|show|verify" against a running node.
+func runChainCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println(chainUsage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("chain "+args[0], flag.ExitOnError)
+	client := addClientFlags(flags)
+	flags.Parse(args[1:])
+
+	switch args[0] {
+	case "head":
+		var head api.HeadView
+		call(client.client(), "chain_head", &head)
+		printJSON(head)
+
+	case "show":
+		if flags.NArg() != 1 {
+			log.Fatal("show needs the hash of a block or a transaction")
+		}
+		hash := flags.Arg(0)
+		node := client.client()
+
+		var block api.BlockView
+		err := node.Call("chain_getBlockByHash", &block, hash)
+		if err == nil {
+			printJSON(block)
+			return
+		}
+		if !api.IsNotFound(err) {
+			exitWithCallError("chain_getBlockByHash", err)
+		}
+
+		// not a block, so it may be a transaction
+		var transaction api.TransactionView
+		err = node.Call("tx_get", &transaction, hash)
+		if api.IsNotFound(err) {
+			log.Fatal("no block or transaction with hash " + hash)
+		}
+		if err != nil {
+			exitWithCallError("tx_get", err)
+		}
+		printJSON(transaction)
+
+	case "verify":
+		var result api.VerifyView
+		call(client.client(), "chain_verify", &result)
+
+		if !result.Valid {
+			fmt.Printf("Chain is invalid after %d blocks: %s\n", result.Blocks, result.Error)
+			os.Exit(1)
+		}
+		fmt.Printf("Chain is valid: %d blocks, head %s\n", result.Blocks, result.Head)
+
+	default:
+		fmt.Println(chainUsage)
+		os.Exit(2)
+	}
+}
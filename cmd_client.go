@@ -0,0 +1,86 @@
+// flags of the commands that talk to a running node
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/Lqvendar/blockchain/api"
+	"github.com/Lqvendar/blockchain/config"
+)
+
+// Flags that tell a command how to reach the API of a running node.
+// configFile	config file of the node, its api_listen (and keystore, for tx send) is used
+// api			address of the API, overrides the config
+type clientFlags struct {
+	configFile *string
+	api        *string
+}
+
+func addClientFlags(flags *flag.FlagSet) *clientFlags {
+	return &clientFlags{
+		configFile: flags.String("config", "", "config file of the node to talk to (its api_listen is used)"),
+		api:        flags.String("api", "", "address of the API of the node (host:port, default $BLOCKCHAIN_API_LISTEN or "+config.DefaultAPIListen+")"),
+	}
+}
+
+// Loads the config the flags point to: the config file if one is given, then the BLOCKCHAIN_* environment variables,
+// then -api. Nothing is printed, so the output of the commands can be used by scripts.
+func (client *clientFlags) load() *config.Config {
+	cfg := config.Default()
+	if *client.configFile != "" {
+		loaded, err := config.Load(*client.configFile)
+		if err != nil {
+			log.Fatal("error loading the config\n", err)
+		}
+		cfg = loaded
+	}
+
+	if err := cfg.ApplyEnv(); err != nil {
+		log.Fatal("invalid environment variables\n", err)
+	}
+
+	if *client.api != "" {
+		cfg.APIListen = *client.api
+	}
+	if cfg.APIListen == "" {
+		log.Fatal("the node has no API (its api_listen is empty), give the address of one with -api")
+	}
+
+	return cfg
+}
+
+// Returns the client of the API the flags point to.
+func (client *clientFlags) client() *api.Client {
+	return api.NewClient(client.load().APIListen)
+}
+
+// Calls the method on the node and exits if it fails.
+func call(client *api.Client, method string, result any, params ...any) {
+	if err := client.Call(method, result, params...); err != nil {
+		exitWithCallError(method, err)
+	}
+}
+
+// Exits with the error of a call to the node.
+func exitWithCallError(method string, err error) {
+	var rpcErr *api.Error
+	if errors.As(err, &rpcErr) {
+		log.Fatal(method+" failed\n", rpcErr.Message)
+	}
+	log.Fatal("error calling the node, is it running with its API enabled?\n", err)
+}
+
+// Prints the value as indented JSON, for people and for jq.
+func printJSON(value any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(value); err != nil {
+		log.Fatal("error printing the result\n", err)
+	}
+}
@@ -0,0 +1,80 @@
+// genesis command
+
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+)
+
+// Runs "genesis init": builds a genesis spec from the flags, mines its block
+// and writes the spec (including the resulting nonce and hash) to a JSON file, so nodes do not have to mine it again.
+// Every node started with that file will have the same genesis block.
+func runGenesisCommand(args []string) {
+	if len(args) == 0 || args[0] != "init" {
+		fmt.Println("Usage: genesis init [-out genesis.json] [-chain-id id] [-timestamp unixnano] [-difficulty bits] [-payload a,b,c]")
+		os.Exit(2)
+	}
+
+	defaults := blockchain.DefaultGenesis()
+
+	flags := flag.NewFlagSet("genesis init", flag.ExitOnError)
+	out := flags.String("out", "genesis.json", "file the genesis spec is written to")
+	chainID := flags.String("chain-id", defaults.ChainID, "chain ID of the new chain")
+	timestamp := flags.Int64("timestamp", time.Now().UnixNano(), "timestamp of the genesis block in UnixNano")
+	difficulty := flags.Int("difficulty", defaults.Difficulty, "leading zero bits of the genesis hash (at most "+strconv.Itoa(blockchain.MaxGenesisDifficulty)+")")
+	payload := flags.String("payload", strings.Join(defaults.Payload, ","), "comma separated data of the genesis transactions")
+	flags.Parse(args[1:])
+
+	spec := &blockchain.GenesisSpec{
+		ChainID:    *chainID,
+		Timestamp:  *timestamp,
+		Payload:    strings.Split(*payload, ","),
+		Difficulty: *difficulty,
+	}
+
+	genesis, err := spec.MakeBlock()
+	if err != nil {
+		log.Fatal("error creating the genesis block\n", err)
+	}
+	nonce := genesis.GetNonce()
+	spec.Nonce = &nonce
+	spec.Hash = hex.EncodeToString(genesis.GetHash())
+
+	if err := spec.Save(*out); err != nil {
+		log.Fatal("error writing the genesis file\n", err)
+	}
+
+	fmt.Printf("Genesis block of chain %s written to %s. Hash: %s\n", spec.ChainID, *out, spec.Hash)
+}
+
+// Loads the genesis spec the node runs with.
+// Falls back to the default genesis, with the given difficulty, if the file does not exist.
+// The difficulty of an existing genesis file wins over the given one, which is then only reported.
+func loadGenesis(filename string, difficulty int) *blockchain.GenesisSpec {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		fmt.Println("No genesis file found at " + filename + ", using the default genesis block")
+		spec := blockchain.DefaultGenesis()
+		spec.Difficulty = difficulty
+		return spec
+	}
+
+	spec, err := blockchain.LoadGenesis(filename)
+	if err != nil {
+		log.Fatal("error loading the genesis file\n", err)
+	}
+
+	if spec.Difficulty != difficulty {
+		fmt.Printf("Ignoring difficulty %d of the config, the genesis file %s sets difficulty %d\n", difficulty, filename, spec.Difficulty)
+	}
+
+	return spec
+}
@@ -0,0 +1,108 @@
+// node command
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Lqvendar/blockchain/api"
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+)
+
+// Time a starting node waits for its first peer before it syncs.
+const firstPeerTimeout = 30 * time.Second
+
+// Runs "node run".
+func runNodeCommand(args []string) {
+	if len(args) == 0 || args[0] != "run" {
+		fmt.Println("Usage: node run [flags] [ID]")
+		os.Exit(2)
+	}
+
+	runNode(args[1:])
+}
+
+// Starts a node with the config from the args (see loadConfig) and runs it until it gets SIGINT or SIGTERM.
+// Transactions are sent to it with "tx send", its chain and peers are looked at with "chain" and "peers".
+func runNode(args []string) {
+	cfg := loadConfig(args)
+
+	blockchain.SetMax(cfg.BlockSize)
+	level, _ := blockchain.ParseLogLevel(cfg.LogLevel) // checked by Validate
+	blockchain.SetLogLevel(level)
+
+	myID := cfg.ID
+	node := blockchain.MakeNode(myID)
+	node.SetListenAddress(cfg.Listen)
+	for _, peer := range cfg.Peers {
+		node.AddPeer(peer.ID, peer.Address)
+	}
+	for _, seed := range cfg.Seeds {
+		node.AddSeed(seed)
+	}
+	node.SetTargetPeers(cfg.TargetPeers)
+
+	var err error
+	node.Key, err = blockchain.LoadOrCreateKey(filepath.Join(cfg.DataDir, "node.key"))
+	if err != nil {
+		log.Fatal("error loading the node key\n", err)
+	}
+	fmt.Println("Node address: " + node.GetAddress())
+
+	err = rpc.Register(node)
+	fmt.Println("Node " + strconv.Itoa(myID) + " up!")
+	if err != nil {
+		log.Fatal("error registering the RPCs\n", err)
+	}
+
+	store, err := blockchain.OpenBlockStore(cfg.DataDir)
+	if err != nil {
+		log.Fatal("error opening the block store\n", err)
+	}
+	defer store.Close()
+
+	localChain, err := blockchain.NewBlockChain(loadGenesis(cfg.Genesis, cfg.Difficulty), store)
+	if err != nil {
+		log.Fatal("error loading the blockchain\n", err)
+	}
+	node.SetLocalChain(localChain)
+
+	// nodes connect now
+	node.ConnectNodes()
+
+	if cfg.APIListen != "" {
+		go func() {
+			log.Fatal("error serving the API\n", api.ListenAndServe(cfg.APIListen, node))
+		}()
+		fmt.Println("Serving the JSON-RPC API on http://" + cfg.APIListen + "/rpc, events on ws://" + cfg.APIListen + "/ws and the explorer on http://" + cfg.APIListen + "/head")
+	}
+
+	// catch up with the peers before adding any blocks of our own.
+	// A node without peers (the first of a network) starts on its own chain, and syncs when a peer connects later.
+	if node.WaitForPeer(firstPeerTimeout) {
+		node.SyncChain()
+	} else {
+		fmt.Println("No peer connected within " + firstPeerTimeout.String() + ", starting without syncing")
+	}
+
+	if cfg.Mining.Enabled {
+		if err := node.StartMining(cfg.Mining.Interval, cfg.Mining.Workers); err != nil {
+			log.Fatal(err)
+		}
+		defer node.StopMining()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	sig := <-signals
+	fmt.Println("Got " + sig.String() + ", exiting...")
+}
@@ -0,0 +1,27 @@
+// peers command
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Lqvendar/blockchain/api"
+)
+
+// Runs "peers list": prints the addresses a running node knows and the state of its connection to each.
+func runPeersCommand(args []string) {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Println("Usage: peers list [-api host:port] [-config file]")
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("peers list", flag.ExitOnError)
+	client := addClientFlags(flags)
+	flags.Parse(args[1:])
+
+	var peers []api.PeerView
+	call(client.client(), "net_peers", &peers)
+	printJSON(peers)
+}
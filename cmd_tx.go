@@ -0,0 +1,70 @@
+// tx command
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Lqvendar/blockchain/api"
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+	"github.com/Lqvendar/blockchain/wallet"
+)
+
+const txUsage = `Usage: tx send [-api host:port] [-config file] [-keystore dir] [-passphrase-file file] [-from addr] -to addr -data data`
+
+// Runs "tx send": signs a transaction with an account of the wallet and submits it to a running node,
+// which adds it to its mempool and relays it. Prints the hash of the transaction.
+func runTxCommand(args []string) {
+	if len(args) == 0 || args[0] != "send" {
+		fmt.Println(txUsage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("tx send", flag.ExitOnError)
+	client := addClientFlags(flags)
+	keystoreDir := flags.String("keystore", "", "directory of the wallet accounts (default the keystore of the config)")
+	passphraseFile := flags.String("passphrase-file", "", "file to read the passphrase from (prompted for if not set)")
+	from := flags.String("from", "", "address of the sending account (default the first account of the wallet)")
+	to := flags.String("to", "", "address of the recipient")
+	data := flags.String("data", "", "data of the transaction")
+	flags.Parse(args[1:])
+
+	if !blockchain.IsValidAddress(*to) {
+		log.Fatal("recipient (-to) must be an address (40 hex characters)")
+	}
+	if *data == "" {
+		log.Fatal("data (-data) cannot be empty")
+	}
+
+	cfg := client.load()
+	if *keystoreDir == "" {
+		*keystoreDir = cfg.Keystore
+	}
+
+	keystore, err := wallet.OpenKeystore(*keystoreDir)
+	if err != nil {
+		log.Fatal("error opening the keystore\n", err)
+	}
+
+	if *from == "" {
+		accounts, err := keystore.Accounts()
+		if err != nil || len(accounts) == 0 {
+			log.Fatal("the wallet has no accounts, create one with: wallet new")
+		}
+		*from = accounts[0].Address
+	}
+
+	transaction, err := keystore.Sign(*from, readPassphrase(*passphraseFile, false), *to, time.Now().UnixNano(), *data)
+	if err != nil {
+		log.Fatal("error signing the transaction\n", err)
+	}
+
+	var view api.TransactionView
+	call(api.NewClient(cfg.APIListen), "tx_submit", &view, api.NewTransactionView(*transaction))
+
+	fmt.Println(view.Hash)
+}
@@ -0,0 +1,145 @@
+// wallet command
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+	"github.com/Lqvendar/blockchain/config"
+	"github.com/Lqvendar/blockchain/wallet"
+	"golang.org/x/term"
+)
+
+const walletUsage = `Usage: wallet <command> [-keystore dir] [-passphrase-file file] ...
+
+Commands:
+  new                                  create a new account
+  list                                 list the addresses of all accounts
+  export -address addr                 print the key of an account as a hex seed
+  import -key hex                      add an account from a hex seed
+  sign -from addr -to addr -data data  print a signed transaction (hex canonical encoding)`
+
+// Runs "wallet new|list|export|import|sign".
+func runWalletCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println(walletUsage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("wallet "+args[0], flag.ExitOnError)
+	keystoreDir := flags.String("keystore", config.Default().Keystore, "directory of the encrypted key files")
+	passphraseFile := flags.String("passphrase-file", "", "file to read the passphrase from (prompted for if not set)")
+	address := flags.String("address", "", "address of the account (export)")
+	key := flags.String("key", "", "hex seed of the key to import (import)")
+	from := flags.String("from", "", "address of the sending account (sign)")
+	to := flags.String("to", "", "address of the recipient (sign)")
+	data := flags.String("data", "", "data of the transaction (sign)")
+	flags.Parse(args[1:])
+
+	keystore, err := wallet.OpenKeystore(*keystoreDir)
+	if err != nil {
+		log.Fatal("error opening the keystore\n", err)
+	}
+
+	switch args[0] {
+	case "new":
+		account, err := keystore.NewAccount(readPassphrase(*passphraseFile, true))
+		if err != nil {
+			log.Fatal("error creating the account\n", err)
+		}
+		fmt.Println("New account: " + account.Address)
+
+	case "list":
+		accounts, err := keystore.Accounts()
+		if err != nil {
+			log.Fatal("error reading the keystore\n", err)
+		}
+		for i, account := range accounts {
+			fmt.Printf("%d. %s\n", i+1, account.Address)
+		}
+
+	case "export":
+		seed, err := keystore.Export(*address, readPassphrase(*passphraseFile, false))
+		if err != nil {
+			log.Fatal("error exporting the account\n", err)
+		}
+		fmt.Println(seed)
+
+	case "import":
+		account, err := keystore.Import(*key, readPassphrase(*passphraseFile, true))
+		if err != nil {
+			log.Fatal("error importing the account\n", err)
+		}
+		fmt.Println("Imported account: " + account.Address)
+
+	case "sign":
+		if !blockchain.IsValidAddress(*to) || *data == "" {
+			log.Fatal("sign needs a recipient address (-to) and data (-data)")
+		}
+
+		transaction, err := keystore.Sign(*from, readPassphrase(*passphraseFile, false), *to, time.Now().UnixNano(), *data)
+		if err != nil {
+			log.Fatal("error signing the transaction\n", err)
+		}
+
+		encoded, _ := transaction.MarshalBinary()
+		fmt.Println(hex.EncodeToString(encoded))
+
+	default:
+		fmt.Println(walletUsage)
+		os.Exit(2)
+	}
+}
+
+// Reads the passphrase from the file, or asks for it on stdin if no file is given.
+// The prompts go to stderr, so they do not end up in the output of the command (e.g. an exported seed).
+// On a terminal the passphrase is not echoed. New passphrases have to be typed twice.
+func readPassphrase(filename string, confirm bool) string {
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			log.Fatal("error reading the passphrase file\n", err)
+		}
+		return strings.TrimRight(string(data), "\r\n")
+	}
+
+	read := readLine(bufio.NewScanner(os.Stdin))
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		read = func() string {
+			data, err := term.ReadPassword(fd)
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				log.Fatal("error reading the passphrase\n", err)
+			}
+			return string(data)
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, ">>> Enter passphrase:")
+	passphrase := read()
+
+	if confirm {
+		fmt.Fprintln(os.Stderr, ">>> Repeat passphrase:")
+		if read() != passphrase {
+			log.Fatal("passphrases do not match")
+		}
+	}
+
+	return passphrase
+}
+
+// Returns a function that reads the next line from the scanner.
+func readLine(reader *bufio.Scanner) func() string {
+	return func() string {
+		reader.Scan()
+		return reader.Text()
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Lqvendar/blockchain/config"
+)
+
+// Loads the config of the node from the args of "node run": the config file, then the BLOCKCHAIN_* environment variables,
+// then the flags that were given.
+// Without -config, configs/node<ID>.yaml is used when an ID is given as argument (the old "go run . <ID>"),
+// otherwise config.yaml if it exists, otherwise the defaults.
+// Exits with every problem in the config if it is invalid.
+func loadConfig(args []string) *config.Config {
+	defaults := config.Default()
+
+	flags := flag.NewFlagSet("node run", flag.ExitOnError)
+
+	configFile := flags.String("config", "", "YAML config file of the node (default configs/node<ID>.yaml or config.yaml)")
+	id := flags.Int("id", defaults.ID, "ID of the node")
+	listen := flags.String("listen", defaults.Listen, "address the RPC server listens on (host:port)")
+	apiListen := flags.String("api-listen", defaults.APIListen, "address the JSON-RPC, WebSocket and REST API for external clients listens on (host:port, empty to disable it)")
+	dataDir := flags.String("datadir", "", "directory the node keeps its blocks in (default data/node<ID>)")
+	genesisFile := flags.String("genesis", defaults.Genesis, "genesis file of the chain (default genesis block if it does not exist)")
+	keystore := flags.String("keystore", defaults.Keystore, "directory of the wallet accounts transactions are sent from")
+	peers := flags.String("peers", "", "peers to always stay connected to, as id=host:port,id=host:port")
+	seeds := flags.String("seeds", "", "nodes to ask for other nodes, as host:port,host:port")
+	targetPeers := flags.Int("target-peers", defaults.TargetPeers, "number of peers the node tries to stay connected to")
+	blockSize := flags.Int("block-size", defaults.BlockSize, "maximum number of transactions in a block")
+	difficulty := flags.Int("difficulty", defaults.Difficulty, "leading zero bits of the default genesis block")
+	mine := flags.Bool("mine", defaults.Mining.Enabled, "build blocks from the mempool and mine them")
+	mineInterval := flags.Duration("mine-interval", defaults.Mining.Interval, "time between two blocks the miner builds from the mempool")
+	mineWorkers := flags.Int("mine-workers", defaults.Mining.Workers, "number of goroutines that mine a block (default one per CPU)")
+	logLevel := flags.String("log-level", defaults.LogLevel, "least important messages that are printed (debug, info, warn, error)")
+	flags.Parse(args)
+
+	argID := -1
+	if flags.NArg() > 0 {
+		number, err := strconv.Atoi(flags.Arg(0))
+		if err != nil {
+			log.Fatal("node ID must be a number, got " + flags.Arg(0))
+		}
+		argID = number
+	}
+
+	if *configFile == "" {
+		if argID >= 0 {
+			*configFile = filepath.Join("configs", "node"+strconv.Itoa(argID)+".yaml")
+		} else if _, err := os.Stat("config.yaml"); err == nil {
+			*configFile = "config.yaml"
+		}
+	}
+
+	cfg := defaults
+	if *configFile != "" {
+		loaded, err := config.Load(*configFile)
+		if err != nil {
+			log.Fatal("error loading the config\n", err)
+		}
+		cfg = loaded
+		fmt.Println("Loaded config from " + *configFile)
+	}
+
+	if err := cfg.ApplyEnv(); err != nil {
+		log.Fatal("invalid environment variables\n", err)
+	}
+
+	if argID >= 0 {
+		cfg.ID = argID
+	}
+
+	var peerErr error
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "id":
+			cfg.ID = *id
+		case "listen":
+			cfg.Listen = *listen
+		case "api-listen":
+			cfg.APIListen = *apiListen
+		case "datadir":
+			cfg.DataDir = *dataDir
+		case "genesis":
+			cfg.Genesis = *genesisFile
+		case "keystore":
+			cfg.Keystore = *keystore
+		case "peers":
+			cfg.Peers, peerErr = config.ParsePeers(*peers)
+		case "seeds":
+			cfg.Seeds = config.ParseSeeds(*seeds)
+		case "target-peers":
+			cfg.TargetPeers = *targetPeers
+		case "block-size":
+			cfg.BlockSize = *blockSize
+		case "difficulty":
+			cfg.Difficulty = *difficulty
+		case "mine":
+			cfg.Mining.Enabled = *mine
+		case "mine-interval":
+			cfg.Mining.Interval = *mineInterval
+		case "mine-workers":
+			cfg.Mining.Workers = *mineWorkers
+		case "log-level":
+			cfg.LogLevel = *logLevel
+		}
+	})
+	if peerErr != nil {
+		log.Fatal("invalid -peers flag\n", peerErr)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("invalid config\n", err)
+	}
+
+	return cfg
+}
@@ -0,0 +1,339 @@
+// Package config loads the configuration of a node.
+// Values come from a YAML file, then from environment variables, then from command line flags,
+// each one overriding the one before it.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of the environment variables that override the config file.
+const EnvPrefix = "BLOCKCHAIN_"
+
+// Address the API listens on by default, and the address commands talk to a node on.
+const DefaultAPIListen = "localhost:8080"
+
+// Log levels the node knows, from the most to the least verbose.
+var LogLevels = []string{"debug", "info", "warn", "error"}
+
+// Config of a node.
+// ID			ID of the node, peers refer to it by this ID
+// Listen		address the RPC server listens on (host:port)
+// APIListen	address the JSON-RPC, WebSocket and REST API for external clients listens on (host:port, empty to disable it)
+// DataDir		directory the node keeps its blocks and key in
+// Genesis		genesis file of the chain (the default genesis block is used if it does not exist)
+// Keystore		directory of the wallet accounts transactions are sent from
+// Peers		nodes to always stay connected to, each with an explicit ID
+// Seeds		addresses of nodes to ask for other nodes (host:port)
+// TargetPeers	number of peers the node tries to stay connected to
+// BlockSize	maximum number of transactions in a block (every node of a chain needs the same value, bigger blocks are rejected)
+// Difficulty	leading zero bits of the default genesis block (when there is no genesis file)
+// Mining		whether and how the node mines blocks
+// LogLevel		least important messages that are printed (debug, info, warn or error)
+type Config struct {
+	ID          int          `yaml:"id"`
+	Listen      string       `yaml:"listen"`
+	APIListen   string       `yaml:"api_listen"`
+	DataDir     string       `yaml:"datadir"`
+	Genesis     string       `yaml:"genesis"`
+	Keystore    string       `yaml:"keystore"`
+	Peers       []Peer       `yaml:"peers"`
+	Seeds       []string     `yaml:"seeds"`
+	TargetPeers int          `yaml:"target_peers"`
+	BlockSize   int          `yaml:"block_size"`
+	Difficulty  int          `yaml:"difficulty"`
+	Mining      MiningConfig `yaml:"mining"`
+	LogLevel    string       `yaml:"log_level"`
+}
+
+// Peer is a node that this node connects to.
+type Peer struct {
+	ID      int    `yaml:"id"`
+	Address string `yaml:"address"`
+}
+
+// MiningConfig says whether the node mines and how.
+// Enabled		build blocks from the mempool and mine them
+// Interval		time between two blocks the miner builds from the mempool
+// Workers		number of goroutines that mine a block (0 means one per CPU)
+type MiningConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	Workers  int           `yaml:"workers"`
+}
+
+// Default returns the config that is used for everything the file, environment and flags do not set.
+func Default() *Config {
+	return &Config{
+		ID:          0,
+		Listen:      "localhost:4040",
+		APIListen:   DefaultAPIListen,
+		Genesis:     "genesis.json",
+		Keystore:    filepath.Join("data", "keystore"),
+		TargetPeers: 8,
+		BlockSize:   7,
+		Difficulty:  12,
+		Mining: MiningConfig{
+			Enabled:  true,
+			Interval: 5 * time.Second,
+		},
+		LogLevel: "info",
+	}
+}
+
+// Load reads the config file on top of the default config.
+// Fields that are missing from the file keep their default value, unknown fields are an error.
+func Load(filename string) (*Config, error) {
+	config := Default()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("config file %s: %v", filename, err)
+	}
+
+	return config, nil
+}
+
+// ApplyEnv overrides the config with the environment variables that are set:
+// BLOCKCHAIN_ID, BLOCKCHAIN_LISTEN, BLOCKCHAIN_API_LISTEN, BLOCKCHAIN_DATADIR, BLOCKCHAIN_GENESIS, BLOCKCHAIN_KEYSTORE,
+// BLOCKCHAIN_PEERS (id=host:port,id=host:port), BLOCKCHAIN_SEEDS (host:port,host:port),
+// BLOCKCHAIN_TARGET_PEERS, BLOCKCHAIN_BLOCK_SIZE, BLOCKCHAIN_DIFFICULTY,
+// BLOCKCHAIN_MINE, BLOCKCHAIN_MINE_INTERVAL, BLOCKCHAIN_MINE_WORKERS and BLOCKCHAIN_LOG_LEVEL.
+func (config *Config) ApplyEnv() error {
+	var errs []error
+
+	env := func(name string, apply func(value string) error) {
+		value, ok := os.LookupEnv(EnvPrefix + name)
+		if !ok {
+			return
+		}
+
+		if err := apply(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s%s: %v", EnvPrefix, name, err))
+		}
+	}
+
+	env("ID", intSetter(&config.ID))
+	env("LISTEN", stringSetter(&config.Listen))
+	env("API_LISTEN", stringSetter(&config.APIListen))
+	env("DATADIR", stringSetter(&config.DataDir))
+	env("GENESIS", stringSetter(&config.Genesis))
+	env("KEYSTORE", stringSetter(&config.Keystore))
+	env("PEERS", func(value string) error {
+		peers, err := ParsePeers(value)
+		if err == nil {
+			config.Peers = peers
+		}
+		return err
+	})
+	env("SEEDS", func(value string) error {
+		config.Seeds = ParseSeeds(value)
+		return nil
+	})
+	env("TARGET_PEERS", intSetter(&config.TargetPeers))
+	env("BLOCK_SIZE", intSetter(&config.BlockSize))
+	env("DIFFICULTY", intSetter(&config.Difficulty))
+	env("MINE", func(value string) error {
+		enabled, err := strconv.ParseBool(value)
+		if err == nil {
+			config.Mining.Enabled = enabled
+		}
+		return err
+	})
+	env("MINE_INTERVAL", func(value string) error {
+		interval, err := time.ParseDuration(value)
+		if err == nil {
+			config.Mining.Interval = interval
+		}
+		return err
+	})
+	env("MINE_WORKERS", intSetter(&config.Mining.Workers))
+	env("LOG_LEVEL", stringSetter(&config.LogLevel))
+
+	return errors.Join(errs...)
+}
+
+func stringSetter(field *string) func(string) error {
+	return func(value string) error {
+		*field = value
+		return nil
+	}
+}
+
+func intSetter(field *int) func(string) error {
+	return func(value string) error {
+		number, err := strconv.Atoi(value)
+		if err == nil {
+			*field = number
+		}
+		return err
+	}
+}
+
+// ParsePeers parses a list of peers in the form id=host:port,id=host:port.
+func ParsePeers(value string) ([]Peer, error) {
+	var peers []Peer
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, address, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("peer %q is not in the form id=host:port", entry)
+		}
+
+		number, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("peer %q does not have a numeric ID", entry)
+		}
+
+		peers = append(peers, Peer{ID: number, Address: address})
+	}
+
+	return peers, nil
+}
+
+// ParseSeeds parses a list of seed addresses in the form host:port,host:port.
+func ParseSeeds(value string) []string {
+	var seeds []string
+
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			seeds = append(seeds, entry)
+		}
+	}
+
+	return seeds
+}
+
+// Validate checks the whole config and returns every problem it finds.
+// Fills in the data directory (data/node<ID>) if it is not set.
+func (config *Config) Validate() error {
+	var errs []error
+
+	if config.ID < 0 {
+		errs = append(errs, fmt.Errorf("id: must not be negative, got %d", config.ID))
+	}
+
+	if err := checkAddress(config.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("listen: %v", err))
+	}
+
+	if config.APIListen != "" {
+		if err := checkAddress(config.APIListen); err != nil {
+			errs = append(errs, fmt.Errorf("api_listen: %v", err))
+		} else if config.APIListen == config.Listen {
+			errs = append(errs, errors.New("api_listen: must not be the listen address"))
+		}
+	}
+
+	if config.DataDir == "" {
+		config.DataDir = filepath.Join("data", "node"+strconv.Itoa(config.ID))
+	}
+
+	if config.Genesis == "" {
+		errs = append(errs, errors.New("genesis: must be set"))
+	}
+
+	if config.Keystore == "" {
+		errs = append(errs, errors.New("keystore: must be set"))
+	}
+
+	ids := map[int]bool{config.ID: true}
+	addresses := map[string]bool{config.Listen: true}
+	for i, peer := range config.Peers {
+		if ids[peer.ID] {
+			errs = append(errs, fmt.Errorf("peers[%d]: ID %d is used twice (or is the ID of this node)", i, peer.ID))
+		}
+		ids[peer.ID] = true
+
+		if err := checkAddress(peer.Address); err != nil {
+			errs = append(errs, fmt.Errorf("peers[%d]: %v", i, err))
+		} else if addresses[peer.Address] {
+			errs = append(errs, fmt.Errorf("peers[%d]: address %s is used twice (or is the listen address)", i, peer.Address))
+		}
+		addresses[peer.Address] = true
+	}
+
+	for i, seed := range config.Seeds {
+		if err := checkAddress(seed); err != nil {
+			errs = append(errs, fmt.Errorf("seeds[%d]: %v", i, err))
+		}
+	}
+
+	if config.TargetPeers < 0 {
+		errs = append(errs, fmt.Errorf("target_peers: must not be negative, got %d", config.TargetPeers))
+	}
+
+	if config.BlockSize < 1 || config.BlockSize > 1<<16 {
+		errs = append(errs, fmt.Errorf("block_size: must be between 1 and %d, got %d", 1<<16, config.BlockSize))
+	}
+
+	if config.Difficulty < 1 || config.Difficulty > blockchain.MaxGenesisDifficulty {
+		errs = append(errs, fmt.Errorf("difficulty: must be between 1 and %d, got %d", blockchain.MaxGenesisDifficulty, config.Difficulty))
+	}
+
+	if config.Mining.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("mining.interval: must be positive, got %v", config.Mining.Interval))
+	}
+
+	if config.Mining.Workers < 0 {
+		errs = append(errs, fmt.Errorf("mining.workers: must not be negative, got %d", config.Mining.Workers))
+	}
+
+	if !isLogLevel(config.LogLevel) {
+		errs = append(errs, fmt.Errorf("log_level: must be one of %s, got %q", strings.Join(LogLevels, ", "), config.LogLevel))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Checks that an address is in the form host:port.
+func checkAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("address %q is not in the form host:port", address)
+	}
+
+	if host == "" {
+		return fmt.Errorf("address %q has no host", address)
+	}
+
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return fmt.Errorf("address %q does not have a valid port", address)
+	}
+
+	return nil
+}
+
+// Checks if the level is one of LogLevels. Case does not matter, like in blockchain.ParseLogLevel.
+func isLogLevel(level string) bool {
+	for _, known := range LogLevels {
+		if strings.EqualFold(level, known) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes the YAML to a config file in a temporary directory and returns its path.
+func writeConfig(t *testing.T, yaml string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	config := Default()
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.DataDir != filepath.Join("data", "node0") {
+		t.Fatalf("data directory is %s, want data/node0", config.DataDir)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		want   string // part of the error, empty if the config is valid
+	}{
+		{"negative id", func(config *Config) { config.ID = -1 }, "id:"},
+		{"listen without port", func(config *Config) { config.Listen = "localhost" }, "listen:"},
+		{"listen port out of range", func(config *Config) { config.Listen = "localhost:70000" }, "listen:"},
+		{"api on the listen address", func(config *Config) { config.APIListen = config.Listen }, "api_listen:"},
+		{"api disabled", func(config *Config) { config.APIListen = "" }, ""},
+		{"no genesis", func(config *Config) { config.Genesis = "" }, "genesis:"},
+		{"no keystore", func(config *Config) { config.Keystore = "" }, "keystore:"},
+		{"peer id twice", func(config *Config) {
+			config.Peers = []Peer{{ID: 1, Address: "localhost:5001"}, {ID: 1, Address: "localhost:5002"}}
+		}, "peers[1]: ID 1"},
+		{"peer with the id of the node", func(config *Config) { config.Peers = []Peer{{ID: 0, Address: "localhost:5001"}} }, "peers[0]: ID 0"},
+		{"peer on the listen address", func(config *Config) { config.Peers = []Peer{{ID: 1, Address: config.Listen}} }, "peers[0]: address"},
+		{"invalid seed", func(config *Config) { config.Seeds = []string{"nowhere"} }, "seeds[0]:"},
+		{"negative target peers", func(config *Config) { config.TargetPeers = -1 }, "target_peers:"},
+		{"block size zero", func(config *Config) { config.BlockSize = 0 }, "block_size:"},
+		{"block size too large", func(config *Config) { config.BlockSize = 1<<16 + 1 }, "block_size:"},
+		{"block size one", func(config *Config) { config.BlockSize = 1 }, ""},
+		{"difficulty zero", func(config *Config) { config.Difficulty = 0 }, "difficulty:"},
+		{"difficulty too high", func(config *Config) { config.Difficulty = 255 }, "difficulty:"},
+		{"mining interval zero", func(config *Config) { config.Mining.Interval = 0 }, "mining.interval:"},
+		{"negative workers", func(config *Config) { config.Mining.Workers = -1 }, "mining.workers:"},
+		{"unknown log level", func(config *Config) { config.LogLevel = "loud" }, "log_level:"},
+		{"log level in capitals", func(config *Config) { config.LogLevel = "WARN" }, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := Default()
+			test.change(config)
+
+			err := config.Validate()
+			if test.want == "" {
+				if err != nil {
+					t.Fatalf("valid config is rejected: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("err = %v, want one about %q", err, test.want)
+			}
+		})
+	}
+}
+
+// Every problem of the config is reported at once.
+func TestValidateReportsAll(t *testing.T) {
+	config := Default()
+	config.ID = -1
+	config.BlockSize = 0
+	config.LogLevel = "loud"
+
+	err := config.Validate()
+	for _, want := range []string{"id:", "block_size:", "log_level:"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("err = %v, want one about %q", err, want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+id: 3
+listen: localhost:5003
+block_size: 5
+mining:
+  interval: 2s
+`)
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ID != 3 || config.Listen != "localhost:5003" || config.BlockSize != 5 || config.Mining.Interval != 2*time.Second {
+		t.Fatalf("config from the file is %+v", config)
+	}
+
+	// fields that are not in the file keep their default
+	defaults := Default()
+	if config.Difficulty != defaults.Difficulty || config.TargetPeers != defaults.TargetPeers || !config.Mining.Enabled {
+		t.Fatalf("missing fields did not keep their default: %+v", config)
+	}
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	if _, err := Load(writeConfig(t, "blocksize: 5\n")); err == nil {
+		t.Fatal("config with an unknown field was loaded")
+	}
+}
+
+// Environment variables override the config file.
+func TestApplyEnv(t *testing.T) {
+	config, err := Load(writeConfig(t, "block_size: 5\ntarget_peers: 3\nlisten: localhost:5003\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(EnvPrefix+"BLOCK_SIZE", "6")
+	t.Setenv(EnvPrefix+"KEYSTORE", "keys")
+	t.Setenv(EnvPrefix+"PEERS", "1=localhost:5001, 2=localhost:5002")
+	t.Setenv(EnvPrefix+"MINE", "false")
+	t.Setenv(EnvPrefix+"MINE_INTERVAL", "1m")
+
+	if err := config.ApplyEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.BlockSize != 6 || config.Keystore != "keys" || config.Mining.Enabled || config.Mining.Interval != time.Minute {
+		t.Fatalf("environment did not override the file: %+v", config)
+	}
+	if config.TargetPeers != 3 || config.Listen != "localhost:5003" {
+		t.Fatalf("fields without an environment variable lost the value of the file: %+v", config)
+	}
+	if len(config.Peers) != 2 || config.Peers[1].ID != 2 || config.Peers[1].Address != "localhost:5002" {
+		t.Fatalf("peers are %+v", config.Peers)
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	t.Setenv(EnvPrefix+"BLOCK_SIZE", "seven")
+	t.Setenv(EnvPrefix+"MINE", "maybe")
+	t.Setenv(EnvPrefix+"PEERS", "localhost:5001")
+
+	err := Default().ApplyEnv()
+	for _, name := range []string{"BLOCK_SIZE", "MINE", "PEERS"} {
+		if err == nil || !strings.Contains(err.Error(), EnvPrefix+name) {
+			t.Errorf("err = %v, want one about %s", err, EnvPrefix+name)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Lqvendar/blockchain/config"
+)
+
+// The config file is overridden by the environment, which is overridden by the flags.
+func TestLoadConfigOverrideOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.yaml")
+	yaml := "listen: localhost:5003\ntarget_peers: 3\nblock_size: 5\ndifficulty: 10\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("BLOCKCHAIN_TARGET_PEERS", "4")
+	t.Setenv("BLOCKCHAIN_BLOCK_SIZE", "6")
+
+	cfg := loadConfig([]string{"-config", path, "-block-size", "9"})
+
+	if cfg.Listen != "localhost:5003" || cfg.Difficulty != 10 {
+		t.Errorf("values only in the file were lost: listen %s, difficulty %d", cfg.Listen, cfg.Difficulty)
+	}
+	if cfg.TargetPeers != 4 {
+		t.Errorf("target peers is %d, want 4 from the environment", cfg.TargetPeers)
+	}
+	if cfg.BlockSize != 9 {
+		t.Errorf("block size is %d, want 9 from the flag", cfg.BlockSize)
+	}
+	if cfg.Mining.Interval != config.Default().Mining.Interval {
+		t.Errorf("mining interval is %v, want the default", cfg.Mining.Interval)
+	}
+}
@@ -3,3 +3,13 @@ module github.com/Lqvendar/blockchain
 go 1.20
 
 require github.com/cbergoon/merkletree v0.2.0
+
+require golang.org/x/crypto v0.14.0
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/gorilla/websocket v1.5.3
+
+require golang.org/x/term v0.14.0
+
+require golang.org/x/sys v0.14.0 // indirect
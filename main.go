@@ -3,120 +3,60 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-	"log"
-	"net/rpc"
 	"os"
 	"strconv"
-	"sync"
-	"time"
+	"strings"
 
-	blockchain "github.com/Lqvendar/blockchain/blockchain"
-	//"github.com/Lqvendar/blockchain/blockchain"
-	// connection "github.com/Lqvendar/blockchain/node"
+	"github.com/Lqvendar/blockchain/config"
 )
 
-func main() {
-	arguments := os.Args
-
-	myID, err := strconv.Atoi(arguments[1])
-	node := blockchain.MakeNode(myID)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = rpc.Register(node)
-	fmt.Println("Node " + strconv.Itoa(myID) + " up!")
-	if err != nil {
-		log.Fatal("error registering the RPCs\n", err)
-	}
-
-	node.ReadClusterConfig("nodes.txt")
-	// nodes connect now
-	node.ConnectNodes()
-
-	node.LocalChain = blockchain.NewBlockChain()
-
-	time.Sleep(3 * time.Second)
-
-	fmt.Println("\n--- Welcome to Blockchain! ---\n")
-
-	continueLoop := true
+const usage = `Usage: blockchain <command> [flags] [arguments]
 
-	for continueLoop {
-		var wg sync.WaitGroup
-		
-		fmt.Print("--------------------------------------\n\nWhat would you like to do?\n\n1. Send a transaction\n2. View hash of local chain\n\n-----\n\nType option: \n")
-		reader := bufio.NewScanner(os.Stdin)
-		reader.Scan()
-		option := reader.Text()
+Commands:
+  node run [flags] [ID]                 run a node until it is interrupted (the default without a command, see "node run -h")
+  tx send -to addr -data data           sign a transaction with a wallet account and send it to a running node
+  chain head                            print the root of the canonical chain of a running node
+  chain show <hash>                     print a block, or a transaction, that a running node knows
+  chain verify                          check every block of the canonical chain of a running node again
+  peers list                            print the addresses a running node knows and their state
+  wallet <command>                      manage the accounts transactions are sent from (see "wallet")
+  genesis init [flags]                  create the genesis file of a new chain
 
-		if option == "1" {
-			fmt.Println(">>> Enter recipient:")
-			reader.Scan()
-			recipient := reader.Text()
+Commands that talk to a running node use its API, given with -api host:port
+(default $BLOCKCHAIN_API_LISTEN, or ` + config.DefaultAPIListen + `). Flags go before the arguments.`
 
-			fmt.Println(">>> Type transaction data: ")
-			reader.Scan()
-			data := reader.Text()
-
-			if data == "" {
-				fmt.Println(">>> Data cannot be empty!")
-				fmt.Println()
-			} else {
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					fmt.Println(">>> Creating transaction!")
-					fmt.Println()
-					transaction := blockchain.MakeTransaction(node.GetSelfAddress(), recipient, time.Now().UnixNano(), data)
-
-					// Check if block list length is 1 (empty besides genesis) and create block if so
-					if node.LocalChain.GetBlockListLen() == 1 && node.Block == nil {
-						fmt.Println(">>> Creating main block!")
-						fmt.Println()
-						node.Block = blockchain.MakeBlock(node.LocalChain.GetRoot().GetHash())
-					}
-
-					node.Block.AddTransaction(*transaction, node.LocalChain, node)
-					hash, _ := transaction.CalculateHash()
-					fmt.Printf(">>> Added transaction to local chain! Hash: %x\n", hash)
-					fmt.Println(">>> Sent transaction to all nodes!")
-					fmt.Println()
-				}()
-
-				wg.Wait()
-			}
-		} else if option == "2" {
-			fmt.Printf("Current chain hash: %x", node.LocalChain.GetRoot().GetHash())
-			fmt.Println()
-		} else {
-			fmt.Println(">>> Invalid input! Please select one of the valid options.")
-			fmt.Println()
-		}
+func main() {
+	args := os.Args[1:]
 
-		fmt.Println("--------------------------------------")
-		fmt.Println("Would you like to continue? (y/n): ")
-		reader.Scan()
-		option = reader.Text()
+	// "go run .", "go run . <ID>" and "go run . -config ..." still start a node
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") || isNumber(args[0]) {
+		runNode(args)
+		return
+	}
 
-		if option == "n" {
-			fmt.Println("Exiting...")
-			continueLoop = false
-		}
+	switch args[0] {
+	case "node":
+		runNodeCommand(args[1:])
+	case "tx":
+		runTxCommand(args[1:])
+	case "chain":
+		runChainCommand(args[1:])
+	case "peers":
+		runPeersCommand(args[1:])
+	case "wallet":
+		runWalletCommand(args[1:])
+	case "genesis":
+		runGenesisCommand(args[1:])
+	case "help":
+		fmt.Println(usage)
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
 	}
 }
 
-// byteData := make([]byte, len(data))
-
-// for i := 0; i < len(data); i++ {
-// 	byteData[i] = data[i]
-// }
-
-// transaction := blockchain.Transaction{
-// 	Sender:    []byte("s" + strconv.Itoa(myID)),
-// 	Recipient: []byte("r" + strconv.Itoa(myID)),
-// 	Timestamp: time.Now().UnixNano(),
-// 	Data:      byteData,
-// }
+func isNumber(value string) bool {
+	_, err := strconv.Atoi(value)
+	return err == nil
+}
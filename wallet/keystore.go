@@ -0,0 +1,353 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	blockchain "github.com/Lqvendar/blockchain/blockchain"
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters used for new key files.
+// N = 2^15 takes about 100ms on a laptop, which is slow enough to make guessing passphrases expensive.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32 // AES-256
+
+	keyFileVersion = 1
+	keyFileSuffix  = ".json"
+)
+
+// Largest scrypt parameters accepted from a key file. A tampered file could otherwise ask for
+// gigabytes of memory or hours of work when it is unlocked.
+// N must also be above 1 and r and p at least 1, or scrypt divides by zero.
+const (
+	maxScryptN = 1 << 20
+	maxScryptR = 8
+	maxScryptP = 16
+)
+
+var (
+	ErrAccountNotFound = errors.New("account not found")
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupted key file")
+	ErrAccountExists   = errors.New("account already exists")
+	ErrInvalidAddress  = errors.New("not a valid address")
+)
+
+// Keystore keeps the keys of the accounts in a directory, one encrypted JSON file per account.
+// Keys are encrypted with AES-256-GCM, using a key derived from the passphrase with scrypt.
+type Keystore struct {
+	dir string
+}
+
+// Account is an address and the public key it belongs to.
+// The private key is only available after unlocking the account with its passphrase.
+type Account struct {
+	Address   string
+	PublicKey ed25519.PublicKey
+}
+
+// Contents of a key file.
+// Address		address of the account, also used as additional data of the encryption
+// PublicKey	hex public key, so accounts can be listed without the passphrase
+// Crypto		encrypted private key seed and the parameters to decrypt it
+type keyFile struct {
+	Version   int        `json:"version"`
+	Address   string     `json:"address"`
+	PublicKey string     `json:"publicKey"`
+	Crypto    cryptoJSON `json:"crypto"`
+}
+
+type cryptoJSON struct {
+	KDF        string     `json:"kdf"`
+	KDFParams  scryptJSON `json:"kdfParams"`
+	Cipher     string     `json:"cipher"`
+	Nonce      string     `json:"nonce"`
+	Ciphertext string     `json:"ciphertext"`
+}
+
+type scryptJSON struct {
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	KeyLen int    `json:"keyLen"`
+	Salt   string `json:"salt"`
+}
+
+// OpenKeystore opens (or creates) the keystore in the given directory.
+func OpenKeystore(dir string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &Keystore{dir: dir}, nil
+}
+
+// Path of the key file of an address.
+// Only valid addresses are accepted, so an address cannot point outside of the keystore directory.
+func (keystore *Keystore) keyPath(address string) (string, error) {
+	if !blockchain.IsValidAddress(address) {
+		return "", ErrInvalidAddress
+	}
+
+	return filepath.Join(keystore.dir, address+keyFileSuffix), nil
+}
+
+// NewAccount generates a new key and stores it encrypted with the passphrase.
+func (keystore *Keystore) NewAccount(passphrase string) (*Account, error) {
+	_, key, err := blockchain.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return keystore.storeKey(key, passphrase)
+}
+
+// Import stores an existing key, given as a hex encoded seed (see Export), encrypted with the passphrase.
+func (keystore *Keystore) Import(seedHex string, passphrase string) (*Account, error) {
+	seed, err := hex.DecodeString(strings.TrimSpace(seedHex))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("key must be a hex encoded 32 byte seed")
+	}
+
+	return keystore.storeKey(ed25519.NewKeyFromSeed(seed), passphrase)
+}
+
+// Export unlocks the account and returns its key as a hex encoded seed.
+func (keystore *Keystore) Export(address string, passphrase string) (string, error) {
+	key, err := keystore.Unlock(address, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key.Seed()), nil
+}
+
+// Accounts returns all the accounts in the keystore, sorted by address.
+func (keystore *Keystore) Accounts() ([]Account, error) {
+	matches, err := filepath.Glob(filepath.Join(keystore.dir, "*"+keyFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []Account
+	for _, match := range matches {
+		file, err := readKeyFile(match)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", match, err)
+		}
+
+		publicKey, err := hex.DecodeString(file.PublicKey)
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s: invalid public key", match)
+		}
+
+		accounts = append(accounts, Account{Address: file.Address, PublicKey: publicKey})
+	}
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Address < accounts[j].Address })
+
+	return accounts, nil
+}
+
+// Unlock decrypts the key of the account with the passphrase.
+func (keystore *Keystore) Unlock(address string, passphrase string) (ed25519.PrivateKey, error) {
+	path, err := keystore.keyPath(address)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := readKeyFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrAccountNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	seed, err := decryptSeed(file, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	key := ed25519.NewKeyFromSeed(seed)
+	if blockchain.AddressFromPublicKey(key.Public().(ed25519.PublicKey)) != address {
+		return nil, ErrWrongPassphrase
+	}
+
+	return key, nil
+}
+
+// Sign unlocks the account and creates a transaction from it, signed with its key.
+func (keystore *Keystore) Sign(address string, passphrase string, recipient string, timestamp int64, data string) (*blockchain.Transaction, error) {
+	key, err := keystore.Unlock(address, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockchain.MakeSignedTransaction(key, recipient, timestamp, data)
+}
+
+// Encrypts the key and writes it to a new key file.
+func (keystore *Keystore) storeKey(key ed25519.PrivateKey, passphrase string) (*Account, error) {
+	publicKey := key.Public().(ed25519.PublicKey)
+	account := &Account{
+		Address:   blockchain.AddressFromPublicKey(publicKey),
+		PublicKey: publicKey,
+	}
+
+	path, err := keystore.keyPath(account.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return nil, ErrAccountExists
+	}
+
+	file, err := encryptSeed(account, key.Seed(), passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// write to a temporary file first, so a crash cannot leave half a key file behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	return account, nil
+}
+
+func readKeyFile(path string) (*keyFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := new(keyFile)
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, err
+	}
+
+	if file.Version != keyFileVersion {
+		return nil, fmt.Errorf("unsupported key file version %d", file.Version)
+	}
+
+	return file, nil
+}
+
+// Encrypts the seed with AES-256-GCM under a key derived from the passphrase.
+func encryptSeed(account *Account, seed []byte, passphrase string) (*keyFile, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	params := scryptJSON{N: scryptN, R: scryptR, P: scryptP, KeyLen: scryptKeyLen, Salt: hex.EncodeToString(salt)}
+
+	gcm, err := newGCM(passphrase, salt, params)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	ciphertext := gcm.Seal(nil, nonce, seed, []byte(account.Address))
+
+	return &keyFile{
+		Version:   keyFileVersion,
+		Address:   account.Address,
+		PublicKey: hex.EncodeToString(account.PublicKey),
+		Crypto: cryptoJSON{
+			KDF:        "scrypt",
+			KDFParams:  params,
+			Cipher:     "aes-256-gcm",
+			Nonce:      hex.EncodeToString(nonce),
+			Ciphertext: hex.EncodeToString(ciphertext),
+		},
+	}, nil
+}
+
+// Decrypts the seed of a key file with the passphrase.
+func decryptSeed(file *keyFile, passphrase string) ([]byte, error) {
+	if file.Crypto.KDF != "scrypt" || file.Crypto.Cipher != "aes-256-gcm" {
+		return nil, fmt.Errorf("unsupported key encryption %s/%s", file.Crypto.KDF, file.Crypto.Cipher)
+	}
+
+	params := file.Crypto.KDFParams
+	if params.N <= 1 || params.N > maxScryptN || params.R < 1 || params.R > maxScryptR ||
+		params.P < 1 || params.P > maxScryptP || params.KeyLen != scryptKeyLen {
+		return nil, fmt.Errorf("unsupported scrypt parameters N=%d r=%d p=%d keyLen=%d", params.N, params.R, params.P, params.KeyLen)
+	}
+
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	nonce, err := hex.DecodeString(file.Crypto.Nonce)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	ciphertext, err := hex.DecodeString(file.Crypto.Ciphertext)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	gcm, err := newGCM(passphrase, salt, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != gcm.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+
+	seed, err := gcm.Open(nil, nonce, ciphertext, []byte(file.Address))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrWrongPassphrase
+	}
+
+	return seed, nil
+}
+
+// Derives the encryption key from the passphrase and returns an AES-GCM cipher for it.
+func newGCM(passphrase string, salt []byte, params scryptJSON) (cipher.AEAD, error) {
+	derived, err := scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, params.KeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,132 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// Opens a keystore in a temporary directory with one account, locked with "secret".
+func newTestKeystore(t *testing.T) (*Keystore, *Account) {
+	keystore, err := OpenKeystore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := keystore.NewAccount("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keystore, account
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	keystore, account := newTestKeystore(t)
+
+	accounts, err := keystore.Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].Address != account.Address {
+		t.Fatalf("accounts = %v, want only %s", accounts, account.Address)
+	}
+
+	key, err := keystore.Unlock(account.Address, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key.Public().(ed25519.PublicKey), account.PublicKey) {
+		t.Fatal("unlocked key does not belong to the account")
+	}
+
+	seed, err := keystore.Export(account.Address, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := OpenKeystore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := other.Import(seed, "other secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Address != account.Address {
+		t.Fatalf("imported address = %s, want %s", imported.Address, account.Address)
+	}
+
+	if _, err := other.Import(seed, "other secret"); !errors.Is(err, ErrAccountExists) {
+		t.Fatalf("importing twice: err = %v, want %v", err, ErrAccountExists)
+	}
+}
+
+func TestKeystoreUnlockErrors(t *testing.T) {
+	keystore, account := newTestKeystore(t)
+
+	if _, err := keystore.Unlock(account.Address, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong passphrase: err = %v, want %v", err, ErrWrongPassphrase)
+	}
+
+	other, err := OpenKeystore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Unlock(account.Address, "secret"); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("unknown account: err = %v, want %v", err, ErrAccountNotFound)
+	}
+
+	if _, err := keystore.Unlock("../../etc/passwd", "secret"); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("invalid address: err = %v, want %v", err, ErrInvalidAddress)
+	}
+}
+
+// Key files with scrypt parameters out of range are rejected before scrypt runs.
+func TestKeystoreTamperedParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(params *scryptJSON)
+	}{
+		{"p zero", func(params *scryptJSON) { params.P = 0 }},
+		{"r zero", func(params *scryptJSON) { params.R = 0 }},
+		{"n one", func(params *scryptJSON) { params.N = 1 }},
+		{"n negative", func(params *scryptJSON) { params.N = -1 }},
+		{"n too large", func(params *scryptJSON) { params.N = maxScryptN * 2 }},
+		{"r too large", func(params *scryptJSON) { params.R = maxScryptR + 1 }},
+		{"p too large", func(params *scryptJSON) { params.P = maxScryptP + 1 }},
+		{"key length", func(params *scryptJSON) { params.KeyLen = 16 }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keystore, account := newTestKeystore(t)
+
+			path, err := keystore.keyPath(account.Address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := readKeyFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.tamper(&file.Crypto.KDFParams)
+
+			data, err := json.Marshal(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := keystore.Unlock(account.Address, "secret"); err == nil {
+				t.Fatal("tampered key file was unlocked")
+			}
+		})
+	}
+}